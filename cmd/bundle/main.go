@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"content-rag-chat/internal/rag"
+)
+
+const usage = `usage: bundle <build|inspect|verify> [flags]
+
+  build    package chunks + vectors of one model into a bundle
+  inspect  print a bundle's manifest
+  verify   check checksums and every vector in a bundle`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "build":
+		err = build(os.Args[2:])
+	case "inspect":
+		err = inspect(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func build(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	chunksPath := fs.String("chunks", "./out/alicanteabout_chunks.json", "Path to chunks JSON or JSONL")
+	cachePath := fs.String("cache", "./out/embeddings_cache.json", "Path to embeddings cache JSON")
+	model := fs.String("model", "text-embedding-3-small", "Embeddings model to package")
+	dims := fs.Int("dims", 0, "Package the model@dims section when present (0 = full vectors)")
+	out := fs.String("out", "./out/index.bundle", "Bundle output path")
+	allowMissing := fs.Bool("allow-missing", false, "Build even if some chunks have no valid vector")
+	_ = fs.Parse(args)
+
+	chunks, err := rag.ReadChunks(*chunksPath)
+	if err != nil {
+		return err
+	}
+	corpusHash, err := rag.FileSHA256(*chunksPath)
+	if err != nil {
+		return err
+	}
+	cache, err := rag.LoadCache(*cachePath)
+	if err != nil {
+		return err
+	}
+	b, err := rag.NewBundle(chunks, cache, *model, *dims, corpusHash, *allowMissing)
+	if err != nil {
+		return err
+	}
+	if err := rag.WriteBundle(*out, b); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s: %d chunks, %d vectors, model %s, checksum %s\n", *out, b.Manifest.Chunks, b.Manifest.Vectors, b.Manifest.ModelKey, b.Manifest.Checksum[:12])
+	return nil
+}
+
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("inspect takes one bundle path")
+	}
+	b, err := rag.ReadBundle(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	m := b.Manifest
+	fmt.Printf("format:      %d\n", m.Format)
+	fmt.Printf("model:       %s (section %s, %d dims)\n", m.Model, m.ModelKey, m.Dims)
+	fmt.Printf("template:    %s\n", m.Template)
+	fmt.Printf("kinds:       %s\n", formatKinds(m.Kinds))
+	fmt.Printf("built at:    %s\n", m.BuiltAt)
+	fmt.Printf("corpus hash: %s\n", m.CorpusHash)
+	fmt.Printf("chunks:      %d\n", m.Chunks)
+	fmt.Printf("vectors:     %d\n", m.Vectors)
+	fmt.Printf("checksum:    %s\n", m.Checksum)
+	return nil
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	model := fs.String("model", "", "Fail unless the bundle was built for this model")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("verify takes one bundle path")
+	}
+	b, err := rag.ReadBundle(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *model != "" && b.Manifest.Model != *model {
+		return fmt.Errorf("bundle is for model %s, not %s", b.Manifest.Model, *model)
+	}
+	problems := rag.VerifyBundle(b)
+	for _, p := range problems {
+		fmt.Println("  " + p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("bundle %s failed verification (%d problems)", fs.Arg(0), len(problems))
+	}
+	fmt.Printf("OK %s (%s)\n", fs.Arg(0), b.Manifest.Checksum[:12])
+	return nil
+}
+
+func formatKinds(kinds map[string]int) string {
+	parts := make([]string, 0, len(kinds))
+	for k, v := range kinds {
+		parts = append(parts, fmt.Sprintf("%s@v%d", k, v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "ERROR:", err)
+	os.Exit(1)
+}
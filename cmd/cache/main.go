@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"content-rag-chat/internal/rag"
+)
+
+func main() {
+	chunksPath := flag.String("chunks", "./out/alicanteabout_chunks.json", "Path to chunks JSON or JSONL")
+	cachePath := flag.String("cache", "./out/embeddings_cache.json", "Path to embeddings cache JSON")
+	gc := flag.Bool("gc", false, "Remove vectors for chunks that no longer exist")
+	fix := flag.Bool("fix", false, "Remove invalid vectors (bad dim, NaN/Inf, zero, bad key) so they are re-embedded")
+	dropModels := flag.String("drop-model", "", "Remove all vectors of these models (comma-separated)")
+	dryRun := flag.Bool("dry-run", false, "Report what would change without writing the cache")
+	showIssues := flag.Int("show", 10, "Issues to list per model")
+	strict := flag.Bool("strict", false, "Exit non-zero when invalid or orphaned vectors remain")
+	flag.Parse()
+
+	chunks, err := rag.ReadChunks(*chunksPath)
+	if err != nil {
+		fatal(err)
+	}
+	cache, err := rag.LoadCache(*cachePath)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Loaded %d chunks, %d models in cache\n", len(chunks), len(cache.Models))
+
+	changed := false
+	for _, m := range strings.Split(*dropModels, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		if mv, ok := cache.Models[m]; ok {
+			fmt.Printf("Dropping model %s (%d items)\n", m, len(mv.Items))
+			delete(cache.Models, m)
+			changed = true
+		} else {
+			fmt.Printf("Model %s not in cache\n", m)
+		}
+	}
+
+	reports := rag.VerifyCache(cache, chunks)
+	for _, r := range reports {
+		printReport(r, *showIssues)
+	}
+
+	var prune []string
+	if *gc {
+		prune = append(prune, rag.ProblemOrphan)
+	}
+	if *fix {
+		prune = append(prune, rag.ProblemKey, rag.ProblemDim, rag.ProblemNaN, rag.ProblemZero)
+	}
+	if len(prune) > 0 {
+		n := rag.PruneCache(cache, reports, prune...)
+		fmt.Printf("\nRemoved %d items (%s)\n", n, strings.Join(prune, ", "))
+		changed = changed || n > 0
+	}
+
+	if changed {
+		if *dryRun {
+			fmt.Println("Dry run: cache not written")
+		} else {
+			if err := rag.SaveCache(*cachePath, cache); err != nil {
+				fatal(err)
+			}
+			fmt.Printf("Saved cache: %s\n", *cachePath)
+		}
+	}
+
+	if *strict && !*dryRun {
+		for _, r := range rag.VerifyCache(cache, chunks) {
+			for p, n := range r.Counts() {
+				if p != rag.ProblemStale && n > 0 {
+					fatal(fmt.Errorf("model %s has %d %s items", r.Model, n, p))
+				}
+			}
+		}
+	}
+}
+
+func printReport(r rag.ModelReport, show int) {
+	fmt.Printf("\nModel %s\n", r.Model)
+	fmt.Printf("  items: %d  dim: %d  template: %s\n", r.Items, r.Dim, orDash(r.Template))
+	kinds := make([]string, 0, len(r.Covered))
+	for k := range r.Covered {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	if len(kinds) == 0 {
+		fmt.Println("  coverage: none")
+	}
+	for _, k := range kinds {
+		fmt.Printf("  coverage %-8s %6.2f%% (%d/%d chunks)\n", k+":", 100*r.Coverage(k), r.Covered[k], r.Chunks)
+	}
+	if !r.HashChecked {
+		fmt.Println("  body hashes not checked: custom template")
+	}
+	counts := r.Counts()
+	if len(counts) == 0 {
+		fmt.Println("  issues: none")
+		return
+	}
+	problems := make([]string, 0, len(counts))
+	for p := range counts {
+		problems = append(problems, p)
+	}
+	sort.Strings(problems)
+	parts := make([]string, len(problems))
+	for i, p := range problems {
+		parts[i] = fmt.Sprintf("%s=%d", p, counts[p])
+	}
+	fmt.Printf("  issues: %s\n", strings.Join(parts, " "))
+	for i, is := range r.Issues {
+		if i >= show {
+			fmt.Printf("    … %d more\n", len(r.Issues)-show)
+			break
+		}
+		fmt.Printf("    %-9s %s\n", is.Problem, is.Key)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "ERROR:", err)
+	os.Exit(1)
+}
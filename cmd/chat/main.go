@@ -8,11 +8,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"content-rag-chat/internal/chat"
 	appconfig "content-rag-chat/internal/config"
-	"content-rag-chat/internal/rag"
 	"content-rag-chat/internal/storage"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -24,6 +25,9 @@ func main() {
 		log.Fatalf("load .env: %v", err)
 	}
 
+	if err := chat.CheckConfigEnv(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
 	cfg := chat.LoadConfigFromEnv()
 	chat.BindFlags(&cfg)
 	flag.Parse()
@@ -35,6 +39,9 @@ func main() {
 	if cfg.JWTSecret == "" {
 		log.Fatal("CHAT_JWT_SECRET is not set")
 	}
+	if err := chat.CheckLLMConfig(cfg); err != nil {
+		log.Fatalf("llm: %v", err)
+	}
 
 	var logger storage.Logger
 	db, err := openChatDB()
@@ -56,31 +63,59 @@ func main() {
 			})
 			async.Start(context.Background())
 			logger = async
-		} else {
-			_ = db.Close()
 		}
 	}
+	if cfg.SessionStore == "postgres" && db == nil {
+		log.Fatal("SESSION_STORE=postgres requires CHAT_DB_DSN")
+	}
+	if db != nil && cfg.DisableLogging && cfg.SessionStore != "postgres" {
+		_ = db.Close()
+	}
 
-	chunks, err := rag.ReadChunks(cfg.ChunksPath)
+	ix, err := chat.LoadIndex(cfg)
 	if err != nil {
-		log.Fatalf("load chunks: %v", err)
+		log.Fatal(err)
 	}
-	cache, err := rag.LoadCache(cfg.CachePath)
-	if err != nil {
-		log.Fatalf("load cache: %v", err)
+	if len(ix.Entries) == 0 {
+		log.Fatal("no embeddings loaded; ensure cache exists and matches embed model")
 	}
-	if cache.Model != "" && cache.Model != cfg.EmbedModel {
-		log.Printf("warning: cache model is %q but embed model is %q; only matching items will be used", cache.Model, cfg.EmbedModel)
+	if cfg.EmbedDims > 0 {
+		log.Printf("index dims=%d entries=%d vectors_mb=%.1f", cfg.EmbedDims, len(ix.Entries), float64(len(ix.Entries)*cfg.EmbedDims*4)/(1<<20))
 	}
+	log.Printf("index version=%s entries=%d", ix.Version, len(ix.Entries))
 
-	entries := rag.BuildIndex(chunks, cache, cfg.EmbedModel)
-	if len(entries) == 0 {
-		log.Fatal("no embeddings loaded; ensure cache exists and matches embed model")
+	srv := chat.NewServer(cfg, ix, &http.Client{Timeout: cfg.Timeout}, logger)
+	if cfg.GateCalibration != "" {
+		if err := srv.LoadCalibration(cfg.GateCalibration); err != nil {
+			log.Fatalf("load gate calibration: %v", err)
+		}
+	}
+	switch {
+	case cfg.SessionTTL <= 0:
+		srv.UseSessionStore(nil)
+	case cfg.SessionStore == "postgres":
+		srv.UseSessionStore(storage.NewPostgresSessionStore(db))
+	case cfg.SessionStore != "memory":
+		log.Fatalf("unknown SESSION_STORE %q (want memory or postgres)", cfg.SessionStore)
+	}
+	if cfg.SessionTTL > 0 {
+		go srv.RunSessionSweeper(context.Background(), cfg.SessionTTL/2)
+		log.Printf("sessions store=%s ttl=%s", cfg.SessionStore, cfg.SessionTTL)
 	}
-
-	srv := chat.NewServer(cfg, entries, &http.Client{Timeout: cfg.Timeout}, logger)
 	mux := chat.NewMux(srv)
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Printf("SIGHUP received; reloading index")
+			_ = srv.Reload()
+		}
+	}()
+	if cfg.IndexWatch > 0 {
+		go srv.WatchIndexFiles(context.Background(), cfg.IndexWatch)
+	}
+
 	log.Printf("listening on %s", cfg.Addr)
 	if err := http.ListenAndServe(cfg.Addr, mux); err != nil {
 		log.Fatal(err)
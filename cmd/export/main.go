@@ -22,6 +22,7 @@ type wpItem struct {
 	Slug        string `json:"slug"`
 	Link        string `json:"link"`
 	ModifiedGMT string `json:"modified_gmt"`
+	Categories  []int  `json:"categories"`
 	Title       struct {
 		Rendered string `json:"rendered"`
 	} `json:"title"`
@@ -31,13 +32,19 @@ type wpItem struct {
 }
 
 type doc struct {
-	ID          int    `json:"id"`
-	Type        string `json:"type"` // "post" | "page"
-	Slug        string `json:"slug"`
-	Title       string `json:"title"`
-	URL         string `json:"url"`
-	ModifiedGMT string `json:"modified_gmt"`
-	ContentText string `json:"content_text"`
+	ID          int      `json:"id"`
+	Type        string   `json:"type"` // "post" | "page"
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	ModifiedGMT string   `json:"modified_gmt"`
+	Categories  []string `json:"categories,omitempty"` // category names, for category: filters
+	ContentText string   `json:"content_text"`
+}
+
+type wpTerm struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
 
 var (
@@ -68,6 +75,13 @@ func main() {
 
 	var all []doc
 
+	fmt.Println("Fetching categories...")
+	categories, err := fetchCategories(client, *baseURL, *perPage)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("  -> %d categories\n", len(categories))
+
 	// WP endpoints: /wp-json/wp/v2/posts and /pages
 	for _, typ := range []struct {
 		endpoint string
@@ -94,6 +108,7 @@ func main() {
 				Title:       title,
 				URL:         it.Link,
 				ModifiedGMT: it.ModifiedGMT,
+				Categories:  categoryNames(it.Categories, categories),
 				ContentText: txt,
 			})
 		}
@@ -128,7 +143,7 @@ func fetchAll(client *http.Client, baseURL, endpoint string, perPage int, sleep
 	page := 1
 
 	for {
-		url := fmt.Sprintf("%s/wp-json/wp/v2/%s?per_page=%d&page=%d&_fields=id,slug,link,modified_gmt,title,content",
+		url := fmt.Sprintf("%s/wp-json/wp/v2/%s?per_page=%d&page=%d&_fields=id,slug,link,modified_gmt,categories,title,content",
 			strings.TrimRight(baseURL, "/"),
 			endpoint,
 			perPage,
@@ -163,31 +178,76 @@ func fetchAll(client *http.Client, baseURL, endpoint string, perPage int, sleep
 	return out, nil
 }
 
+// fetchCategories maps category IDs to their names.
+func fetchCategories(client *http.Client, baseURL string, perPage int) (map[int]string, error) {
+	out := map[int]string{}
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/wp-json/wp/v2/categories?per_page=%d&page=%d&_fields=id,name",
+			strings.TrimRight(baseURL, "/"),
+			perPage,
+			page,
+		)
+		var terms []wpTerm
+		status, err := getJSON(client, url, &terms)
+		if status == http.StatusBadRequest && page > 1 {
+			break // past the last page
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range terms {
+			out[t.ID] = htmlUnescape(t.Name)
+		}
+		if len(terms) < perPage {
+			break
+		}
+	}
+	return out, nil
+}
+
+// categoryNames resolves category IDs, sorted for stable output. Unknown
+// IDs are skipped.
+func categoryNames(ids []int, names map[int]string) []string {
+	var out []string
+	for _, id := range ids {
+		if name, ok := names[id]; ok && name != "" {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 func fetchPage(client *http.Client, url string) ([]wpItem, int, error) {
+	var items []wpItem
+	status, err := getJSON(client, url, &items)
+	return items, status, err
+}
+
+func getJSON(client *http.Client, url string, v any) (int, error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return nil, 0, err
+		return 0, err
 	}
 	req.Header.Set("User-Agent", "victorsesma-corpus-export/1.0")
 
 	res, err := client.Do(req)
 	if err != nil {
-		return nil, 0, err
+		return 0, err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		// read some body for debugging
 		b, _ := io.ReadAll(io.LimitReader(res.Body, 8_192))
-		return nil, res.StatusCode, fmt.Errorf("HTTP %d: %s\n%s", res.StatusCode, url, string(b))
+		return res.StatusCode, fmt.Errorf("HTTP %d: %s\n%s", res.StatusCode, url, string(b))
 	}
 
-	var items []wpItem
 	dec := json.NewDecoder(res.Body)
-	if err := dec.Decode(&items); err != nil {
-		return nil, res.StatusCode, err
+	if err := dec.Decode(v); err != nil {
+		return res.StatusCode, err
 	}
-	return items, res.StatusCode, nil
+	return res.StatusCode, nil
 }
 
 func writeJSON(path string, v any) error {
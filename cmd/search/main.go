@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +22,23 @@ func main() {
 	cachePath := flag.String("cache", "./out/embeddings_cache.json", "Path to embeddings cache JSON")
 	outPrompt := flag.Bool("prompt", true, "Print a ready-to-use prompt with sources after ranking")
 	topK := flag.Int("k", 5, "Top K chunks to retrieve")
+	candidates := flag.Int("candidates", 15, "Candidates retrieved before MMR selects top K")
+	mmrLambda := flag.Float64("mmr-lambda", 0.7, "MMR relevance/diversity trade-off in (0,1); 0 or 1 disables")
+	minScore := flag.Float64("min-score", 0.25, "Gate: decline below this score")
+	strongScore := flag.Float64("strong-score", 0.4, "Gate: answer at/above this score without further evidence")
+	minMargin := flag.Float64("min-margin", 0.05, "Gate: margin over runner-ups that supports answering below -strong-score")
+	calibrate := flag.String("calibrate", "", "Fit a gate calibration from labelled questions (JSONL of {question, expected, answerable}) and exit")
+	calibrationOut := flag.String("calibration-out", "./out/gate_calibration.json", "Where -calibrate writes the calibration")
+	freshness := flag.Float64("freshness-weight", 0.2, "Recency boost weight for time-sensitive questions (0 disables)")
+	expandMode := flag.String("expand-mode", "neighbors", "Expand hits for the prompt: none|neighbors|section|doc")
+	dedup := flag.Bool("dedup", true, "Collapse near-duplicate chunks into a canonical chunk")
+	dedupReport := flag.Bool("dedup-report", false, "Print near-duplicate groups and exit")
+	rewrite := flag.Bool("rewrite", true, "Rewrite place-name aliases before retrieval")
+	queryExpand := flag.Bool("query-expand", false, "Append related gazetteer terms to the query")
+	filterExpr := flag.String("filter", "", "Retrieval filter, e.g. \"type:post -slug:privacy-policy since:2024-01-01\"")
+	docTypes := flag.String("type", "", "Only include these doc types (comma-separated, e.g. post)")
+	urlPrefix := flag.String("url-prefix", "", "Only include URLs with this prefix")
+	since := flag.String("since", "", "Only include docs modified on/after this date (YYYY-MM-DD)")
 
 	// Embeddings config
 	provider := flag.String("provider", "openai", "Embeddings provider: openai (default)")
@@ -27,6 +46,14 @@ func main() {
 	batchSize := flag.Int("batch", 64, "Batch size for embedding requests")
 	timeout := flag.Duration("timeout", 30*time.Second, "HTTP timeout for embedding requests")
 	sleep := flag.Duration("sleep", 150*time.Millisecond, "Sleep between embedding requests (rate-limit friendly)")
+	templateName := flag.String("template", rag.DefaultEmbedTemplate, "Embedding input template: "+strings.Join(rag.EmbedTemplateNames(), "|"))
+	templateFile := flag.String("template-file", "", "Custom embedding input template (Go text/template over chunk fields); overrides -template")
+	templateVersion := flag.Int("template-version", 1, "Version of -template-file; bump to force a re-embed")
+	dims := flag.Int("dims", 0, "Reduce vectors to this many dimensions (0 = full; text-embedding-3 only)")
+	dimsSource := flag.String("dims-source", "truncate", "How -dims vectors are produced: truncate (from cached full vectors) or api (dimensions parameter, separate cache section)")
+	evalPath := flag.String("eval", "", "Run a retrieval eval (JSONL of {question, expected}) instead of the interactive loop")
+	evalDims := flag.String("eval-dims", "256,512,1536", "Dimensions to compare in -eval mode (0 = full)")
+	weightsFlag := flag.String("weights", rag.DefaultVectorWeights.String(), "Vector kind weights; kinds with weight > 0 are embedded (e.g. body=1,title=0.3,summary=0.3)")
 
 	flag.Parse()
 
@@ -34,6 +61,29 @@ func main() {
 		fatal(err)
 	}
 
+	filter, err := buildFilter(*filterExpr, *docTypes, *urlPrefix, *since)
+	if err != nil {
+		fatal(err)
+	}
+	if !filter.IsZero() {
+		fmt.Printf("Filter: %s\n", filter)
+	}
+	weights, err := rag.ParseVectorWeights(*weightsFlag)
+	if err != nil {
+		fatal(err)
+	}
+	tmpl, err := loadTemplate(*templateName, *templateFile, *templateVersion)
+	if err != nil {
+		fatal(err)
+	}
+	if *dimsSource != "truncate" && *dimsSource != "api" {
+		fatal(fmt.Errorf("-dims-source must be truncate or api"))
+	}
+	kinds := weights.Kinds()
+	if len(kinds) == 0 || kinds[0] != rag.KindBody {
+		fatal(fmt.Errorf("weights must include body > 0"))
+	}
+
 	// Load chunks
 	chunks, err := rag.ReadChunks(*chunksPath)
 	if err != nil {
@@ -46,11 +96,13 @@ func main() {
 	if err != nil {
 		fatal(err)
 	}
-	if cache.Items == nil {
-		cache.Items = map[string]rag.EmbedCacheItem{}
+	if mv := cache.Models[*model]; mv != nil && mv.Template != "" && mv.Template != tmpl.ID() {
+		fmt.Printf("⚠️ Cache was built with template %s; switching to %s re-embeds body vectors.\n", mv.Template, tmpl.ID())
+	} else if mv != nil && mv.Template == "" && len(mv.Items) > 0 {
+		fmt.Printf("⚠️ Cache predates embedding templates; its %d vectors are re-embedded once.\n", len(mv.Items))
 	}
-	if cache.Model != "" && cache.Model != *model {
-		fmt.Printf("⚠️ Cache model is %q but you selected %q. We'll keep cache but only reuse matching items.\n", cache.Model, *model)
+	if others := otherModels(cache, *model); len(others) > 0 {
+		fmt.Printf("Cache also holds vectors for: %s (kept untouched)\n", strings.Join(others, ", "))
 	}
 
 	// Prepare embedding client
@@ -62,23 +114,21 @@ func main() {
 
 	// Ensure embeddings exist for all chunks
 	ctx := context.Background()
-	needCount := 0
-	for _, ch := range chunks {
-		h := rag.TextHash(ch.Text)
-		item, ok := cache.Items[ch.ChunkID]
-		if ok && item.Hash == h && item.Dim > 0 && len(item.Vector) == item.Dim && cache.Model == *model {
-			continue
-		}
-		needCount++
+	embedOpts := rag.EmbedOptions{Kinds: kinds, Template: tmpl, BatchSize: *batchSize, Sleep: *sleep}
+	if *dimsSource == "api" {
+		embedOpts.Dims = *dims
+	}
+	needCount, err := rag.MissingEmbeddings(chunks, cache, *model, embedOpts)
+	if err != nil {
+		fatal(err)
 	}
 	fmt.Printf("Embeddings missing/outdated: %d\n", needCount)
 
 	if needCount > 0 {
 		fmt.Println("Generating embeddings (cached)…")
-		if err := rag.EmbedAll(ctx, client, *provider, apiKey, *model, chunks, cache, *batchSize, *sleep); err != nil {
+		if err := rag.EmbedAll(ctx, client, *provider, apiKey, *model, chunks, cache, embedOpts); err != nil {
 			fatal(err)
 		}
-		cache.Model = *model
 		if err := rag.SaveCache(*cachePath, cache); err != nil {
 			fatal(err)
 		}
@@ -86,8 +136,49 @@ func main() {
 	}
 
 	// Build in-memory embedding matrix (normalized)
-	entries := rag.BuildIndex(chunks, cache, *model)
-	fmt.Printf("Index ready: %d vectors (normalized)\n", len(entries))
+	docs := rag.NewDocIndex(chunks)
+	var rewriter *rag.QueryRewriter
+	if *rewrite {
+		rewriter = rag.NewQueryRewriter(rag.DefaultGazetteer, *queryExpand)
+	}
+
+	if *evalPath != "" {
+		if err := runEval(ctx, client, *provider, apiKey, *model, *evalPath, *evalDims, chunks, cache, rewriter, *topK, weights); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	entries := rag.BuildIndexDims(chunks, cache, *model, *dims)
+	fmt.Printf("Index ready: %d entries (normalized), vector kinds: %s\n", len(entries), strings.Join(kinds, ","))
+	if len(entries) < len(chunks) {
+		fmt.Printf("⚠️ %d chunks have no valid vector; run ./cmd/cache to inspect.\n", len(chunks)-len(entries))
+	}
+	if *dedupReport {
+		printDuplicates(rag.FindDuplicates(entries, rag.DefaultDedupOptions))
+		return
+	}
+	if *dedup {
+		var groups []rag.DuplicateGroup
+		before := len(entries)
+		opts := rag.DefaultDedupOptions
+		opts.Filter = filter
+		entries, groups = rag.CollapseDuplicates(entries, opts)
+		fmt.Printf("Collapsed %d near-duplicate chunks into %d canonical chunks\n", before-len(entries), len(groups))
+	}
+	lexical := rag.NewBM25(entryChunks(entries))
+	gate := rag.GatePolicy{MinScore: float32(*minScore), StrongScore: float32(*strongScore), MinMargin: float32(*minMargin), LexicalCheck: true}
+	gateSignals := func(query string, qVec []float32) rag.GateSignals {
+		k := max(*topK, *candidates)
+		return rag.ComputeGateSignals(query, rag.TopKSearchWeighted(entries, qVec, k, filter, weights), lexical.Search(query, k, filter))
+	}
+
+	if *calibrate != "" {
+		if err := runCalibrate(ctx, client, *provider, apiKey, *model, *dims, *calibrate, *calibrationOut, rewriter, gate, gateSignals); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
 	// Interactive search loop
 	reader := bufio.NewReader(os.Stdin)
@@ -103,14 +194,29 @@ func main() {
 			return
 		}
 
-		qVec, err := rag.EmbedQuery(ctx, client, *provider, apiKey, *model, q)
+		rw := rewriter.Rewrite(q)
+		if rw.Changed() {
+			fmt.Printf("Rewritten query: %s\n", rw.Text())
+		}
+
+		qVec, err := rag.EmbedQueryDims(ctx, client, *provider, apiKey, *model, *dims, rw.Text())
 		if err != nil {
 			fmt.Println("Embedding error:", err)
 			continue
 		}
-		rag.Normalize(qVec)
+		qVec = rag.TruncateVector(qVec, *dims)
 
-		results := rag.TopKSearch(entries, qVec, *topK)
+		results := rag.TopKSearchWeighted(entries, qVec, max(*topK, *candidates), filter, weights)
+		d := gate.Decide(gateSignals(rw.Text(), qVec))
+		fmt.Printf("Gate: answer=%t reason=%s top=%.4f margin=%.4f lexical_agree=%t\n", d.Answer, d.Reason, d.Signals.Top, d.Signals.Margin, d.Signals.LexicalAgree)
+		if *freshness > 0 && rag.IsTimeSensitive(rw.Text()) {
+			results = rag.ApplyFreshness(results, rag.Freshness{Weight: float32(*freshness), HalfLife: 365 * 24 * time.Hour})
+		}
+		if *mmrLambda > 0 && *mmrLambda < 1 {
+			results = rag.MMR(results, float32(*mmrLambda), *topK)
+		} else if len(results) > *topK {
+			results = results[:*topK]
+		}
 
 		fmt.Printf("\nTop %d results:\n", len(results))
 		for i, r := range results {
@@ -118,6 +224,10 @@ func main() {
 			fmt.Printf("Title: %s\n", r.Chunk.Title)
 			fmt.Printf("URL:   %s\n", r.Chunk.URL)
 			fmt.Printf("Slug:  %s\n", r.Chunk.Slug)
+			fmt.Printf("Modified: %s\n", r.Chunk.ModifiedGMT)
+			if len(r.Chunk.Alternates) > 0 {
+				fmt.Printf("Also at: %s\n", strings.Join(r.Chunk.Alternates, ", "))
+			}
 			preview := r.Chunk.Text
 			if len(preview) > 420 {
 				preview = preview[:420] + "…"
@@ -127,12 +237,145 @@ func main() {
 
 		if *outPrompt {
 			fmt.Println("\n--- Prompt (copy/paste) ---")
-			fmt.Println(rag.BuildPrompt(q, results))
+			fmt.Println(rag.BuildPrompt(q, docs.Expand(results, rag.ExpandOptions{Mode: *expandMode, Window: 1, MaxChars: 6000})))
 			fmt.Println("--- End prompt ---")
 		}
 	}
 }
 
+// buildFilter combines the -filter expression with the shorthand flags.
+func buildFilter(expr, docTypes, urlPrefix, since string) (rag.Filter, error) {
+	terms := []string{expr}
+	if docTypes != "" {
+		terms = append(terms, "type:"+docTypes)
+	}
+	if urlPrefix != "" {
+		terms = append(terms, "url:"+urlPrefix)
+	}
+	if since != "" {
+		terms = append(terms, "since:"+since)
+	}
+	return rag.ParseFilter(strings.Join(terms, " "))
+}
+
+// runEval embeds every eval question once at full size and compares
+// retrieval quality, memory and search time across the requested dims.
+func runEval(ctx context.Context, client *http.Client, provider, apiKey, model, path, dimsList string, chunks []rag.Chunk, cache *rag.EmbedCache, rewriter *rag.QueryRewriter, k int, weights rag.VectorWeights) error {
+	cases, err := rag.ReadEvalCases(path)
+	if err != nil {
+		return err
+	}
+	questions := make([]string, len(cases))
+	for i, c := range cases {
+		questions[i] = rewriter.Rewrite(c.Question).Text()
+	}
+	queries, err := rag.EmbedTexts(ctx, client, provider, apiKey, model, questions)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nEval: %d cases, k=%d\n", len(cases), k)
+	fmt.Printf("%6s %9s %7s %11s %10s\n", "dims", "recall@k", "mrr", "search_avg", "vectors_mb")
+	for _, f := range strings.Split(dimsList, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return fmt.Errorf("bad -eval-dims value %q", f)
+		}
+		entries := rag.BuildIndexDims(chunks, cache, model, d)
+		if len(entries) == 0 {
+			fmt.Printf("%6d  no vectors\n", d)
+			continue
+		}
+		dim := len(entries[0].Vec)
+		res := rag.EvalRetrieval(entries, cases, queries, k, weights)
+		fmt.Printf("%6d %9.3f %7.3f %11s %10.1f\n", dim, res.RecallAtK, res.MRR, res.SearchAvg.Round(time.Microsecond), float64(len(entries)*dim*4)/(1<<20))
+	}
+	return nil
+}
+
+func printDuplicates(groups []rag.DuplicateGroup) {
+	fmt.Printf("\n%d near-duplicate groups\n", len(groups))
+	for _, g := range groups {
+		fmt.Printf("\n%s  %s\n", g.Canonical.ChunkID, g.Canonical.URL)
+		for _, d := range g.Duplicates {
+			fmt.Printf("  = %s  %s\n", d.ChunkID, d.URL)
+		}
+	}
+}
+
+// runCalibrate computes gate signals for labelled questions, fits a
+// calibration and reports how the calibrated and rule-based gates compare.
+func runCalibrate(ctx context.Context, client *http.Client, provider, apiKey, model string, dims int, path, out string, rewriter *rag.QueryRewriter, gate rag.GatePolicy, signals func(string, []float32) rag.GateSignals) error {
+	cases, err := rag.ReadEvalCases(path)
+	if err != nil {
+		return err
+	}
+	queries := make([]string, len(cases))
+	for i, c := range cases {
+		queries[i] = rewriter.Rewrite(c.Question).Text()
+	}
+	vecs, err := rag.EmbedTextsDims(ctx, client, provider, apiKey, model, dims, queries)
+	if err != nil {
+		return err
+	}
+	sigs := make([]rag.GateSignals, len(cases))
+	labels := make([]bool, len(cases))
+	for i, c := range cases {
+		sigs[i] = signals(queries[i], rag.TruncateVector(vecs[i], dims))
+		labels[i] = c.IsAnswerable()
+	}
+	cal, err := rag.FitCalibration(sigs, labels, 0.5)
+	if err != nil {
+		return err
+	}
+	calibrated := gate
+	calibrated.Calibration = cal
+	var ruleOK, calOK int
+	for i := range sigs {
+		if gate.Decide(sigs[i]).Answer == labels[i] {
+			ruleOK++
+		}
+		if calibrated.Decide(sigs[i]).Answer == labels[i] {
+			calOK++
+		}
+	}
+	n := float64(len(sigs))
+	fmt.Printf("Calibration on %d questions: rule accuracy %.3f, calibrated accuracy %.3f\n", len(sigs), float64(ruleOK)/n, float64(calOK)/n)
+	if err := rag.SaveCalibration(out, cal); err != nil {
+		return err
+	}
+	fmt.Printf("Saved calibration: %s (use GATE_CALIBRATION=%s)\n", out, out)
+	return nil
+}
+
+func entryChunks(entries []rag.Entry) []rag.Chunk {
+	chunks := make([]rag.Chunk, len(entries))
+	for i, e := range entries {
+		chunks[i] = e.Chunk
+	}
+	return chunks
+}
+
+func otherModels(cache *rag.EmbedCache, model string) []string {
+	var out []string
+	for _, m := range cache.ModelNames() {
+		if m != model {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func loadTemplate(name, file string, version int) (rag.EmbedTemplate, error) {
+	if file == "" {
+		return rag.LookupEmbedTemplate(name)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return rag.EmbedTemplate{}, err
+	}
+	return rag.ParseEmbedTemplate(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), version, string(b))
+}
+
 func fatal(err error) {
 	fmt.Fprintln(os.Stderr, "ERROR:", err)
 	os.Exit(1)
@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"content-rag-chat/internal/rag"
+)
+
+const (
+	defaultAnthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+)
+
+// anthropicLLM talks to the Anthropic Messages API. System messages become
+// the top-level system prompt. JSON mode has no API switch, so the reply is
+// prefilled with "{" and the brace is restored on the way out.
+type anthropicLLM struct {
+	client    *http.Client
+	baseURL   string
+	apiKey    string
+	headers   map[string]string
+	maxTokens int
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float32            `json:"temperature"` // always sent: 0 is a real setting
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+const jsonPrefill = "{"
+
+func (a *anthropicLLM) wireRequest(req CompletionRequest, stream bool) anthropicRequest {
+	out := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   a.maxTokens,
+		Temperature: req.Temperature,
+		Stream:      stream,
+	}
+	var system []string
+	for _, m := range req.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: m.Role, Content: m.Content})
+	}
+	out.System = strings.Join(system, "\n\n")
+	if req.JSON {
+		out.Messages = append(out.Messages, anthropicMessage{Role: "assistant", Content: jsonPrefill})
+	}
+	return out
+}
+
+func (a *anthropicLLM) newRequest(ctx context.Context, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	for k, v := range a.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq, nil
+}
+
+func (a *anthropicLLM) Complete(ctx context.Context, creq CompletionRequest) (string, error) {
+	req := a.wireRequest(creq, false)
+	body, _ := json.Marshal(req)
+	reqID := rag.RequestID(ctx)
+	if reqID == "" {
+		reqID = "unknown"
+	}
+	log.Printf("req_id=%s anthropic chat request_bytes=%d model=%s messages=%d", reqID, len(body), req.Model, len(req.Messages))
+	start := time.Now()
+	httpReq, err := a.newRequest(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	res, err := a.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(res.Body, 10*1024*1024))
+	log.Printf("req_id=%s anthropic chat response_status=%d response_bytes=%d took=%s", reqID, res.StatusCode, len(raw), fmtDuration(time.Since(start)))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("anthropic http %d: %s", res.StatusCode, string(raw))
+	}
+	var out anthropicResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("parse anthropic response: %w", err)
+	}
+	if out.Error != nil {
+		return "", fmt.Errorf("anthropic error: %s (%s)", out.Error.Message, out.Error.Type)
+	}
+	var sb strings.Builder
+	if creq.JSON {
+		sb.WriteString(jsonPrefill)
+	}
+	for _, c := range out.Content {
+		if c.Type == "text" {
+			sb.WriteString(c.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("anthropic: empty content")
+	}
+	return sb.String(), nil
+}
+
+func (a *anthropicLLM) Stream(ctx context.Context, creq CompletionRequest, onDelta func(string)) error {
+	req := a.wireRequest(creq, true)
+	body, _ := json.Marshal(req)
+	reqID := rag.RequestID(ctx)
+	if reqID == "" {
+		reqID = "unknown"
+	}
+	log.Printf("req_id=%s anthropic chat stream request_bytes=%d model=%s messages=%d", reqID, len(body), req.Model, len(req.Messages))
+	start := time.Now()
+	httpReq, err := a.newRequest(ctx, body)
+	if err != nil {
+		return err
+	}
+	res, err := a.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(res.Body, 10*1024*1024))
+		return fmt.Errorf("anthropic http %d: %s", res.StatusCode, string(raw))
+	}
+
+	// The prefill goes out with the first real token: sent earlier, it would
+	// count as output and stop the chain from falling back on an error
+	// event that arrives before any content.
+	prefill := ""
+	if creq.JSON {
+		prefill = jsonPrefill
+	}
+	sc := bufio.NewScanner(res.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	var bytesRead int
+	for sc.Scan() {
+		line := sc.Text()
+		bytesRead += len(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &ev); err != nil {
+			continue
+		}
+		switch ev.Type {
+		case "content_block_delta":
+			if ev.Delta.Type == "text_delta" && ev.Delta.Text != "" && onDelta != nil {
+				onDelta(prefill + ev.Delta.Text)
+				prefill = ""
+			}
+		case "error":
+			if ev.Error != nil {
+				return fmt.Errorf("anthropic error: %s (%s)", ev.Error.Message, ev.Error.Type)
+			}
+			return fmt.Errorf("anthropic stream error")
+		case "message_stop":
+			log.Printf("req_id=%s anthropic chat stream bytes=%d took=%s", reqID, bytesRead, fmtDuration(time.Since(start)))
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	log.Printf("req_id=%s anthropic chat stream bytes=%d took=%s", reqID, bytesRead, fmtDuration(time.Since(start)))
+	return nil
+}
@@ -0,0 +1,172 @@
+package chat
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"content-rag-chat/internal/rag"
+)
+
+// citation ties a span of the answer to one returned source. Start and End
+// are offsets in Unicode code points into the answer text, so clients in
+// any language can slice the same span. Source is 1-based into sources.
+type citation struct {
+	Source  int    `json:"source"`
+	URL     string `json:"url"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Excerpt string `json:"excerpt,omitempty"`
+
+	claim string
+}
+
+// citationMarker matches "[2]" or "[1, 3]" plus the space before it.
+var citationMarker = regexp.MustCompile(`\s*\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+const citationExcerptChars = 300
+
+// applyCitations strips the [n] markers from answer and resolves them
+// against the prompt's numbered sources. Brackets with a number outside
+// 1..len(ordered), such as "[2024]", are not markers and stay in the text.
+// Cited sources are returned first, in order of first
+// citation, followed by the model's other valid picks, up to maxSources;
+// citations to sources past the cap are dropped. Offsets are filled in by
+// locateCitations once the answer text is final.
+func applyCitations(answer string, ordered []promptSource, picked []sourceItem, maxSources int) (string, []sourceItem, []citation) {
+	var clean strings.Builder
+	var cites []citation
+	var cited []string
+	citedSet := map[string]bool{}
+	last, claimFloor, claim := 0, 0, ""
+	for _, m := range citationMarker.FindAllStringSubmatchIndex(answer, -1) {
+		nums, ok := markerNumbers(answer[m[2]:m[3]], len(ordered))
+		if !ok {
+			continue
+		}
+		clean.WriteString(answer[last:m[0]])
+		last = m[1]
+		text := clean.String()
+		if strings.TrimSpace(text[claimFloor:]) != "" {
+			// Only new text starts a new claim: "[1][2]" cites one claim twice.
+			claim = strings.TrimSpace(text[max(claimFloor, sentenceStart(text)):])
+		}
+		claimFloor = len(text)
+		if claim == "" {
+			continue
+		}
+		for _, n := range nums {
+			src := ordered[n-1]
+			if !citedSet[src.URL] {
+				citedSet[src.URL] = true
+				cited = append(cited, src.URL)
+			}
+			cites = append(cites, citation{URL: src.URL, Excerpt: supportingExcerpt(claim, src.Excerpt), claim: claim})
+		}
+	}
+	clean.WriteString(answer[last:])
+
+	titles := map[string]string{}
+	for _, src := range ordered {
+		titles[src.URL] = src.Title
+	}
+	var sources []sourceItem
+	index := map[string]int{}
+	add := func(src sourceItem) {
+		if _, ok := index[src.URL]; ok || len(sources) >= maxSources {
+			return
+		}
+		index[src.URL] = len(sources) + 1
+		sources = append(sources, src)
+	}
+	for _, url := range cited {
+		add(sourceItem{Title: titles[url], URL: url})
+	}
+	for _, src := range filterSources(ordered, picked, maxSources) {
+		add(src)
+	}
+
+	kept := cites[:0]
+	for _, c := range cites {
+		if n, ok := index[c.URL]; ok {
+			c.Source = n
+			kept = append(kept, c)
+		}
+	}
+	return strings.TrimSpace(clean.String()), sources, kept
+}
+
+// markerNumbers parses the numbers of a citation marker. ok is false unless
+// every number is a source in 1..count.
+func markerNumbers(list string, count int) ([]int, bool) {
+	var nums []int
+	for _, num := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil || n < 1 || n > count {
+			return nil, false
+		}
+		nums = append(nums, n)
+	}
+	return nums, true
+}
+
+// sentenceStart returns the byte offset where the last sentence of text
+// begins, ignoring punctuation at the very end (a marker placed after the
+// full stop cites the sentence before it).
+func sentenceStart(text string) int {
+	body := strings.TrimRight(text, " .!?")
+	start := 0
+	for _, sep := range []string{". ", "! ", "? ", "\n"} {
+		if i := strings.LastIndex(body, sep); i >= 0 && i+len(sep) > start {
+			start = i + len(sep)
+		}
+	}
+	return start
+}
+
+// supportingExcerpt returns the sentence of excerpt that best supports claim.
+func supportingExcerpt(claim, excerpt string) string {
+	best, bestScore := "", 0.0
+	for _, sent := range rag.Sentences(excerpt) {
+		if score := rag.LexicalSupport(claim, sent); score > bestScore {
+			best, bestScore = sent, score
+		}
+	}
+	return rag.TruncateRunes(best, citationExcerptChars, "…")
+}
+
+// locateCitations sets the offsets of each citation's claim in answer, in
+// order. Citations whose claim is no longer in the answer (dropped by the
+// verifier) are removed.
+func locateCitations(answer string, cites []citation) []citation {
+	var out []citation
+	cursor := 0
+	for _, c := range cites {
+		i := strings.Index(answer[cursor:], c.claim)
+		if i < 0 {
+			continue
+		}
+		cursor += i
+		c.Start = utf8.RuneCountInString(answer[:cursor])
+		c.End = c.Start + utf8.RuneCountInString(c.claim)
+		out = append(out, c)
+	}
+	return out
+}
+
+// finishAnswer turns the model's raw answer and source picks into the
+// response: citations resolved, empty answers replaced by the fallback,
+// claims verified and citation offsets computed on the final text.
+func (s *Server) finishAnswer(ctx context.Context, answer string, picked []sourceItem, ordered []promptSource, model string) generated {
+	answer, sources, cites := applyCitations(strings.TrimSpace(answer), ordered, picked, s.cfg.MaxSources)
+	if answer == "" {
+		return generated{Answer: fallbackAnswer, Model: model}
+	}
+	g := s.verifyAnswer(ctx, generated{Answer: answer, Sources: sources, Model: model}, ordered)
+	if !isFallbackAnswer(g.Answer, g.Sources) {
+		g.Citations = locateCitations(g.Answer, cites)
+	}
+	return g
+}
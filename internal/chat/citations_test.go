@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestApplyCitations(t *testing.T) {
+	ordered := []promptSource{
+		{Title: "Tram", URL: "https://tram", Excerpt: "Line 1 goes to Benidorm."},
+		{Title: "Airport bus", URL: "https://bus", Excerpt: "The C6 bus runs every 20 minutes. Tickets cost 3.85 €."},
+		{Title: "Castle", URL: "https://castle", Excerpt: "The castle opens at 10:00."},
+	}
+	answer := "The C6 bus runs every 20 minutes [2]. Tickets cost 3.85 € [2][1]. The castle was restored [2024]."
+
+	clean, sources, cites := applyCitations(answer, ordered, []sourceItem{{URL: "https://castle"}}, 2)
+	// "[2024]" is not a source number, so it is text, not a marker.
+	if clean != "The C6 bus runs every 20 minutes. Tickets cost 3.85 €. The castle was restored [2024]." {
+		t.Fatalf("unexpected answer %q", clean)
+	}
+	// Cited sources come first; the uncited pick no longer fits.
+	if len(sources) != 2 || sources[0].URL != "https://bus" || sources[0].Title != "Airport bus" || sources[1].URL != "https://tram" {
+		t.Fatalf("unexpected sources %+v", sources)
+	}
+	cites = locateCitations(clean, cites)
+	if len(cites) != 3 {
+		t.Fatalf("expected 3 citations, got %+v", cites)
+	}
+	want := []struct{ source, start, end int }{{1, 0, 32}, {1, 34, 53}, {2, 34, 53}}
+	for i, w := range want {
+		if c := cites[i]; c.Source != w.source || c.Start != w.start || c.End != w.end {
+			t.Fatalf("citation %d: got %+v, want %+v", i, c, w)
+		}
+	}
+	if cites[1].Excerpt != "Tickets cost 3.85 €." {
+		t.Fatalf("unexpected excerpt %q", cites[1].Excerpt)
+	}
+	if got := string([]rune(clean)[cites[1].Start:cites[1].End]); got != "Tickets cost 3.85 €" {
+		t.Fatalf("offsets point at %q", got)
+	}
+}
+
+func TestLocateCitationsDropsRemovedClaims(t *testing.T) {
+	cites := []citation{{claim: "Gone."}, {claim: "Kept", Source: 1}}
+	got := locateCitations("Kept. Other.", cites)
+	if len(got) != 1 || got[0].Start != 0 || got[0].End != 4 {
+		t.Fatalf("unexpected citations %+v", got)
+	}
+}
+
+func TestGenerateAnswerCitations(t *testing.T) {
+	llm := &ScriptedLLM{Replies: []string{`{"answer":"Take the C6 bus [1]. It runs every 20 minutes [1].","sources":[{"title":"","url":"https://a"}]}`}}
+	srv := &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m"}, llm: llm}
+	hits := []rag.ScoredChunk{{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: "The C6 bus goes to the centre. It runs every 20 minutes."}, Score: 0.9}}
+
+	g, err := srv.generateAnswer(context.Background(), "How do I get to the centre?", nil, hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Answer != "Take the C6 bus. It runs every 20 minutes." || len(g.Sources) != 1 {
+		t.Fatalf("unexpected result %+v", g)
+	}
+	if len(g.Citations) != 2 || g.Citations[1].URL != "https://a" || g.Citations[1].Excerpt != "It runs every 20 minutes." {
+		t.Fatalf("unexpected citations %+v", g.Citations)
+	}
+}
@@ -2,21 +2,75 @@ package chat
 
 import (
 	"flag"
+	"fmt"
+	"log"
 	"os"
 	"strconv"
 	"time"
+
+	"content-rag-chat/internal/rag"
 )
 
 type Config struct {
 	Addr              string
 	ChunksPath        string
 	CachePath         string
+	BundlePath        string
 	Provider          string
 	EmbedModel        string
+	EmbedDims         int
 	ChatModel         string
+	LLMProvider       string
+	LLMBaseURL        string
+	LLMAPIKey         string
+	LLMOrg            string
+	LLMHeaders        string
+	LLMMaxTokens      int
+	LLMJSONSchema     bool
+	LLMScript         string
+	LLMFallbacks      string
+	LLMAttemptTimeout time.Duration
+	LLMBreakerFails   int
+	LLMBreakerCool    time.Duration
+	DegradedAnswers   bool
+	Extractive        bool
+	ExtractiveMinConf float32
+	Verify            string
+	VerifyModel       string
+	VerifyMinSupport  float32
 	TopK              int
 	MaxSources        int
 	MinScore          float32
+	GateStrongScore   float32
+	GateMinMargin     float32
+	GateLexical       bool
+	GateCalibration   string
+	QueryRewrite      bool
+	QueryExpand       bool
+	SearchFilter      rag.Filter
+	SearchCandidates  int
+	VectorWeights     rag.VectorWeights
+	MMRLambda         float32
+	Dedup             bool
+	DedupMinSim       float32
+	DedupMinJaccard   float32
+	FreshnessWeight   float32
+	FreshnessHalfLife time.Duration
+	ExpandMode        string
+	ExpandWindow      int
+	ExpandMaxChars    int
+	ContextMaxTokens  int
+	Reranker          string
+	RerankModel       string
+	RerankTopN        int
+	RerankMinScore    float32
+	HistoryMaxTurns   int
+	HistoryMaxTokens  int
+	Condense          bool
+	CondenseModel     string
+	SessionStore      string
+	SessionTTL        time.Duration
+	SessionMax        int
 	CORSAllowedOrigin string
 	RateLimit         int
 	RateWindow        time.Duration
@@ -31,6 +85,8 @@ type Config struct {
 	LogFlushEvery     time.Duration
 	LogReportEvery    time.Duration
 	DisableLogging    bool
+	AdminToken        string
+	IndexWatch        time.Duration
 }
 
 func DefaultConfig() Config {
@@ -41,9 +97,42 @@ func DefaultConfig() Config {
 		Provider:          "openai",
 		EmbedModel:        "text-embedding-3-small",
 		ChatModel:         "gpt-4o-mini",
+		LLMProvider:       LLMOpenAI,
+		LLMMaxTokens:      1024,
+		LLMAttemptTimeout: 20 * time.Second,
+		LLMBreakerFails:   3,
+		LLMBreakerCool:    30 * time.Second,
+		DegradedAnswers:   true,
+		ExtractiveMinConf: 0.75,
+		VerifyMinSupport:  0.5,
 		TopK:              3,
 		MaxSources:        2,
 		MinScore:          0.25,
+		GateStrongScore:   0.4,
+		GateMinMargin:     0.05,
+		GateLexical:       true,
+		QueryRewrite:      true,
+		SearchCandidates:  10,
+		VectorWeights:     rag.DefaultVectorWeights,
+		MMRLambda:         0.7,
+		Dedup:             true,
+		DedupMinSim:       rag.DefaultDedupOptions.MinSim,
+		DedupMinJaccard:   float32(rag.DefaultDedupOptions.MinJaccard),
+		FreshnessWeight:   0.2,
+		FreshnessHalfLife: 365 * 24 * time.Hour,
+		ExpandMode:        rag.ExpandNeighbors,
+		ExpandWindow:      1,
+		ExpandMaxChars:    6000,
+		ContextMaxTokens:  3000,
+		Reranker:          "none",
+		RerankTopN:        8,
+		RerankMinScore:    0.3,
+		HistoryMaxTurns:   6,
+		HistoryMaxTokens:  800,
+		Condense:          true,
+		SessionStore:      "memory",
+		SessionTTL:        30 * time.Minute,
+		SessionMax:        10000,
 		CORSAllowedOrigin: envString("CORS_ALLOWED_ORIGIN", "https://alicanteabout.com"),
 		RateLimit:         30,
 		RateWindow:        1 * time.Minute,
@@ -65,12 +154,62 @@ func LoadConfigFromEnv() Config {
 		Addr:              envString("ADDR", def.Addr),
 		ChunksPath:        envString("CHUNKS_PATH", def.ChunksPath),
 		CachePath:         envString("CACHE_PATH", def.CachePath),
+		BundlePath:        envString("INDEX_BUNDLE", def.BundlePath),
 		Provider:          envString("EMBED_PROVIDER", def.Provider),
 		EmbedModel:        envString("EMBED_MODEL", def.EmbedModel),
+		EmbedDims:         envInt("EMBED_DIMS", def.EmbedDims),
 		ChatModel:         envString("CHAT_MODEL", def.ChatModel),
+		LLMProvider:       envString("LLM_PROVIDER", def.LLMProvider),
+		LLMBaseURL:        envString("LLM_BASE_URL", def.LLMBaseURL),
+		LLMAPIKey:         envString("LLM_API_KEY", def.LLMAPIKey),
+		LLMOrg:            envString("LLM_ORG", def.LLMOrg),
+		LLMHeaders:        envString("LLM_HEADERS", def.LLMHeaders),
+		LLMMaxTokens:      envInt("LLM_MAX_TOKENS", def.LLMMaxTokens),
+		LLMJSONSchema:     envBool("LLM_JSON_SCHEMA", def.LLMJSONSchema),
+		LLMScript:         envString("LLM_SCRIPT", def.LLMScript),
+		LLMFallbacks:      envString("LLM_FALLBACKS", def.LLMFallbacks),
+		LLMAttemptTimeout: envDuration("LLM_ATTEMPT_TIMEOUT", def.LLMAttemptTimeout),
+		LLMBreakerFails:   envInt("LLM_BREAKER_FAILURES", def.LLMBreakerFails),
+		LLMBreakerCool:    envDuration("LLM_BREAKER_COOLDOWN", def.LLMBreakerCool),
+		DegradedAnswers:   envBool("DEGRADED_ANSWERS", def.DegradedAnswers),
+		Extractive:        envBool("EXTRACTIVE", def.Extractive),
+		ExtractiveMinConf: envFloat32("EXTRACTIVE_MIN_CONFIDENCE", def.ExtractiveMinConf),
+		Verify:            envString("VERIFY", def.Verify),
+		VerifyModel:       envString("VERIFY_MODEL", def.VerifyModel),
+		VerifyMinSupport:  envFloat32("VERIFY_MIN_SUPPORT", def.VerifyMinSupport),
 		TopK:              envInt("TOP_K", def.TopK),
 		MaxSources:        envInt("MAX_SOURCES", def.MaxSources),
 		MinScore:          envFloat32("MIN_SCORE", def.MinScore),
+		GateStrongScore:   envFloat32("GATE_STRONG_SCORE", def.GateStrongScore),
+		GateMinMargin:     envFloat32("GATE_MIN_MARGIN", def.GateMinMargin),
+		GateLexical:       envBool("GATE_LEXICAL", def.GateLexical),
+		GateCalibration:   envString("GATE_CALIBRATION", def.GateCalibration),
+		QueryRewrite:      envBool("QUERY_REWRITE", def.QueryRewrite),
+		QueryExpand:       envBool("QUERY_EXPAND", def.QueryExpand),
+		SearchFilter:      envFilter("SEARCH_FILTER", def.SearchFilter),
+		SearchCandidates:  envInt("SEARCH_CANDIDATES", def.SearchCandidates),
+		VectorWeights:     envVectorWeights("VECTOR_WEIGHTS", def.VectorWeights),
+		MMRLambda:         envFloat32("MMR_LAMBDA", def.MMRLambda),
+		Dedup:             envBool("DEDUP", def.Dedup),
+		DedupMinSim:       envFloat32("DEDUP_MIN_SIM", def.DedupMinSim),
+		DedupMinJaccard:   envFloat32("DEDUP_MIN_JACCARD", def.DedupMinJaccard),
+		FreshnessWeight:   envFloat32("FRESHNESS_WEIGHT", def.FreshnessWeight),
+		FreshnessHalfLife: envDuration("FRESHNESS_HALF_LIFE", def.FreshnessHalfLife),
+		ExpandMode:        envString("EXPAND_MODE", def.ExpandMode),
+		ExpandWindow:      envInt("EXPAND_WINDOW", def.ExpandWindow),
+		ExpandMaxChars:    envInt("EXPAND_MAX_CHARS", def.ExpandMaxChars),
+		ContextMaxTokens:  envInt("CONTEXT_MAX_TOKENS", def.ContextMaxTokens),
+		Reranker:          envString("RERANKER", def.Reranker),
+		RerankModel:       envString("RERANK_MODEL", def.RerankModel),
+		RerankTopN:        envInt("RERANK_TOP_N", def.RerankTopN),
+		RerankMinScore:    envFloat32("RERANK_MIN_SCORE", def.RerankMinScore),
+		HistoryMaxTurns:   envInt("HISTORY_MAX_TURNS", def.HistoryMaxTurns),
+		HistoryMaxTokens:  envInt("HISTORY_MAX_TOKENS", def.HistoryMaxTokens),
+		Condense:          envBool("CONDENSE", def.Condense),
+		CondenseModel:     envString("CONDENSE_MODEL", def.CondenseModel),
+		SessionStore:      envString("SESSION_STORE", def.SessionStore),
+		SessionTTL:        envDuration("SESSION_TTL", def.SessionTTL),
+		SessionMax:        envInt("SESSION_MAX", def.SessionMax),
 		CORSAllowedOrigin: envString("CORS_ALLOWED_ORIGIN", def.CORSAllowedOrigin),
 		RateLimit:         envInt("RATE_LIMIT", def.RateLimit),
 		RateWindow:        envDuration("RATE_WINDOW", def.RateWindow),
@@ -85,6 +224,8 @@ func LoadConfigFromEnv() Config {
 		LogFlushEvery:     envDuration("CHAT_LOG_FLUSH_EVERY", def.LogFlushEvery),
 		LogReportEvery:    envDuration("CHAT_LOG_REPORT_EVERY", def.LogReportEvery),
 		DisableLogging:    envBool("CHAT_LOG_DISABLE", def.DisableLogging),
+		AdminToken:        envString("CHAT_ADMIN_TOKEN", def.AdminToken),
+		IndexWatch:        envDuration("INDEX_WATCH", def.IndexWatch),
 	}
 }
 
@@ -92,12 +233,61 @@ func BindFlags(cfg *Config) {
 	flag.StringVar(&cfg.Addr, "addr", cfg.Addr, "HTTP listen address")
 	flag.StringVar(&cfg.ChunksPath, "chunks", cfg.ChunksPath, "Path to chunks JSON or JSONL")
 	flag.StringVar(&cfg.CachePath, "cache", cfg.CachePath, "Path to embeddings cache JSON")
+	flag.StringVar(&cfg.BundlePath, "bundle", cfg.BundlePath, "Load the index from a bundle (overrides -chunks/-cache)")
 	flag.StringVar(&cfg.Provider, "provider", cfg.Provider, "Embeddings provider: openai")
 	flag.StringVar(&cfg.EmbedModel, "embed-model", cfg.EmbedModel, "Embeddings model")
+	flag.IntVar(&cfg.EmbedDims, "embed-dims", cfg.EmbedDims, "Reduce embeddings to this many dimensions (0 = full)")
 	flag.StringVar(&cfg.ChatModel, "chat-model", cfg.ChatModel, "Chat model")
+	flag.StringVar(&cfg.LLMProvider, "llm", cfg.LLMProvider, "Chat completion provider: openai|anthropic|fake")
+	flag.StringVar(&cfg.LLMBaseURL, "llm-base-url", cfg.LLMBaseURL, "Base URL of an OpenAI-compatible (or Anthropic) API")
+	flag.StringVar(&cfg.LLMOrg, "llm-org", cfg.LLMOrg, "OpenAI organization header")
+	flag.StringVar(&cfg.LLMHeaders, "llm-headers", cfg.LLMHeaders, "Extra LLM request headers, e.g. \"X-Title=AlicanteAbout,HTTP-Referer=https://alicanteabout.com\"")
+	flag.IntVar(&cfg.LLMMaxTokens, "llm-max-tokens", cfg.LLMMaxTokens, "Max output tokens (Anthropic)")
+	flag.BoolVar(&cfg.LLMJSONSchema, "llm-json-schema", cfg.LLMJSONSchema, "Request strict JSON schema output (json_schema) from OpenAI-compatible servers that support it")
+	flag.StringVar(&cfg.LLMScript, "llm-script", cfg.LLMScript, "JSON array of canned replies for -llm fake")
+	flag.StringVar(&cfg.LLMFallbacks, "llm-fallbacks", cfg.LLMFallbacks, "Ordered fallback models after the chat model, e.g. \"openai:gpt-4o,anthropic:claude-3-5-haiku-latest\"")
+	flag.DurationVar(&cfg.LLMAttemptTimeout, "llm-attempt-timeout", cfg.LLMAttemptTimeout, "Timeout per model attempt; for streams, the wait for the first token (0 = request timeout only)")
+	flag.IntVar(&cfg.LLMBreakerFails, "llm-breaker-failures", cfg.LLMBreakerFails, "Consecutive failures that open a provider's circuit breaker (0 disables)")
+	flag.DurationVar(&cfg.LLMBreakerCool, "llm-breaker-cooldown", cfg.LLMBreakerCool, "How long an open breaker skips its provider")
+	flag.BoolVar(&cfg.DegradedAnswers, "degraded-answers", cfg.DegradedAnswers, "Return top sources with snippets when generation or embedding fails")
+	flag.BoolVar(&cfg.Extractive, "extractive", cfg.Extractive, "Answer simple lookups (price, hours, distance) with source sentences, without the chat model")
+	flag.Var(float32Value{v: &cfg.ExtractiveMinConf}, "extractive-min-confidence", "Min extractive confidence (0-1); below it the chat model answers")
+	flag.StringVar(&cfg.Verify, "verify", cfg.Verify, "Verify answers against sources: lexical, llm or empty to disable")
+	flag.StringVar(&cfg.VerifyModel, "verify-model", cfg.VerifyModel, "Chat model for -verify llm (default: chat model)")
+	flag.Var(float32Value{v: &cfg.VerifyMinSupport}, "verify-min-support", "Min share of supported claims (0-1); below it the fallback answer is returned")
 	flag.IntVar(&cfg.TopK, "k", cfg.TopK, "Top K chunks to retrieve")
 	flag.IntVar(&cfg.MaxSources, "max-sources", cfg.MaxSources, "Max sources to return")
 	flag.Var(float32Value{v: &cfg.MinScore}, "min-score", "Min cosine score to answer")
+	flag.Var(float32Value{v: &cfg.GateStrongScore}, "gate-strong-score", "Score at which no further evidence is needed to answer")
+	flag.Var(float32Value{v: &cfg.GateMinMargin}, "gate-min-margin", "Margin over runner-up hits that supports answering below the strong score")
+	flag.BoolVar(&cfg.GateLexical, "gate-lexical", cfg.GateLexical, "Accept lexical (BM25) agreement as support below the strong score")
+	flag.StringVar(&cfg.GateCalibration, "gate-calibration", cfg.GateCalibration, "Calibration JSON (from cmd/search -calibrate) deciding between min and strong score")
+	flag.BoolVar(&cfg.QueryRewrite, "query-rewrite", cfg.QueryRewrite, "Rewrite place-name aliases (Alacant, El Altet, ALC...) before retrieval")
+	flag.BoolVar(&cfg.QueryExpand, "query-expand", cfg.QueryExpand, "Append related gazetteer terms to the retrieval query")
+	flag.IntVar(&cfg.SearchCandidates, "candidates", cfg.SearchCandidates, "Candidates retrieved before MMR selects top K")
+	flag.Var(weightsValue{v: &cfg.VectorWeights}, "vector-weights", "Weights per vector kind, e.g. body=1,title=0.3,summary=0.3")
+	flag.Var(float32Value{v: &cfg.MMRLambda}, "mmr-lambda", "MMR relevance/diversity trade-off in (0,1); 0 or 1 disables")
+	flag.BoolVar(&cfg.Dedup, "dedup", cfg.Dedup, "Collapse near-duplicate chunks into a canonical chunk at startup")
+	flag.Var(float32Value{v: &cfg.DedupMinSim}, "dedup-min-sim", "Minimum vector similarity for near-duplicates")
+	flag.Var(float32Value{v: &cfg.DedupMinJaccard}, "dedup-min-jaccard", "Minimum shingle overlap for near-duplicates")
+	flag.Var(float32Value{v: &cfg.FreshnessWeight}, "freshness-weight", "Share of score subject to recency decay for time-sensitive questions (0 disables)")
+	flag.DurationVar(&cfg.FreshnessHalfLife, "freshness-half-life", cfg.FreshnessHalfLife, "Age at which the recency factor halves")
+	flag.StringVar(&cfg.ExpandMode, "expand-mode", cfg.ExpandMode, "Hit expansion before prompting: none|neighbors|section|doc")
+	flag.IntVar(&cfg.ExpandWindow, "expand-window", cfg.ExpandWindow, "Adjacent chunks per side in neighbors mode")
+	flag.IntVar(&cfg.ExpandMaxChars, "expand-max-chars", cfg.ExpandMaxChars, "Character budget per expanded hit")
+	flag.IntVar(&cfg.ContextMaxTokens, "context-max-tokens", cfg.ContextMaxTokens, "Estimated token budget for source excerpts in the prompt (0 disables trimming)")
+	flag.StringVar(&cfg.Reranker, "reranker", cfg.Reranker, "Second-stage reranker: none|llm")
+	flag.StringVar(&cfg.RerankModel, "rerank-model", cfg.RerankModel, "Chat model used by the llm reranker (default: chat model)")
+	flag.IntVar(&cfg.RerankTopN, "rerank-top-n", cfg.RerankTopN, "Candidates passed to the reranker")
+	flag.Var(float32Value{v: &cfg.RerankMinScore}, "rerank-min-score", "Min reranked score (0-1) to answer")
+	flag.IntVar(&cfg.HistoryMaxTurns, "history-max-turns", cfg.HistoryMaxTurns, "Prior turns kept for follow-up questions (0 disables history)")
+	flag.IntVar(&cfg.HistoryMaxTokens, "history-max-tokens", cfg.HistoryMaxTokens, "Estimated token budget for prior turns")
+	flag.BoolVar(&cfg.Condense, "condense", cfg.Condense, "Condense history + follow-up into a standalone retrieval query")
+	flag.StringVar(&cfg.CondenseModel, "condense-model", cfg.CondenseModel, "Chat model used to condense follow-ups (default: chat model)")
+	flag.StringVar(&cfg.SessionStore, "session-store", cfg.SessionStore, "Conversation session store: memory|postgres (postgres needs CHAT_DB_DSN)")
+	flag.DurationVar(&cfg.SessionTTL, "session-ttl", cfg.SessionTTL, "Idle time before a conversation session expires (0 disables sessions)")
+	flag.IntVar(&cfg.SessionMax, "session-max", cfg.SessionMax, "Max sessions held by the memory store")
+	flag.Var(filterValue{v: &cfg.SearchFilter}, "search-filter", "Default retrieval filter, e.g. \"-slug:privacy-policy,contact type:post\"")
 	flag.StringVar(&cfg.CORSAllowedOrigin, "cors-origin", cfg.CORSAllowedOrigin, "Allowed CORS origin")
 	flag.IntVar(&cfg.RateLimit, "rate", cfg.RateLimit, "Requests per window per IP")
 	flag.DurationVar(&cfg.RateWindow, "window", cfg.RateWindow, "Rate limit window")
@@ -112,6 +302,7 @@ func BindFlags(cfg *Config) {
 	flag.DurationVar(&cfg.LogFlushEvery, "log-flush", cfg.LogFlushEvery, "Chat log flush interval")
 	flag.DurationVar(&cfg.LogReportEvery, "log-report", cfg.LogReportEvery, "Chat log report interval")
 	flag.BoolVar(&cfg.DisableLogging, "log-disable", cfg.DisableLogging, "Disable chat logging")
+	flag.DurationVar(&cfg.IndexWatch, "index-watch", cfg.IndexWatch, "Poll chunk/cache files and hot-reload on change (0 disables)")
 }
 
 type float32Value struct {
@@ -134,6 +325,46 @@ func (f float32Value) Set(value string) error {
 	return nil
 }
 
+type filterValue struct {
+	v *rag.Filter
+}
+
+func (f filterValue) String() string {
+	if f.v == nil {
+		return ""
+	}
+	return f.v.String()
+}
+
+func (f filterValue) Set(value string) error {
+	parsed, err := rag.ParseFilter(value)
+	if err != nil {
+		return err
+	}
+	*f.v = parsed
+	return nil
+}
+
+type weightsValue struct {
+	v *rag.VectorWeights
+}
+
+func (w weightsValue) String() string {
+	if w.v == nil {
+		return ""
+	}
+	return w.v.String()
+}
+
+func (w weightsValue) Set(value string) error {
+	parsed, err := rag.ParseVectorWeights(value)
+	if err != nil {
+		return err
+	}
+	*w.v = parsed
+	return nil
+}
+
 func envString(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -161,6 +392,41 @@ func envFloat32(key string, def float32) float32 {
 	return def
 }
 
+// envFilter logs a malformed filter instead of dropping it quietly;
+// CheckConfigEnv turns the same error into a startup failure.
+func envFilter(key string, def rag.Filter) rag.Filter {
+	if v := os.Getenv(key); v != "" {
+		f, err := rag.ParseFilter(v)
+		if err == nil {
+			return f
+		}
+		log.Printf("config %s error=%q", key, err.Error())
+	}
+	return def
+}
+
+// CheckConfigEnv reports environment settings that must not fall back to a
+// default when malformed. A bad SEARCH_FILTER would otherwise serve the
+// content the operator meant to exclude.
+func CheckConfigEnv() error {
+	if v := os.Getenv("SEARCH_FILTER"); v != "" {
+		if _, err := rag.ParseFilter(v); err != nil {
+			return fmt.Errorf("SEARCH_FILTER: %w", err)
+		}
+	}
+	return nil
+}
+
+func envVectorWeights(key string, def rag.VectorWeights) rag.VectorWeights {
+	if v := os.Getenv(key); v != "" {
+		w, err := rag.ParseVectorWeights(v)
+		if err == nil {
+			return w
+		}
+	}
+	return def
+}
+
 func envDuration(key string, def time.Duration) time.Duration {
 	if v := os.Getenv(key); v != "" {
 		d, err := time.ParseDuration(v)
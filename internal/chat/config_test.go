@@ -0,0 +1,18 @@
+package chat
+
+import "testing"
+
+func TestCheckConfigEnvRejectsBadFilter(t *testing.T) {
+	t.Setenv("SEARCH_FILTER", "-slug:privacy-policy nonsense")
+	if err := CheckConfigEnv(); err == nil {
+		t.Fatalf("expected an error for a malformed SEARCH_FILTER")
+	}
+
+	t.Setenv("SEARCH_FILTER", "-slug:privacy-policy,contact type:post")
+	if err := CheckConfigEnv(); err != nil {
+		t.Fatalf("valid filter rejected: %v", err)
+	}
+	if len(LoadConfigFromEnv().SearchFilter.ExcludeSlugs) != 2 {
+		t.Fatalf("valid filter not loaded")
+	}
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"content-rag-chat/internal/rag"
+	"content-rag-chat/internal/storage"
+)
+
+// Answer types stored in chat_logs.answer_type and returned as answer_type.
+const (
+	answerGrounded    = "grounded"
+	answerNoAnswer    = "no_answer"
+	answerSearchOnly  = "search_only"  // retrieval worked, generation did not
+	answerLexicalOnly = "lexical_only" // embedding failed, BM25 only
+)
+
+const degradedAnswer = "I can't write a full answer right now, but these AlicanteAbout guides look most relevant:"
+
+const (
+	snippetTokens = 60
+	// lexicalMinCoverage is the share of query terms the top BM25 hit must
+	// contain before lexical-only results are shown; BM25 scores themselves
+	// are not comparable across queries.
+	lexicalMinCoverage = 0.5
+)
+
+// errGenerationUnavailable marks a streaming failure before any delta was
+// sent, so the handler can still answer in degraded mode.
+var errGenerationUnavailable = errors.New("generation unavailable")
+
+// degradedSources returns up to max distinct sources with an extractive
+// snippet of the passage that best matches query.
+func degradedSources(query string, hits []rag.ScoredChunk, max int) []sourceItem {
+	out := make([]sourceItem, 0, max)
+	seen := map[string]bool{}
+	for _, h := range hits {
+		if len(out) >= max {
+			break
+		}
+		if h.Chunk.URL == "" || seen[h.Chunk.URL] {
+			continue
+		}
+		seen[h.Chunk.URL] = true
+		out = append(out, sourceItem{Title: h.Chunk.Title, URL: h.Chunk.URL, Snippet: snippet(query, h.Chunk.Text)})
+	}
+	return out
+}
+
+// snippet keeps the paragraphs of text that best match query, flattened to
+// one line.
+func snippet(query, text string) string {
+	sel := rag.SelectPassages(query, text, snippetTokens)
+	s := strings.Join(strings.Fields(sel.Text), " ")
+	s = strings.TrimPrefix(s, "… ")
+	if r := []rune(s); len(r) > snippetTokens*4 {
+		s = strings.TrimSpace(string(r[:snippetTokens*4])) + "…"
+	}
+	return s
+}
+
+// lexicalHits searches the BM25 index and drops the results unless the top
+// hit covers enough of the query.
+func lexicalHits(ix *Index, query string, k int, filter rag.Filter) []rag.ScoredChunk {
+	hits := ix.Lexical.Search(query, k, filter)
+	if len(hits) == 0 || termCoverage(query, hits[0].Chunk) < lexicalMinCoverage {
+		return nil
+	}
+	return hits
+}
+
+func termCoverage(query string, ch rag.Chunk) float64 {
+	qTerms := map[string]bool{}
+	for _, t := range rag.Terms(query) {
+		qTerms[t] = false
+	}
+	if len(qTerms) == 0 {
+		return 0
+	}
+	matched := 0
+	for _, t := range rag.Terms(ch.Title + "\n" + ch.Text) {
+		if seen, ok := qTerms[t]; ok && !seen {
+			qTerms[t] = true
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(qTerms))
+}
+
+// writeDegraded answers with the top sources and snippets instead of a
+// generated answer. answerType is answerSearchOnly or answerLexicalOnly;
+// gate is nil on the lexical path.
+func (s *Server) writeDegraded(ctx context.Context, w http.ResponseWriter, r *http.Request, req chatRequest, sess *storage.Session, query string, hits []rag.ScoredChunk, answerType string, gate *rag.GateDecision, start time.Time) {
+	sources := degradedSources(query, hits, s.cfg.MaxSources)
+	if len(sources) == 0 {
+		s.writeNoAnswer(ctx, w, req, sess, hits, gate, start)
+		return
+	}
+	resp := chatResponse{
+		Answer:         degradedAnswer,
+		Sources:        sources,
+		ConversationID: req.ConversationID,
+		AnswerType:     answerType,
+	}
+	if wantsStream(r) {
+		writeStreamResult(w, resp)
+	} else {
+		writeJSON(w, resp)
+	}
+	s.rememberTurn(ctx, sess, req.Question, degradedAnswer, hits)
+	s.logChat(ctx, req.Question, answerType, generated{}, hits, gate, start)
+	log.Printf("req_id=%s chat done=%s degraded=%s sources=%d", rag.RequestID(ctx), fmtDuration(time.Since(start)), answerType, len(sources))
+}
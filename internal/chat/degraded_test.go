@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+const airportText = "Getting there\nThe C6 bus runs from the airport to the city centre every 20 minutes and costs 3.85 euros.\nTaxis wait outside arrivals."
+
+func degradedTestServer(logger *recordingLogger) *Server {
+	srv := &Server{
+		cfg:    Config{TopK: 3, MaxSources: 2, MinScore: 0.1, DegradedAnswers: true},
+		logger: logger,
+		llm:    &ScriptedLLM{Err: errors.New("429 quota exceeded")},
+		embedFunc: func(ctx context.Context, question string) ([]float32, error) {
+			return []float32{1, 0}, nil
+		},
+	}
+	srv.index.Store(NewIndex(Config{}, "", []rag.Entry{
+		{Chunk: rag.Chunk{ChunkID: "a", DocID: 1, Title: "Airport bus", URL: "https://a", Text: airportText}, Vec: []float32{1, 0}},
+		{Chunk: rag.Chunk{ChunkID: "b", DocID: 2, Title: "Beaches", URL: "https://b", Text: "Postiguet beach is next to the old town."}, Vec: []float32{0, 1}},
+	}))
+	return srv
+}
+
+func TestSearchOnlyWhenGenerationFails(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := degradedTestServer(logger)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewBufferString(`{"question":"airport bus to the centre","lang":"en"}`))
+	srv.handleChat(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var out chatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.AnswerType != answerSearchOnly || out.Answer != degradedAnswer {
+		t.Fatalf("unexpected response %+v", out)
+	}
+	if len(out.Sources) == 0 || out.Sources[0].URL != "https://a" || !strings.Contains(out.Sources[0].Snippet, "C6 bus") {
+		t.Fatalf("unexpected sources %+v", out.Sources)
+	}
+	if len(logger.records) != 1 || logger.records[0].AnswerType != answerSearchOnly || logger.records[0].GateReason == "" {
+		t.Fatalf("unexpected log %+v", logger.records)
+	}
+}
+
+func TestSearchOnlyWhenStreamFailsBeforeDeltas(t *testing.T) {
+	srv := degradedTestServer(&recordingLogger{})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/chat?stream=1", bytes.NewBufferString(`{"question":"airport bus to the centre","lang":"en"}`))
+	srv.handleChat(rec, req)
+
+	if strings.Contains(rec.Body.String(), "event: error") {
+		t.Fatalf("unexpected error event: %s", rec.Body.String())
+	}
+	out := parseSSEData(t, rec.Body.String())
+	if out.AnswerType != answerSearchOnly || len(out.Sources) == 0 {
+		t.Fatalf("unexpected result %+v", out)
+	}
+}
+
+func TestLexicalOnlyWhenEmbeddingFails(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := degradedTestServer(logger)
+	srv.embedFunc = func(ctx context.Context, question string) ([]float32, error) {
+		return nil, errors.New("embedding api down")
+	}
+
+	post := func(question string) chatResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		body, _ := json.Marshal(chatRequest{Question: question, Lang: "en"})
+		srv.handleChat(rec, httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+		var out chatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	out := post("airport bus price")
+	if out.AnswerType != answerLexicalOnly || len(out.Sources) != 1 || out.Sources[0].URL != "https://a" || out.Sources[0].Snippet == "" {
+		t.Fatalf("unexpected response %+v", out)
+	}
+	if logger.records[0].AnswerType != answerLexicalOnly || logger.records[0].GateReason != "" {
+		t.Fatalf("unexpected log %+v", logger.records[0])
+	}
+
+	// One weak keyword match is not enough to show results.
+	out = post("best paella restaurant near the airport")
+	if out.AnswerType != answerNoAnswer || len(out.Sources) != 0 {
+		t.Fatalf("expected no answer, got %+v", out)
+	}
+}
+
+func TestDegradedAnswersDisabled(t *testing.T) {
+	srv := degradedTestServer(&recordingLogger{})
+	srv.cfg.DegradedAnswers = false
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewBufferString(`{"question":"airport bus to the centre","lang":"en"}`))
+	srv.handleChat(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+}
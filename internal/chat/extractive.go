@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"content-rag-chat/internal/rag"
+)
+
+// extractiveModel is recorded as the model of answers copied from sources.
+const extractiveModel = "extractive"
+
+const extractiveMaxSpans = 2
+
+// extractiveAnswer answers simple lookups (price, opening hours, distance)
+// with sentences copied verbatim from the hits, skipping the chat model.
+// ok is false when extraction is disabled or below ExtractiveMinConf.
+func (s *Server) extractiveAnswer(ctx context.Context, question string, hits []rag.ScoredChunk) (generated, bool) {
+	if !s.cfg.Extractive {
+		return generated{}, false
+	}
+	ex := rag.Extract(question, hits, extractiveMaxSpans)
+	if ex.Kind == "" {
+		return generated{}, false
+	}
+	ok := len(ex.Spans) > 0 && ex.Confidence >= float64(s.cfg.ExtractiveMinConf)
+	log.Printf("req_id=%s chat extractive kind=%s spans=%d confidence=%.3f used=%t", rag.RequestID(ctx), ex.Kind, len(ex.Spans), ex.Confidence, ok)
+	if !ok {
+		return generated{}, false
+	}
+	var answer strings.Builder
+	var sources []sourceItem
+	var cites []citation
+	index := map[string]int{}
+	for _, sp := range ex.Spans {
+		n, ok := index[sp.Chunk.URL]
+		if !ok {
+			if len(sources) >= s.cfg.MaxSources {
+				// Every span must be attributable to a returned source.
+				continue
+			}
+			sources = append(sources, sourceItem{Title: sp.Chunk.Title, URL: sp.Chunk.URL})
+			n = len(sources)
+			index[sp.Chunk.URL] = n
+		}
+		if answer.Len() > 0 {
+			answer.WriteString(" ")
+		}
+		start := utf8.RuneCountInString(answer.String())
+		answer.WriteString(sp.Text)
+		cites = append(cites, citation{Source: n, URL: sp.Chunk.URL, Start: start, End: start + utf8.RuneCountInString(sp.Text), Excerpt: sp.Text})
+	}
+	if answer.Len() == 0 {
+		return generated{}, false
+	}
+	return generated{Answer: answer.String(), Sources: sources, Citations: cites, Model: extractiveModel}, true
+}
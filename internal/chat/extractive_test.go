@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractiveAnswerSkipsModel(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := degradedTestServer(logger)
+	llm := &ScriptedLLM{Replies: []string{`{"answer":"From the model.","sources":[{"title":"Airport bus","url":"https://a"}]}`}}
+	srv.llm = llm
+	srv.cfg.Extractive = true
+	srv.cfg.ExtractiveMinConf = 0.75
+
+	post := func(question string) chatResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		body, _ := json.Marshal(chatRequest{Question: question, Lang: "en"})
+		srv.handleChat(rec, httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewReader(body)))
+		var out chatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	out := post("How much is the C6 bus from the airport?")
+	want := "The C6 bus runs from the airport to the city centre every 20 minutes and costs 3.85 euros."
+	if out.Answer != want || out.Model != extractiveModel || len(out.Sources) != 1 || out.Sources[0].URL != "https://a" {
+		t.Fatalf("unexpected response %+v", out)
+	}
+	if len(llm.Calls) != 0 {
+		t.Fatalf("extractive answer should not call the model")
+	}
+	if logger.records[0].Model != extractiveModel || logger.records[0].AnswerType != answerGrounded {
+		t.Fatalf("unexpected log %+v", logger.records[0])
+	}
+
+	// Not a lookup: the model answers.
+	out = post("Is the airport bus comfortable for families?")
+	if out.Answer != "From the model." || len(llm.Calls) != 1 {
+		t.Fatalf("expected a generated answer, got %+v", out)
+	}
+}
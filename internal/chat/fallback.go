@@ -0,0 +1,317 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"content-rag-chat/internal/rag"
+)
+
+// errBreakerOpen is returned without calling a provider whose breaker is open.
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// breaker is a consecutive-failure circuit breaker. After maxFailures
+// failures in a row it opens for cooldown. Once the cool-down has passed it
+// is half-open: a single trial call is let through while every other call
+// is still refused, and a failed trial reopens it straight away.
+type breaker struct {
+	mu          sync.Mutex
+	maxFailures int
+	cooldown    time.Duration
+	failures    int
+	openUntil   time.Time
+	tripped     bool
+	trial       bool // a half-open trial call is in flight
+	now         func() time.Time
+}
+
+func newBreaker(maxFailures int, cooldown time.Duration) *breaker {
+	return &breaker{maxFailures: maxFailures, cooldown: cooldown, now: time.Now}
+}
+
+func (b *breaker) allow() bool {
+	if b == nil || b.maxFailures <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.now().Before(b.openUntil) {
+		return false
+	}
+	if !b.tripped {
+		return true
+	}
+	if b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *breaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.tripped, b.trial = 0, false, false
+}
+
+// abandon ends a call that neither succeeded nor failed (the client went
+// away), so a half-open breaker admits the next trial.
+func (b *breaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// failure records a failed call and reports whether the breaker opened.
+func (b *breaker) failure() bool {
+	if b == nil || b.maxFailures <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.tripped || b.failures >= b.maxFailures {
+		b.openUntil = b.now().Add(b.cooldown)
+		b.failures, b.tripped = 0, true
+		return true
+	}
+	return false
+}
+
+// llmRoute is one entry of the fallback chain: a model on a provider.
+// Routes on the same provider share its LLM and breaker.
+type llmRoute struct {
+	Provider string
+	Model    string
+	llm      LLM
+	breaker  *breaker
+}
+
+func (r llmRoute) String() string { return r.Provider + ":" + r.Model }
+
+// llmChain tries each route in order until one answers. Each attempt gets
+// its own timeout; failures feed the provider's breaker and open breakers
+// are skipped, so an outage costs one timeout per cool-down, not per request.
+type llmChain struct {
+	routes         []llmRoute
+	attemptTimeout time.Duration
+}
+
+// parseLLMRoutes parses "provider:model,provider:model".
+func parseLLMRoutes(s string) ([][2]string, error) {
+	var out [][2]string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		provider, model, ok := strings.Cut(part, ":")
+		provider, model = strings.TrimSpace(provider), strings.TrimSpace(model)
+		if !ok || provider == "" || model == "" {
+			return nil, fmt.Errorf("llm fallbacks: bad entry %q (want provider:model)", part)
+		}
+		out = append(out, [2]string{provider, model})
+	}
+	return out, nil
+}
+
+// newLLMChain builds the primary route (LLMProvider + ChatModel) followed
+// by LLMFallbacks. The primary provider's LLM is returned as well, wrapped
+// in its breaker, for auxiliary calls (rerank, condense).
+func newLLMChain(cfg Config, client *http.Client) (*llmChain, LLM, error) {
+	fallbacks, err := parseLLMRoutes(cfg.LLMFallbacks)
+	if err != nil {
+		return nil, nil, err
+	}
+	primary := cfg.LLMProvider
+	if primary == "" {
+		primary = LLMOpenAI
+	}
+	targets := append([][2]string{{primary, cfg.ChatModel}}, fallbacks...)
+
+	type backend struct {
+		llm LLM
+		br  *breaker
+	}
+	backends := map[string]backend{}
+	chain := &llmChain{attemptTimeout: cfg.LLMAttemptTimeout}
+	for _, t := range targets {
+		b, ok := backends[t[0]]
+		if !ok {
+			pcfg := cfg
+			pcfg.LLMProvider = t[0]
+			if t[0] != primary {
+				// Base URL, key and headers configure the primary provider only.
+				pcfg.LLMBaseURL, pcfg.LLMAPIKey, pcfg.LLMOrg, pcfg.LLMHeaders = "", "", "", ""
+			}
+			llm, err := NewLLM(pcfg, client)
+			if err != nil {
+				return nil, nil, fmt.Errorf("llm %s: %w", t[0], err)
+			}
+			b = backend{llm: llm, br: newBreaker(cfg.LLMBreakerFails, cfg.LLMBreakerCool)}
+			backends[t[0]] = b
+		}
+		chain.routes = append(chain.routes, llmRoute{Provider: t[0], Model: t[1], llm: b.llm, breaker: b.br})
+	}
+	p := backends[primary]
+	return chain, breakerLLM{llm: p.llm, br: p.br}, nil
+}
+
+// singleRoute is a chain of just the primary model on llm, without a breaker.
+func singleRoute(cfg Config, llm LLM) *llmChain {
+	provider := cfg.LLMProvider
+	if provider == "" {
+		provider = LLMOpenAI
+	}
+	return &llmChain{routes: []llmRoute{{Provider: provider, Model: cfg.ChatModel, llm: llm}}}
+}
+
+// CheckLLMConfig reports whether the provider and fallback settings build.
+func CheckLLMConfig(cfg Config) error {
+	_, _, err := newLLMChain(cfg, nil)
+	return err
+}
+
+// Complete returns the first successful reply and the route that produced it.
+func (c *llmChain) Complete(ctx context.Context, req CompletionRequest) (string, llmRoute, error) {
+	var out string
+	route, err := c.try(ctx, req, c.attemptTimeout, func(ctx context.Context, r llmRoute, req CompletionRequest) error {
+		var err error
+		out, err = r.llm.Complete(ctx, req)
+		return err
+	})
+	return out, route, err
+}
+
+// Stream falls back only until the first delta has been forwarded; after
+// that a failure is returned, since the client already holds partial text.
+// The attempt timeout bounds the wait for the first delta only, so long
+// answers are not cut off while they are being streamed.
+func (c *llmChain) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (llmRoute, error) {
+	started := false
+	return c.try(ctx, req, 0, func(ctx context.Context, r llmRoute, req CompletionRequest) error {
+		actx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var firstToken *time.Timer
+		if c.attemptTimeout > 0 {
+			firstToken = time.AfterFunc(c.attemptTimeout, cancel)
+		}
+		err := r.llm.Stream(actx, req, func(d string) {
+			if !started && firstToken != nil {
+				firstToken.Stop()
+			}
+			started = true
+			onDelta(d)
+		})
+		if firstToken != nil {
+			firstToken.Stop()
+		}
+		if err != nil && started {
+			return errStreamStarted{err}
+		}
+		return err
+	})
+}
+
+type errStreamStarted struct{ error }
+
+func (e errStreamStarted) Unwrap() error { return e.error }
+
+// try calls each route in turn; timeout, when positive, bounds each attempt.
+func (c *llmChain) try(ctx context.Context, req CompletionRequest, timeout time.Duration, call func(context.Context, llmRoute, CompletionRequest) error) (llmRoute, error) {
+	reqID := rag.RequestID(ctx)
+	var errs []error
+	for i, r := range c.routes {
+		if !r.breaker.allow() {
+			log.Printf("req_id=%s chat llm skip=%s reason=breaker_open", reqID, r)
+			errs = append(errs, fmt.Errorf("%s: %w", r, errBreakerOpen))
+			continue
+		}
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		req.Model = r.Model
+		err := call(actx, r, req)
+		cancel()
+		if err == nil {
+			r.breaker.success()
+			if i > 0 {
+				log.Printf("req_id=%s chat llm fallback=%s attempt=%d", reqID, r, i+1)
+			}
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			// The client went away; not the provider's fault.
+			r.breaker.abandon()
+			return r, ctx.Err()
+		}
+		opened := r.breaker.failure()
+		log.Printf("req_id=%s chat llm attempt=%d route=%s error=%q breaker_opened=%t", reqID, i+1, r, err.Error(), opened)
+		var started errStreamStarted
+		if errors.As(err, &started) {
+			return r, started.error
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", r, err))
+	}
+	return llmRoute{}, fmt.Errorf("all models failed: %w", errors.Join(errs...))
+}
+
+// auxComplete makes an auxiliary call (condense, rerank, verify) on s.llm,
+// bounded by LLMAttemptTimeout like each attempt of the chain.
+func (s *Server) auxComplete(ctx context.Context, req CompletionRequest) (string, error) {
+	if s.cfg.LLMAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.LLMAttemptTimeout)
+		defer cancel()
+	}
+	return s.llm.Complete(ctx, req)
+}
+
+// breakerLLM routes auxiliary calls through a provider's breaker.
+type breakerLLM struct {
+	llm LLM
+	br  *breaker
+}
+
+func (b breakerLLM) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	if !b.br.allow() {
+		return "", errBreakerOpen
+	}
+	out, err := b.llm.Complete(ctx, req)
+	b.record(ctx, err)
+	return out, err
+}
+
+func (b breakerLLM) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) error {
+	if !b.br.allow() {
+		return errBreakerOpen
+	}
+	err := b.llm.Stream(ctx, req, onDelta)
+	b.record(ctx, err)
+	return err
+}
+
+func (b breakerLLM) record(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		b.br.success()
+	case ctx.Err() == nil:
+		b.br.failure()
+	default:
+		b.br.abandon()
+	}
+}
@@ -0,0 +1,210 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestBreakerOpensAndRetriesAfterCooldown(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	if !b.allow() {
+		t.Fatalf("breaker opened after a single failure")
+	}
+	if !b.failure() || b.allow() {
+		t.Fatalf("breaker should be open after two failures")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatalf("breaker should allow a trial after the cool-down")
+	}
+	if !b.failure() || b.allow() {
+		t.Fatalf("a failed trial should reopen the breaker at once")
+	}
+
+	now = now.Add(time.Minute)
+	b.success()
+	b.failure()
+	if !b.allow() {
+		t.Fatalf("a success should reset the failure count")
+	}
+}
+
+func TestChainFallsBackAndSkipsOpenBreaker(t *testing.T) {
+	down := &ScriptedLLM{Err: errors.New("503")}
+	up := &ScriptedLLM{Replies: []string{"ok"}}
+	br := newBreaker(1, time.Hour)
+	chain := &llmChain{routes: []llmRoute{
+		{Provider: "openai", Model: "big", llm: down, breaker: br},
+		{Provider: "anthropic", Model: "small", llm: up, breaker: newBreaker(1, time.Hour)},
+	}}
+
+	out, route, err := chain.Complete(context.Background(), CompletionRequest{Model: "ignored"})
+	if err != nil || out != "ok" || route.String() != "anthropic:small" {
+		t.Fatalf("unexpected result %q %s %v", out, route, err)
+	}
+	if up.Calls[0].Model != "small" {
+		t.Fatalf("fallback should get its own model, got %q", up.Calls[0].Model)
+	}
+
+	// The first provider's breaker is now open, so it is not called again.
+	if _, _, err := chain.Complete(context.Background(), CompletionRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(down.Calls) != 1 {
+		t.Fatalf("open breaker should skip the provider, got %d calls", len(down.Calls))
+	}
+
+	up.Err = errors.New("also down")
+	_, _, err = chain.Complete(context.Background(), CompletionRequest{})
+	if err == nil || !errors.Is(err, errBreakerOpen) || !strings.Contains(err.Error(), "also down") {
+		t.Fatalf("expected a joined error, got %v", err)
+	}
+}
+
+type failingStream struct{ ScriptedLLM }
+
+func (f *failingStream) Stream(_ context.Context, _ CompletionRequest, onDelta func(string)) error {
+	onDelta("partial")
+	return errors.New("connection reset")
+}
+
+func TestChainStreamNoFallbackAfterDeltas(t *testing.T) {
+	backup := &ScriptedLLM{Replies: []string{"whole"}}
+	chain := &llmChain{routes: []llmRoute{
+		{Provider: "openai", Model: "a", llm: &failingStream{}},
+		{Provider: "openai", Model: "b", llm: backup},
+	}}
+
+	var got strings.Builder
+	route, err := chain.Stream(context.Background(), CompletionRequest{}, func(d string) { got.WriteString(d) })
+	if err == nil || route.Model != "a" {
+		t.Fatalf("expected the first route's error, got %s %v", route, err)
+	}
+	if got.String() != "partial" || len(backup.Calls) != 0 {
+		t.Fatalf("stream fell back after deltas: %q calls=%d", got.String(), len(backup.Calls))
+	}
+}
+
+func TestNewLLMChainRoutes(t *testing.T) {
+	cfg := Config{LLMProvider: LLMOpenAI, ChatModel: "gpt", LLMFallbacks: "openai:gpt-mini, anthropic:claude", LLMAPIKey: "sk", LLMBreakerFails: 3}
+	t.Setenv("ANTHROPIC_API_KEY", "ak")
+	chain, _, err := newLLMChain(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range chain.routes {
+		names = append(names, r.String())
+	}
+	if strings.Join(names, ",") != "openai:gpt,openai:gpt-mini,anthropic:claude" {
+		t.Fatalf("unexpected routes %v", names)
+	}
+	if chain.routes[0].breaker != chain.routes[1].breaker || chain.routes[0].breaker == chain.routes[2].breaker {
+		t.Fatalf("breakers should be shared per provider")
+	}
+
+	if err := CheckLLMConfig(Config{LLMFallbacks: "nomodel"}); err == nil {
+		t.Fatalf("expected error for a bad fallback entry")
+	}
+}
+
+func TestBreakerHalfOpenAdmitsOneTrial(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(1, time.Minute)
+	b.now = func() time.Time { return now }
+	b.failure()
+
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatalf("expected a trial after the cool-down")
+	}
+	if b.allow() {
+		t.Fatalf("a second call was let through while the trial is in flight")
+	}
+	b.abandon()
+	if !b.allow() {
+		t.Fatalf("an abandoned trial should admit the next one")
+	}
+	b.success()
+	if !b.allow() || !b.allow() {
+		t.Fatalf("a successful trial should close the breaker")
+	}
+}
+
+// slowStream waits delay before its first delta, then sends deltas every
+// gap, honouring cancellation.
+type slowStream struct {
+	ScriptedLLM
+	delay, gap time.Duration
+	deltas     int
+}
+
+func (s *slowStream) Stream(ctx context.Context, _ CompletionRequest, onDelta func(string)) error {
+	wait := s.delay
+	for i := 0; i < s.deltas; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		onDelta("x")
+		wait = s.gap
+	}
+	return nil
+}
+
+// hangingLLM blocks every call until its context ends.
+type hangingLLM struct{ ScriptedLLM }
+
+func (h *hangingLLM) Complete(ctx context.Context, _ CompletionRequest) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestAuxCallsUseAttemptTimeout(t *testing.T) {
+	srv := &Server{cfg: Config{ChatModel: "m", LLMAttemptTimeout: 20 * time.Millisecond}, llm: &hangingLLM{}}
+	start := time.Now()
+	if _, err := srv.condenseWithModel(context.Background(), []chatTurn{{Role: roleUser, Content: "Bus?"}}, "How much?"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if _, err := (&llmVerifier{srv: srv}).Verify(context.Background(), []string{"The bus is free."}, verifySources); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	hits := []rag.ScoredChunk{{Chunk: rag.Chunk{Title: "Bus", URL: "https://a", Text: "The C6 bus."}}}
+	if _, err := (&llmReranker{srv: srv}).Rerank(context.Background(), "bus", hits); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("auxiliary calls were not bounded")
+	}
+}
+
+func TestChainStreamTimeoutBoundsFirstDeltaOnly(t *testing.T) {
+	long := &slowStream{gap: 10 * time.Millisecond, deltas: 6}
+	chain := &llmChain{attemptTimeout: 30 * time.Millisecond, routes: []llmRoute{{Provider: "openai", Model: "a", llm: long}}}
+	var got strings.Builder
+	if _, err := chain.Stream(context.Background(), CompletionRequest{}, func(d string) { got.WriteString(d) }); err != nil || got.Len() != 6 {
+		t.Fatalf("long stream was cut: %q %v", got.String(), err)
+	}
+
+	stuck := &slowStream{delay: time.Second, deltas: 1}
+	backup := &ScriptedLLM{Replies: []string{"ok"}}
+	chain = &llmChain{attemptTimeout: 20 * time.Millisecond, routes: []llmRoute{
+		{Provider: "openai", Model: "a", llm: stuck},
+		{Provider: "anthropic", Model: "b", llm: backup},
+	}}
+	route, err := chain.Stream(context.Background(), CompletionRequest{}, func(string) {})
+	if err != nil || route.Model != "b" {
+		t.Fatalf("expected fallback after no first delta, got %s %v", route, err)
+	}
+}
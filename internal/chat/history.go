@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"content-rag-chat/internal/rag"
+	"content-rag-chat/internal/storage"
+)
+
+// chatTurn is one prior message of a conversation. Role is "user" or "assistant".
+type chatTurn struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+const (
+	roleUser      = "user"
+	roleAssistant = "assistant"
+
+	// historyTurnChars caps a single prior turn; long answers are cut so one
+	// turn can't eat the whole history budget.
+	historyTurnChars = 1200
+)
+
+var conversationIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// validConversationID reports whether id is usable as a conversation key.
+func validConversationID(id string) bool {
+	return conversationIDPattern.MatchString(id)
+}
+
+// boundHistory keeps the newest turns that fit maxTurns and maxTokens,
+// dropping empty turns and unknown roles. Order is preserved. maxTurns <= 0
+// disables history; maxTokens <= 0 leaves only the turn limit.
+func boundHistory(turns []chatTurn, maxTurns, maxTokens int) []chatTurn {
+	if maxTurns <= 0 || len(turns) == 0 {
+		return nil
+	}
+	clean := make([]chatTurn, 0, len(turns))
+	for _, t := range turns {
+		t.Role = strings.ToLower(strings.TrimSpace(t.Role))
+		t.Content = strings.TrimSpace(t.Content)
+		if t.Content == "" || (t.Role != roleUser && t.Role != roleAssistant) {
+			continue
+		}
+		t.Content = rag.TruncateRunes(t.Content, historyTurnChars, "…")
+		clean = append(clean, t)
+	}
+	start, used := len(clean), 0
+	for start > 0 && len(clean)-start < maxTurns {
+		tok := rag.EstimateTokens(clean[start-1].Content)
+		if maxTokens > 0 && used+tok > maxTokens {
+			break
+		}
+		used += tok
+		start--
+	}
+	if start == len(clean) {
+		return nil
+	}
+	return clean[start:]
+}
+
+// conversationHistory returns the bounded history for a request: the turns
+// of its session when sessions are enabled, or else the turns sent by the
+// client.
+func (s *Server) conversationHistory(req chatRequest, sess *storage.Session) []chatTurn {
+	turns := req.History
+	if s.sessions != nil {
+		// The session is the only source of history: client-sent turns
+		// could be forged to steer the model.
+		turns = nil
+	}
+	if sess != nil && len(sess.Turns) > 0 {
+		turns = make([]chatTurn, len(sess.Turns))
+		for i, t := range sess.Turns {
+			turns[i] = chatTurn{Role: t.Role, Content: t.Content}
+		}
+	}
+	return boundHistory(turns, s.cfg.HistoryMaxTurns, s.cfg.HistoryMaxTokens)
+}
+
+// condense rewrites a follow-up into a standalone retrieval query using the
+// history ("and how much is it?" -> "how much is the airport bus?"). Without
+// history the question is returned unchanged. If the model call fails the
+// previous user turn is prepended instead, which keeps the topic in the query.
+func (s *Server) condense(ctx context.Context, history []chatTurn, question string) string {
+	if len(history) == 0 || !s.cfg.Condense {
+		return question
+	}
+	fn := s.condenseFunc
+	if fn == nil {
+		fn = s.condenseWithModel
+	}
+	standalone, err := fn(ctx, history, question)
+	standalone = strings.TrimSpace(standalone)
+	if err != nil || standalone == "" {
+		if err != nil {
+			log.Printf("req_id=%s chat condense error=%q", rag.RequestID(ctx), err.Error())
+		}
+		return fallbackCondense(history, question)
+	}
+	return standalone
+}
+
+func (s *Server) condenseWithModel(ctx context.Context, history []chatTurn, question string) (string, error) {
+	model := s.cfg.CondenseModel
+	if model == "" {
+		model = s.cfg.ChatModel
+	}
+	req := CompletionRequest{
+		Model: model,
+		Messages: []Message{
+			{Role: "system", Content: "You rewrite follow-up questions for a search engine. Output JSON."},
+			{Role: "user", Content: buildCondensePrompt(history, question)},
+		},
+		Temperature: 0,
+		JSON:        true,
+	}
+	raw, err := s.auxComplete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return "", fmt.Errorf("invalid condense json: %w", err)
+	}
+	return out.Query, nil
+}
+
+func buildCondensePrompt(history []chatTurn, question string) string {
+	var sb strings.Builder
+	sb.WriteString("Rewrite the follow-up question as a standalone question about Alicante that can be searched without the conversation.\n")
+	sb.WriteString("Resolve pronouns and omitted subjects from the conversation. Keep the user's wording otherwise; do not answer it.\n")
+	sb.WriteString("If the follow-up is already standalone, return it unchanged.\n")
+	sb.WriteString("Respond in JSON: {\"query\": \"<standalone question>\"}.\n\n")
+	writeHistory(&sb, history)
+	sb.WriteString("\nFollow-up question:\n")
+	sb.WriteString(question)
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// fallbackCondense joins the last user turn with the follow-up.
+func fallbackCondense(history []chatTurn, question string) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == roleUser {
+			return history[i].Content + " " + question
+		}
+	}
+	return question
+}
+
+// writeHistory renders turns as "User: ..." / "Assistant: ..." lines.
+func writeHistory(sb *strings.Builder, history []chatTurn) {
+	sb.WriteString("Conversation so far:\n")
+	for _, t := range history {
+		who := "User"
+		if t.Role == roleAssistant {
+			who = "Assistant"
+		}
+		fmt.Fprintf(sb, "%s: %s\n", who, t.Content)
+	}
+}
@@ -15,16 +15,29 @@ import (
 type chatRequest struct {
 	Question string `json:"question"`
 	Lang     string `json:"lang"`
+	// History holds prior turns, oldest first, for stateless clients. It is
+	// ignored when the ConversationID session already has turns.
+	History        []chatTurn `json:"history,omitempty"`
+	ConversationID string     `json:"conversation_id,omitempty"`
 }
 
 type sourceItem struct {
 	Title string `json:"title"`
 	URL   string `json:"url"`
+	// Snippet is an extractive excerpt, set only on degraded answers.
+	Snippet string `json:"snippet,omitempty"`
 }
 
 type chatResponse struct {
 	Answer  string       `json:"answer"`
 	Sources []sourceItem `json:"sources"`
+	// Citations map answer spans to sources, for footnotes.
+	Citations      []citation `json:"citations,omitempty"`
+	ConversationID string     `json:"conversation_id,omitempty"`
+	// Model is the "provider:model" that answered; empty when no model ran.
+	Model string `json:"model,omitempty"`
+	// AnswerType is grounded, no_answer, search_only or lexical_only.
+	AnswerType string `json:"answer_type,omitempty"`
 }
 
 func NewMux(s *Server) *http.ServeMux {
@@ -32,7 +45,15 @@ func NewMux(s *Server) *http.ServeMux {
 	mux := http.NewServeMux()
 	chatHandler := withRateLimit(limiter, withJWTAuth(s.cfg, http.HandlerFunc(s.handleChat)))
 	mux.Handle("/chat", withCORS(s.cfg.CORSAllowedOrigin, chatHandler))
+	sessionHandler := withRateLimit(limiter, withJWTAuth(s.cfg, http.HandlerFunc(s.handleDeleteSession)))
+	mux.Handle("/chat/session", withCORS(s.cfg.CORSAllowedOrigin, sessionHandler))
+	if s.cfg.AdminToken != "" {
+		mux.Handle("/admin/reload", withAdminToken(s.cfg.AdminToken, http.HandlerFunc(s.handleReload)))
+		mux.Handle("/admin/index", withAdminToken(s.cfg.AdminToken, http.HandlerFunc(s.handleIndexInfo)))
+		mux.Handle("/admin/stats", withAdminToken(s.cfg.AdminToken, http.HandlerFunc(s.handleOutputStats)))
+	}
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Index-Version", s.currentIndex().Version)
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
@@ -40,7 +61,14 @@ func NewMux(s *Server) *http.ServeMux {
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus is writeJSON with a status code; headers must be set
+// before WriteHeader, so callers cannot send the status themselves.
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(true)
 	_ = enc.Encode(v)
@@ -71,8 +99,9 @@ func withCORS(allowedOrigin string, next http.Handler) http.Handler {
 		if origin != "" && origin == allowedOrigin {
 			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 			w.Header().Set("Vary", "Origin")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Conversation-ID")
 		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
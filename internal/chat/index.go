@@ -0,0 +1,190 @@
+package chat
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"content-rag-chat/internal/rag"
+)
+
+// Index is an immutable snapshot of the searchable corpus. A request loads
+// the current snapshot once, so a reload never changes it mid-request and
+// in-flight streams finish on the index they started with.
+type Index struct {
+	Entries []rag.Entry
+	// Docs sees every chunk, including collapsed duplicates, for expansion.
+	Docs *rag.DocIndex
+	// Lexical is a BM25 index over Entries, used by the relevance gate.
+	Lexical *rag.BM25
+	Model   string
+	Dims    int
+	Chunks  int
+	Version string
+	// Source is "files" or "bundle:<path>".
+	Source   string
+	LoadedAt time.Time
+}
+
+// IndexInfo is the public description of the active index.
+type IndexInfo struct {
+	Version    string    `json:"version"`
+	Model      string    `json:"model"`
+	Dims       int       `json:"dims"`
+	Entries    int       `json:"entries"`
+	Chunks     int       `json:"chunks"`
+	Source     string    `json:"source"`
+	LoadedAt   time.Time `json:"loaded_at"`
+	LastReload string    `json:"last_reload_error,omitempty"`
+}
+
+// LoadIndex reads cfg.BundlePath, or cfg.ChunksPath and cfg.CachePath, and
+// builds an index for cfg.EmbedModel at cfg.EmbedDims.
+func LoadIndex(cfg Config) (*Index, error) {
+	if cfg.BundlePath != "" {
+		return loadBundleIndex(cfg)
+	}
+	chunks, err := rag.ReadChunks(cfg.ChunksPath)
+	if err != nil {
+		return nil, fmt.Errorf("load chunks: %w", err)
+	}
+	cache, err := rag.LoadCache(cfg.CachePath)
+	if err != nil {
+		return nil, fmt.Errorf("load cache: %w", err)
+	}
+	if cache.Models[cfg.EmbedModel] == nil && cache.Models[rag.ModelKey(cfg.EmbedModel, cfg.EmbedDims)] == nil {
+		return nil, fmt.Errorf("cache %s has no vectors for embed model %q (has: %s)", cfg.CachePath, cfg.EmbedModel, strings.Join(cache.ModelNames(), ", "))
+	}
+	entries := rag.BuildIndexDims(chunks, cache, cfg.EmbedModel, cfg.EmbedDims)
+	if len(entries) < len(chunks) {
+		log.Printf("warning: %d/%d chunks have no valid vector for %s and are not searchable", len(chunks)-len(entries), len(chunks), rag.ModelKey(cfg.EmbedModel, cfg.EmbedDims))
+	}
+	ix := NewIndex(cfg, cfg.EmbedModel, entries)
+	ix.Chunks = len(chunks)
+	ix.Source = "files"
+	return ix, nil
+}
+
+// loadBundleIndex refuses bundles that are corrupt (ReadBundle checks the
+// checksums) or were built for another model or too few dimensions.
+func loadBundleIndex(cfg Config) (*Index, error) {
+	b, err := rag.ReadBundle(cfg.BundlePath)
+	if err != nil {
+		return nil, err
+	}
+	m := b.Manifest
+	if m.Model != cfg.EmbedModel {
+		return nil, fmt.Errorf("bundle %s was built for model %s, not %s", cfg.BundlePath, m.Model, cfg.EmbedModel)
+	}
+	if cfg.EmbedDims > m.Dims {
+		return nil, fmt.Errorf("bundle %s has %d dims, EMBED_DIMS=%d", cfg.BundlePath, m.Dims, cfg.EmbedDims)
+	}
+	// Full vectors can be truncated to any EMBED_DIMS; vectors the API
+	// embedded at fewer dims only serve that exact size.
+	if m.ModelKey != m.Model && m.ModelKey != rag.ModelKey(cfg.EmbedModel, cfg.EmbedDims) {
+		return nil, fmt.Errorf("bundle %s holds %s vectors embedded at %d dims; set EMBED_DIMS=%d", cfg.BundlePath, m.Model, m.Dims, m.Dims)
+	}
+	entries := rag.BuildIndexDims(b.Chunks, b.Cache, cfg.EmbedModel, cfg.EmbedDims)
+	if len(entries) < len(b.Chunks) {
+		log.Printf("warning: %d/%d bundle chunks have no valid vector", len(b.Chunks)-len(entries), len(b.Chunks))
+	}
+	ix := NewIndex(cfg, m.Model, entries)
+	ix.Chunks = len(b.Chunks)
+	ix.Version = m.Checksum[:12]
+	ix.Source = "bundle:" + cfg.BundlePath
+	log.Printf("index bundle=%s built_at=%s template=%s corpus_hash=%.12s", cfg.BundlePath, m.BuiltAt, m.Template, m.CorpusHash)
+	return ix, nil
+}
+
+// NewIndex wraps already-built entries embedded with model, collapsing
+// near-duplicates when cfg.Dedup is set. model is the one recorded in the
+// cache section or bundle manifest the vectors came from.
+func NewIndex(cfg Config, model string, entries []rag.Entry) *Index {
+	ix := &Index{
+		Entries:  entries,
+		Docs:     rag.NewDocIndex(entryChunks(entries)),
+		Model:    model,
+		Chunks:   len(entries),
+		Version:  indexVersion(entries),
+		LoadedAt: time.Now().UTC(),
+	}
+	if len(entries) > 0 {
+		ix.Dims = len(entries[0].Vec)
+	}
+	if cfg.ExpandMode != "" && cfg.ExpandMode != rag.ExpandNone && len(entries) > 0 && !ix.Docs.Chunked() {
+		log.Printf("warning: EXPAND_MODE=%s has no effect: every doc is a single chunk (chunk files need chunk_index/section)", cfg.ExpandMode)
+	}
+	if cfg.Dedup {
+		var groups []rag.DuplicateGroup
+		ix.Entries, groups = rag.CollapseDuplicates(entries, rag.DedupOptions{MinSim: cfg.DedupMinSim, MinJaccard: float64(cfg.DedupMinJaccard), Filter: cfg.SearchFilter})
+		log.Printf("index dedup groups=%d collapsed=%d entries=%d", len(groups), len(entries)-len(ix.Entries), len(ix.Entries))
+	}
+	ix.Lexical = rag.NewBM25(entryChunks(ix.Entries))
+	return ix
+}
+
+// Validate checks that ix can replace prev: it must be non-empty, built for
+// the configured model, and every vector must share the configured (or
+// previous) dimension, since query vectors are sized once per request.
+func (ix *Index) Validate(cfg Config, prev *Index) error {
+	if ix == nil || len(ix.Entries) == 0 {
+		return fmt.Errorf("index is empty")
+	}
+	if ix.Model != cfg.EmbedModel {
+		return fmt.Errorf("index model %q does not match embed model %q", ix.Model, cfg.EmbedModel)
+	}
+	want := cfg.EmbedDims
+	if want <= 0 && prev != nil {
+		want = prev.Dims
+	}
+	if want <= 0 {
+		want = ix.Dims
+	}
+	for _, e := range ix.Entries {
+		if len(e.Vec) != want {
+			return fmt.Errorf("chunk %s has %d dims, want %d", e.Chunk.ChunkID, len(e.Vec), want)
+		}
+	}
+	return nil
+}
+
+// Info describes the index for the admin endpoint.
+func (ix *Index) Info() IndexInfo {
+	return IndexInfo{Version: ix.Version, Model: ix.Model, Dims: ix.Dims, Entries: len(ix.Entries), Chunks: ix.Chunks, Source: ix.Source, LoadedAt: ix.LoadedAt}
+}
+
+// indexVersion is a short content hash of the indexed chunks and their
+// vectors' leading components, so identical inputs report the same version.
+func indexVersion(entries []rag.Entry) string {
+	if len(entries) == 0 {
+		return "empty"
+	}
+	h := sha1.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s\x00%s\x00%d", e.Chunk.ChunkID, rag.TextHash(e.Chunk.Text), len(e.Vec))
+		for _, x := range e.Vec[:min(4, len(e.Vec))] {
+			fmt.Fprintf(h, "\x00%.6f", x)
+		}
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// indexFilesStamp is the latest modification time of the index inputs.
+func indexFilesStamp(cfg Config) time.Time {
+	var latest time.Time
+	paths := []string{cfg.ChunksPath, cfg.CachePath}
+	if cfg.BundlePath != "" {
+		paths = []string{cfg.BundlePath}
+	}
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
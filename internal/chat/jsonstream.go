@@ -0,0 +1,277 @@
+package chat
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// answerStream parses the model's JSON reply incrementally as deltas
+// arrive. It passes the decoded characters of the top-level "answer" string
+// to onText and the top-level "sources" array, once complete, to onSources.
+// Anything before the opening brace (code fences, prose) and after the
+// closing one is ignored; malformed input just stops producing output.
+type answerStream struct {
+	onText    func(string)
+	onSources func([]sourceItem)
+	// markers, when > 0, strips citation markers for sources 1..markers
+	// from the text, as applyCitations does for the final answer.
+	markers int
+	held    string // text that may be the start of a marker
+
+	raw       []byte
+	depth     int
+	done      bool
+	expectKey bool
+	key       []byte // decoded top-level key being read
+	valueKey  string // top-level key whose value is being read
+	valueAt   int    // offset of that value in raw, -1 before it starts
+	answered  bool   // the answer string has been streamed
+
+	inString bool
+	strKind  int // strOther, strKey or strAnswer
+	escape   int // escNone, escBackslash or escUnicode
+	hex      []byte
+	high     rune   // pending high surrogate from a \u escape
+	pending  []byte // decoded answer bytes not yet emitted
+}
+
+const (
+	strOther = iota
+	strKey
+	strAnswer
+)
+
+const (
+	escNone = iota
+	escBackslash
+	escUnicode
+)
+
+func newAnswerStream(onText func(string), onSources func([]sourceItem)) *answerStream {
+	return &answerStream{onText: onText, onSources: onSources, valueAt: -1}
+}
+
+// Write consumes the next delta and emits whatever it completes.
+func (a *answerStream) Write(delta string) {
+	for i := 0; i < len(delta) && !a.done; i++ {
+		a.raw = append(a.raw, delta[i])
+		a.step(delta[i], len(a.raw)-1)
+	}
+	a.flushText()
+}
+
+func (a *answerStream) step(c byte, pos int) {
+	if a.inString {
+		a.stringByte(c)
+		return
+	}
+	if a.depth == 0 {
+		if c == '{' {
+			a.depth, a.expectKey = 1, true
+		}
+		return
+	}
+	if a.depth == 1 && a.valueKey != "" && a.valueAt < 0 && !isJSONSpace(c) && c != ',' && c != '}' {
+		a.valueAt = pos
+	}
+	switch c {
+	case '"':
+		a.inString, a.escape = true, escNone
+		switch {
+		case a.depth == 1 && a.expectKey:
+			a.strKind, a.key = strKey, a.key[:0]
+		case a.depth == 1 && a.valueKey == "answer" && !a.answered:
+			a.strKind = strAnswer
+		default:
+			a.strKind = strOther
+		}
+	case ':':
+		if a.depth == 1 && a.expectKey {
+			a.expectKey, a.valueKey, a.valueAt = false, string(a.key), -1
+		}
+	case '{', '[':
+		a.depth++
+	case '}', ']':
+		if a.depth == 1 {
+			a.endValue(pos)
+			a.done = true
+		}
+		a.depth--
+	case ',':
+		if a.depth == 1 {
+			a.endValue(pos)
+			a.expectKey = true
+		}
+	}
+}
+
+// endValue is called at the comma or brace after a top-level value.
+func (a *answerStream) endValue(pos int) {
+	if a.valueKey == "sources" && a.valueAt >= 0 && a.onSources != nil {
+		var sources []sourceItem
+		if err := json.Unmarshal(a.raw[a.valueAt:pos], &sources); err == nil && len(sources) > 0 {
+			a.onSources(sources)
+		}
+	}
+	a.valueKey, a.valueAt = "", -1
+}
+
+func (a *answerStream) stringByte(c byte) {
+	switch a.escape {
+	case escBackslash:
+		a.escape = escNone
+		switch c {
+		case 'u':
+			a.escape, a.hex = escUnicode, a.hex[:0]
+		case 'n':
+			a.emit('\n')
+		case 't':
+			a.emit('\t')
+		case 'r':
+			a.emit('\r')
+		case 'b':
+			a.emit('\b')
+		case 'f':
+			a.emit('\f')
+		default: // '"', '\\', '/'
+			a.emit(rune(c))
+		}
+		return
+	case escUnicode:
+		a.hex = append(a.hex, c)
+		if len(a.hex) < 4 {
+			return
+		}
+		a.escape = escNone
+		n, err := strconv.ParseUint(string(a.hex), 16, 32)
+		if err != nil {
+			a.emit(utf8.RuneError)
+			return
+		}
+		a.emit(rune(n))
+		return
+	}
+	switch c {
+	case '\\':
+		a.escape = escBackslash
+	case '"':
+		a.closeString()
+	default:
+		a.emitByte(c)
+	}
+}
+
+func (a *answerStream) closeString() {
+	if a.high != 0 {
+		a.high = 0
+		a.write(utf8.RuneError)
+	}
+	a.inString = false
+	if a.strKind == strAnswer {
+		a.answered = true // a repeated "answer" key is not streamed twice
+	}
+}
+
+// emit adds a decoded rune, pairing UTF-16 surrogates from \u escapes.
+func (a *answerStream) emit(r rune) {
+	if a.high != 0 {
+		high := a.high
+		a.high = 0
+		if utf16.IsSurrogate(r) && r >= 0xDC00 {
+			a.write(utf16.DecodeRune(high, r))
+			return
+		}
+		a.write(utf8.RuneError)
+	}
+	if utf16.IsSurrogate(r) {
+		if r < 0xDC00 {
+			a.high = r
+			return
+		}
+		r = utf8.RuneError
+	}
+	a.write(r)
+}
+
+func (a *answerStream) emitByte(c byte) {
+	if a.high != 0 {
+		a.high = 0
+		a.write(utf8.RuneError)
+	}
+	switch a.strKind {
+	case strKey:
+		a.key = append(a.key, c)
+	case strAnswer:
+		a.pending = append(a.pending, c)
+	}
+}
+
+func (a *answerStream) write(r rune) {
+	switch a.strKind {
+	case strKey:
+		a.key = utf8.AppendRune(a.key, r)
+	case strAnswer:
+		a.pending = utf8.AppendRune(a.pending, r)
+	}
+}
+
+// flushText emits the decoded answer text, holding back a UTF-8 sequence
+// split across deltas.
+func (a *answerStream) flushText() {
+	n := len(a.pending)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(a.pending[i]) {
+			if !utf8.FullRune(a.pending[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	text := string(a.pending[:n])
+	a.pending = append(a.pending[:0], a.pending[n:]...)
+	if a.markers > 0 {
+		text = a.stripMarkers(text)
+	}
+	if text != "" && a.onText != nil {
+		a.onText(text)
+	}
+}
+
+// stripMarkers removes complete citation markers from the held text plus
+// text, holding back a trailing "[1, " or whitespace that a later delta may
+// complete into a marker. Everything is released once the answer closes.
+func (a *answerStream) stripMarkers(text string) string {
+	s := a.held + text
+	cut := len(s)
+	if !a.answered {
+		cut = openMarkerStart(s)
+	}
+	a.held = s[cut:]
+	return citationMarker.ReplaceAllStringFunc(s[:cut], func(m string) string {
+		sub := citationMarker.FindStringSubmatch(m)
+		if _, ok := markerNumbers(sub[1], a.markers); ok {
+			return ""
+		}
+		return m
+	})
+}
+
+// openMarkerStart returns where a possibly unfinished marker at the end of
+// s begins: a "[" followed only by digits, commas and spaces, or trailing
+// whitespace, either with the whitespace before it. It returns len(s) if
+// there is none.
+func openMarkerStart(s string) int {
+	cut := len(s)
+	if i := strings.LastIndexByte(s, '['); i >= 0 && strings.Trim(s[i+1:], "0123456789, ") == "" {
+		cut = i
+	}
+	return len(strings.TrimRightFunc(s[:cut], unicode.IsSpace))
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
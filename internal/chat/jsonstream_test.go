@@ -0,0 +1,131 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestAnswerStreamDecodesAnswer(t *testing.T) {
+	raw := "```json\n{\"sources\": [{\"title\":\"Bus\",\"url\":\"https://a\"}], \"answer\": \"Line \\\"C6\\\"\\nruns \\u00e9t\\u00e9 \\ud83d\\ude8c café €3.85\\\\\", \"answer\": \"again\"}\n```"
+	want := "Line \"C6\"\nruns été 🚌 café €3.85\\"
+
+	// Any split of the reply, down to single bytes, gives the same text.
+	for _, size := range []int{1, 2, 3, 7, len(raw)} {
+		var text strings.Builder
+		var sources [][]sourceItem
+		a := newAnswerStream(func(s string) {
+			if !utf8.ValidString(s) {
+				t.Errorf("size %d: split rune in %q", size, s)
+			}
+			text.WriteString(s)
+		}, func(s []sourceItem) { sources = append(sources, s) })
+		for i := 0; i < len(raw); i += size {
+			a.Write(raw[i:min(i+size, len(raw))])
+		}
+		if text.String() != want {
+			t.Fatalf("size %d: got %q, want %q", size, text.String(), want)
+		}
+		if len(sources) != 1 || len(sources[0]) != 1 || sources[0][0].URL != "https://a" {
+			t.Fatalf("size %d: unexpected sources %+v", size, sources)
+		}
+	}
+}
+
+func TestAnswerStreamIgnoresNestedAnswer(t *testing.T) {
+	var text strings.Builder
+	a := newAnswerStream(func(s string) { text.WriteString(s) }, nil)
+	a.Write(`{"meta":{"answer":"no"},"answer":"yes","sources":null}`)
+	if text.String() != "yes" {
+		t.Fatalf("got %q", text.String())
+	}
+}
+
+func TestAnswerStreamStripsMarkers(t *testing.T) {
+	raw := `{"answer":"Runs every 20 minutes [1, 2]. Rebuilt [2024] and [3] [note].","sources":[]}`
+	want := "Runs every 20 minutes. Rebuilt [2024] and [3] [note]."
+	for _, size := range []int{1, 2, 5, len(raw)} {
+		var text strings.Builder
+		a := newAnswerStream(func(s string) { text.WriteString(s) }, nil)
+		a.markers = 2
+		for i := 0; i < len(raw); i += size {
+			a.Write(raw[i:min(i+size, len(raw))])
+		}
+		if text.String() != want {
+			t.Fatalf("size %d: got %q, want %q", size, text.String(), want)
+		}
+	}
+}
+
+func TestGenerateAnswerStreamSendsText(t *testing.T) {
+	llm := &ScriptedLLM{Replies: []string{`{"answer":"Take the C6 bus [1].","sources":[{"title":"","url":"https://a"},{"title":"x","url":"https://unknown"}]}`}}
+	srv := &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m"}, llm: llm}
+	hits := []rag.ScoredChunk{{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: "Take the C6 bus to the centre."}, Score: 0.9}}
+
+	rec := httptest.NewRecorder()
+	if _, err := srv.generateAnswerStream(context.Background(), "q", nil, hits, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	var text strings.Builder
+	var events []string
+	var out chatResponse
+	for _, block := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		event, data, _ := strings.Cut(block, "\n")
+		event = strings.TrimPrefix(event, "event: ")
+		data = strings.TrimPrefix(data, "data: ")
+		if len(events) == 0 || events[len(events)-1] != event {
+			events = append(events, event)
+		}
+		switch event {
+		case "delta":
+			var d struct{ Delta string }
+			if err := json.Unmarshal([]byte(data), &d); err != nil {
+				t.Fatal(err)
+			}
+			text.WriteString(d.Delta)
+		case "sources":
+			var s struct{ Sources []sourceItem }
+			if err := json.Unmarshal([]byte(data), &s); err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Sources) != 1 || s.Sources[0].Title != "Airport bus" {
+				t.Fatalf("unexpected sources event %+v", s)
+			}
+		case "result":
+			if err := json.Unmarshal([]byte(data), &out); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if text.String() != "Take the C6 bus." {
+		t.Fatalf("unexpected streamed text %q", text.String())
+	}
+	if strings.Join(events, ",") != "delta,sources,result" {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if out.Answer != "Take the C6 bus." || len(out.Citations) != 1 {
+		t.Fatalf("unexpected result %+v", out)
+	}
+}
+
+func TestGenerateAnswerStreamHoldsTextWhenVerifying(t *testing.T) {
+	llm := &ScriptedLLM{Replies: []string{`{"answer":"Take the C6 bus [1]. It has free wifi [1].","sources":[{"title":"","url":"https://a"}]}`}}
+	srv := &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m", Verify: verifyLexical, VerifyMinSupport: 0.5}, llm: llm}
+	srv.verifier = newVerifier(srv)
+	hits := []rag.ScoredChunk{{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: "Take the C6 bus to the centre."}, Score: 0.9}}
+
+	rec := httptest.NewRecorder()
+	if _, err := srv.generateAnswerStream(context.Background(), "q", nil, hits, rec); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "event: delta") || strings.Contains(body, "wifi") || !strings.Contains(body, "event: result") {
+		t.Fatalf("unverified text was streamed: %s", body)
+	}
+}
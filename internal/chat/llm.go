@@ -0,0 +1,188 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// LLM is a chat-completion backend. Complete returns the whole reply;
+// Stream calls onDelta with each text fragment as it arrives.
+type LLM interface {
+	Complete(ctx context.Context, req CompletionRequest) (string, error)
+	Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) error
+}
+
+// CompletionRequest is a provider-neutral chat completion call.
+type CompletionRequest struct {
+	Model       string
+	Messages    []Message
+	Temperature float32
+	// JSON asks for a single JSON object reply (OpenAI response_format,
+	// an assistant prefill for Anthropic).
+	JSON bool
+	// Schema, when set with JSON, is the reply's JSON schema. Providers with
+	// strict structured outputs enforce it; the rest treat it as JSON mode.
+	Schema *JSONSchema
+}
+
+// Message is one chat message; Role is "system", "user" or "assistant".
+type Message struct {
+	Role    string
+	Content string
+}
+
+// LLM providers for Config.LLMProvider.
+const (
+	LLMOpenAI    = "openai"
+	LLMAnthropic = "anthropic"
+	LLMFake      = "fake"
+)
+
+// NewLLM builds the chat-completion backend selected by cfg.LLMProvider.
+// The API key defaults to OPENAI_API_KEY or ANTHROPIC_API_KEY.
+func NewLLM(cfg Config, client *http.Client) (LLM, error) {
+	headers, err := parseLLMHeaders(cfg.LLMHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+	switch cfg.LLMProvider {
+	case "", LLMOpenAI:
+		key := cfg.LLMAPIKey
+		if key == "" {
+			key = os.Getenv("OPENAI_API_KEY")
+		}
+		base := cfg.LLMBaseURL
+		if base == "" {
+			base = defaultOpenAIBaseURL
+		}
+		return &openAILLM{client: client, baseURL: strings.TrimRight(base, "/"), apiKey: key, org: cfg.LLMOrg, headers: headers, strictSchema: cfg.LLMJSONSchema}, nil
+	case LLMAnthropic:
+		key := cfg.LLMAPIKey
+		if key == "" {
+			key = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if key == "" {
+			return nil, errors.New("anthropic LLM needs LLM_API_KEY or ANTHROPIC_API_KEY")
+		}
+		base := cfg.LLMBaseURL
+		if base == "" {
+			base = defaultAnthropicBaseURL
+		}
+		maxTokens := cfg.LLMMaxTokens
+		if maxTokens <= 0 {
+			maxTokens = 1024
+		}
+		return &anthropicLLM{client: client, baseURL: strings.TrimRight(base, "/"), apiKey: key, headers: headers, maxTokens: maxTokens}, nil
+	case LLMFake:
+		return loadScriptedLLM(cfg.LLMScript)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (want openai, anthropic or fake)", cfg.LLMProvider)
+	}
+}
+
+// parseLLMHeaders parses "Name=value,Other=value" into extra request headers.
+func parseLLMHeaders(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	out := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("llm headers: bad term %q (want Name=value)", part)
+		}
+		out[http.CanonicalHeaderKey(name)] = strings.TrimSpace(val)
+	}
+	return out, nil
+}
+
+// ScriptedLLM replays canned replies in order, for tests and offline runs.
+// Calls records every request. Once the script is exhausted the last reply
+// repeats; an empty script or a set Err fails every call.
+type ScriptedLLM struct {
+	Replies []string
+	Err     error
+
+	mu    sync.Mutex
+	next  int
+	Calls []CompletionRequest
+}
+
+func (f *ScriptedLLM) reply(req CompletionRequest) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls = append(f.Calls, req)
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if len(f.Replies) == 0 {
+		return "", errors.New("scripted llm: no replies")
+	}
+	i := f.next
+	if i >= len(f.Replies) {
+		i = len(f.Replies) - 1
+	} else {
+		f.next++
+	}
+	return f.Replies[i], nil
+}
+
+func (f *ScriptedLLM) Complete(_ context.Context, req CompletionRequest) (string, error) {
+	return f.reply(req)
+}
+
+// Stream delivers the reply in small fragments, like a real provider.
+func (f *ScriptedLLM) Stream(_ context.Context, req CompletionRequest, onDelta func(string)) error {
+	out, err := f.reply(req)
+	if err != nil {
+		return err
+	}
+	for len(out) > 0 && onDelta != nil {
+		n := 0
+		for i := 0; i < 8 && n < len(out); i++ {
+			_, size := utf8.DecodeRuneInString(out[n:])
+			n += size
+		}
+		onDelta(out[:n])
+		out = out[n:]
+	}
+	return nil
+}
+
+// loadScriptedLLM reads a JSON array of replies.
+func loadScriptedLLM(path string) (*ScriptedLLM, error) {
+	if path == "" {
+		return nil, errors.New("fake LLM needs LLM_SCRIPT (JSON array of replies)")
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read llm script: %w", err)
+	}
+	var replies []string
+	if err := json.Unmarshal(raw, &replies); err != nil {
+		return nil, fmt.Errorf("parse llm script %s: %w", path, err)
+	}
+	return &ScriptedLLM{Replies: replies}, nil
+}
+
+// errLLM fails every call; it stands in when NewLLM could not build a backend.
+type errLLM struct{ err error }
+
+func (e errLLM) Complete(context.Context, CompletionRequest) (string, error) { return "", e.err }
+
+func (e errLLM) Stream(context.Context, CompletionRequest, func(string)) error { return e.err }
@@ -0,0 +1,167 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestOpenAICompatibleLLM(t *testing.T) {
+	var got chatCompletionRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-test" || r.Header.Get("OpenAI-Organization") != "org-1" || r.Header.Get("X-Title") != "AlicanteAbout" {
+			t.Errorf("missing headers: %v", r.Header)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"answer\\\":\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"\\\"hi\\\"}\"}}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		_, _ = io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"{\"answer\":\"hi\"}"}}]}`)
+	}))
+	defer ts.Close()
+
+	llm, err := NewLLM(Config{LLMProvider: LLMOpenAI, LLMBaseURL: ts.URL + "/v1/", LLMAPIKey: "sk-test", LLMOrg: "org-1", LLMHeaders: "x-title=AlicanteAbout"}, ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := CompletionRequest{Model: "local-model", Messages: []Message{{Role: "user", Content: "q"}}, JSON: true}
+	out, err := llm.Complete(context.Background(), req)
+	if err != nil || out != `{"answer":"hi"}` {
+		t.Fatalf("complete: %q %v", out, err)
+	}
+	if got.Model != "local-model" || got.ResponseFormat == nil || got.ResponseFormat.Type != "json_object" {
+		t.Fatalf("unexpected wire request: %+v", got)
+	}
+
+	var streamed strings.Builder
+	if err := llm.Stream(context.Background(), req, func(d string) { streamed.WriteString(d) }); err != nil {
+		t.Fatal(err)
+	}
+	if streamed.String() != `{"answer":"hi"}` {
+		t.Fatalf("unexpected stream %q", streamed.String())
+	}
+}
+
+func TestAnthropicLLM(t *testing.T) {
+	var got anthropicRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "ak-test" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.Stream {
+			fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"\\\"answer\\\":\"}}\n\n")
+			fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"\\\"hi\\\"}\"}}\n\n")
+			fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+			return
+		}
+		_, _ = io.WriteString(w, `{"content":[{"type":"text","text":"\"answer\":\"hi\"}"}]}`)
+	}))
+	defer ts.Close()
+
+	llm, err := NewLLM(Config{LLMProvider: LLMAnthropic, LLMBaseURL: ts.URL, LLMAPIKey: "ak-test"}, ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := CompletionRequest{
+		Model:    "claude-test",
+		Messages: []Message{{Role: "system", Content: "Output JSON."}, {Role: "user", Content: "q"}},
+		JSON:     true,
+	}
+	out, err := llm.Complete(context.Background(), req)
+	if err != nil || out != `{"answer":"hi"}` {
+		t.Fatalf("complete: %q %v", out, err)
+	}
+	if got.System != "Output JSON." || got.MaxTokens <= 0 || len(got.Messages) != 2 || got.Messages[1].Role != "assistant" || got.Messages[1].Content != "{" {
+		t.Fatalf("unexpected wire request: %+v", got)
+	}
+
+	var streamed strings.Builder
+	if err := llm.Stream(context.Background(), req, func(d string) { streamed.WriteString(d) }); err != nil {
+		t.Fatal(err)
+	}
+	if streamed.String() != `{"answer":"hi"}` {
+		t.Fatalf("unexpected stream %q", streamed.String())
+	}
+
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	if _, err := NewLLM(Config{LLMProvider: LLMAnthropic}, nil); err == nil {
+		t.Fatalf("expected error without an API key")
+	}
+	if _, err := NewLLM(Config{LLMProvider: "bogus"}, nil); err == nil {
+		t.Fatalf("expected error for unknown provider")
+	}
+}
+
+func TestAnthropicStreamErrorBeforeContentFallsBack(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	}))
+	defer ts.Close()
+	primary, err := NewLLM(Config{LLMProvider: LLMAnthropic, LLMBaseURL: ts.URL, LLMAPIKey: "ak-test"}, ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := &ScriptedLLM{Replies: []string{`{"answer":"ok"}`}}
+	chain := &llmChain{routes: []llmRoute{
+		{Provider: "anthropic", Model: "a", llm: primary},
+		{Provider: "openai", Model: "b", llm: backup},
+	}}
+
+	var got strings.Builder
+	route, err := chain.Stream(context.Background(), CompletionRequest{JSON: true}, func(d string) { got.WriteString(d) })
+	if err != nil || route.Model != "b" || len(backup.Calls) != 1 {
+		t.Fatalf("expected fallback before the first token, got %s %v calls=%d", route, err, len(backup.Calls))
+	}
+	if got.String() != `{"answer":"ok"}` {
+		t.Fatalf("prefill leaked from the failed attempt: %q", got.String())
+	}
+}
+
+func TestGenerateAnswerWithScriptedLLM(t *testing.T) {
+	fake := &ScriptedLLM{Replies: []string{`{"answer":"Take the C6.","sources":[{"title":"","url":"https://a"},{"title":"X","url":"https://evil"}]}`}}
+	srv := &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m"}, llm: fake}
+	hits := []rag.ScoredChunk{{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: "The C6 bus."}, Score: 0.9}}
+
+	g, err := srv.generateAnswer(context.Background(), "How do I get to the centre?", nil, hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Answer != "Take the C6." || len(g.Sources) != 1 || g.Sources[0].Title != "Airport bus" || g.Model != "openai:m" {
+		t.Fatalf("unexpected result %+v", g)
+	}
+	if len(fake.Calls) != 1 || !fake.Calls[0].JSON || fake.Calls[0].Model != "m" {
+		t.Fatalf("unexpected calls %+v", fake.Calls)
+	}
+}
+
+func TestWireRequestSendsZeroTemperature(t *testing.T) {
+	req := CompletionRequest{Model: "m", Messages: []Message{{Role: "user", Content: "q"}}, Temperature: 0}
+	for name, wire := range map[string]any{
+		"openai":    (&openAILLM{}).wireRequest(req, false),
+		"anthropic": (&anthropicLLM{maxTokens: 16}).wireRequest(req, false),
+	} {
+		body, err := json.Marshal(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), `"temperature":0`) {
+			t.Fatalf("%s: temperature 0 dropped from %s", name, body)
+		}
+	}
+}
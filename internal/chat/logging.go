@@ -2,13 +2,16 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"content-rag-chat/internal/rag"
 	"content-rag-chat/internal/storage"
 )
 
-func (s *Server) logChat(ctx context.Context, question string, answerType string, results []rag.ScoredChunk, start time.Time) {
+// logChat records a request. gate is nil when retrieval was never gated
+// (e.g. the language fallback); g is zero when no model answered.
+func (s *Server) logChat(ctx context.Context, question string, answerType string, g generated, results []rag.ScoredChunk, gate *rag.GateDecision, start time.Time) {
 	if s.logger == nil {
 		return
 	}
@@ -21,6 +24,14 @@ func (s *Server) logChat(ctx context.Context, question string, answerType string
 		TopSources:       sources,
 		TopScores:        scores,
 		LatencyMs:        int(time.Since(start).Milliseconds()),
+		Model:            g.Model,
+		Faithfulness:     g.Faithfulness,
+	}
+	if gate != nil {
+		rec.GateReason = gate.Reason
+		if b, err := json.Marshal(gate.Signals); err == nil {
+			rec.GateSignals = string(b)
+		}
 	}
 	s.logger.Log(ctx, rec)
 }
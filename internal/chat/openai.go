@@ -9,17 +9,18 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"content-rag-chat/internal/rag"
 )
 
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
 type chatCompletionRequest struct {
 	Model          string              `json:"model"`
 	Messages       []chatMessage       `json:"messages"`
-	Temperature    float32             `json:"temperature,omitempty"`
+	Temperature    float32             `json:"temperature"` // always sent: 0 is a real setting
 	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
 	Stream         bool                `json:"stream,omitempty"`
 }
@@ -30,7 +31,14 @@ type chatMessage struct {
 }
 
 type chatResponseFormat struct {
-	Type string `json:"type"`
+	Type       string          `json:"type"`
+	JSONSchema *chatJSONSchema `json:"json_schema,omitempty"`
+}
+
+type chatJSONSchema struct {
+	Name   string          `json:"name"`
+	Strict bool            `json:"strict"`
+	Schema json.RawMessage `json:"schema"`
 }
 
 type chatCompletionResponse struct {
@@ -43,7 +51,58 @@ type chatCompletionResponse struct {
 	} `json:"error,omitempty"`
 }
 
-func (s *Server) callChatCompletion(ctx context.Context, req chatCompletionRequest) (string, error) {
+// openAILLM talks to /chat/completions on OpenAI or any compatible server
+// (Azure-style proxies, vLLM, Ollama, OpenRouter) via baseURL.
+type openAILLM struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	org     string
+	headers map[string]string
+	// strictSchema sends CompletionRequest.Schema as a strict json_schema
+	// response format; servers without structured outputs get json_object.
+	strictSchema bool
+}
+
+func (o *openAILLM) wireRequest(req CompletionRequest, stream bool) chatCompletionRequest {
+	out := chatCompletionRequest{
+		Model:       req.Model,
+		Messages:    make([]chatMessage, len(req.Messages)),
+		Temperature: req.Temperature,
+		Stream:      stream,
+	}
+	for i, m := range req.Messages {
+		out.Messages[i] = chatMessage{Role: m.Role, Content: m.Content}
+	}
+	switch {
+	case req.JSON && req.Schema != nil && o.strictSchema:
+		out.ResponseFormat = &chatResponseFormat{Type: "json_schema", JSONSchema: &chatJSONSchema{Name: req.Schema.Name, Strict: true, Schema: req.Schema.Schema}}
+	case req.JSON:
+		out.ResponseFormat = &chatResponseFormat{Type: "json_object"}
+	}
+	return out
+}
+
+func (o *openAILLM) newRequest(ctx context.Context, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	if o.org != "" {
+		httpReq.Header.Set("OpenAI-Organization", o.org)
+	}
+	for k, v := range o.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq, nil
+}
+
+func (o *openAILLM) Complete(ctx context.Context, creq CompletionRequest) (string, error) {
+	req := o.wireRequest(creq, false)
 	body, _ := json.Marshal(req)
 	reqID := rag.RequestID(ctx)
 	if reqID == "" {
@@ -51,14 +110,12 @@ func (s *Server) callChatCompletion(ctx context.Context, req chatCompletionReque
 	}
 	log.Printf("req_id=%s openai chat request_bytes=%d model=%s messages=%d", reqID, len(body), req.Model, len(req.Messages))
 	start := time.Now()
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewReader(body))
+	httpReq, err := o.newRequest(ctx, body)
 	if err != nil {
 		return "", err
 	}
-	httpReq.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
-	httpReq.Header.Set("Content-Type", "application/json")
 
-	res, err := s.client.Do(httpReq)
+	res, err := o.client.Do(httpReq)
 	if err != nil {
 		return "", err
 	}
@@ -91,7 +148,8 @@ type chatStreamChunk struct {
 	} `json:"choices"`
 }
 
-func (s *Server) callChatCompletionStream(ctx context.Context, req chatCompletionRequest, onDelta func(string)) error {
+func (o *openAILLM) Stream(ctx context.Context, creq CompletionRequest, onDelta func(string)) error {
+	req := o.wireRequest(creq, true)
 	body, _ := json.Marshal(req)
 	reqID := rag.RequestID(ctx)
 	if reqID == "" {
@@ -100,14 +158,12 @@ func (s *Server) callChatCompletionStream(ctx context.Context, req chatCompletio
 	log.Printf("req_id=%s openai chat stream request_bytes=%d model=%s messages=%d", reqID, len(body), req.Model, len(req.Messages))
 	start := time.Now()
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewReader(body))
+	httpReq, err := o.newRequest(ctx, body)
 	if err != nil {
 		return err
 	}
-	httpReq.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
-	httpReq.Header.Set("Content-Type", "application/json")
 
-	res, err := s.client.Do(httpReq)
+	res, err := o.client.Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -2,18 +2,31 @@ package chat
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"content-rag-chat/internal/rag"
 )
 
 type promptSource struct {
-	Title   string
-	URL     string
-	Excerpt string
+	Title    string
+	URL      string
+	Excerpt  string
+	Modified string
 }
 
-func buildPrompt(question string, hits []rag.ScoredChunk, topK int) (string, []promptSource) {
+// contextStats reports how much source text the budget removed.
+type contextStats struct {
+	Sources        int
+	TokensIn       int
+	TokensOut      int
+	TrimmedSources int
+}
+
+// buildPrompt renders the generation prompt. history (may be nil) is shown
+// for context only; the answer must still come from the sources, and user
+// turns also steer which excerpt paragraphs survive the budget.
+func buildPrompt(question string, history []chatTurn, hits []rag.ScoredChunk, topK int, maxTokens int) (string, []promptSource, contextStats) {
 	unique := map[string]promptSource{}
 	ordered := make([]promptSource, 0, len(hits))
 	for _, h := range hits {
@@ -21,9 +34,10 @@ func buildPrompt(question string, hits []rag.ScoredChunk, topK int) (string, []p
 			continue
 		}
 		ps := promptSource{
-			Title:   h.Chunk.Title,
-			URL:     h.Chunk.URL,
-			Excerpt: h.Chunk.Text,
+			Title:    h.Chunk.Title,
+			URL:      h.Chunk.URL,
+			Excerpt:  h.Chunk.Text,
+			Modified: modifiedDate(h.Chunk),
 		}
 		unique[h.Chunk.URL] = ps
 		ordered = append(ordered, ps)
@@ -31,20 +45,81 @@ func buildPrompt(question string, hits []rag.ScoredChunk, topK int) (string, []p
 			break
 		}
 	}
+	focus := question
+	for _, t := range history {
+		if t.Role == roleUser {
+			focus += "\n" + t.Content
+		}
+	}
+	stats := budgetSources(focus, ordered, maxTokens)
 
 	var sb strings.Builder
 	sb.WriteString("You are a helpful assistant for AlicanteAbout.com, a tourism guide for Alicante, Spain.\n")
 	sb.WriteString("Use ONLY the provided sources to answer. If the answer is not in the sources, say \"I don't know based on AlicanteAbout content.\".\n")
 	sb.WriteString("Respond in JSON with keys: answer (string) and sources (array of {title,url}).\n")
-	sb.WriteString("Only include sources you actually used. Do not invent sources.\n\n")
+	sb.WriteString("Only include sources you actually used. Do not invent sources.\n")
+	sb.WriteString("After each sentence, cite the sources it comes from with their numbers in square brackets, e.g. \"The bus runs every 20 minutes [1].\" or \"[1, 3]\".\n")
+	sb.WriteString("Prices, timetables and opening hours may be outdated: when you state one, say \"as of <Last updated date>\" for its source.\n\n")
+	if len(history) > 0 {
+		sb.WriteString("The conversation below only tells you what the question refers to. Do not treat earlier answers as sources.\n")
+		writeHistory(&sb, history)
+		sb.WriteString("\n")
+	}
 	sb.WriteString("Question:\n")
 	sb.WriteString(question)
 	sb.WriteString("\n\nSources:\n")
 	for i, src := range ordered {
-		fmt.Fprintf(&sb, "\n[%d] %s\nURL: %s\nExcerpt:\n%s\n", i+1, src.Title, src.URL, src.Excerpt)
+		fmt.Fprintf(&sb, "\n[%d] %s\nURL: %s\n", i+1, src.Title, src.URL)
+		if src.Modified != "" {
+			fmt.Fprintf(&sb, "Last updated: %s\n", src.Modified)
+		}
+		fmt.Fprintf(&sb, "Excerpt:\n%s\n", src.Excerpt)
+	}
+
+	return sb.String(), ordered, stats
+}
+
+// budgetSources trims excerpts in place so their total estimated tokens fit
+// maxTokens, keeping the most query-relevant paragraphs of each source.
+// Sources are visited shortest-first so budget left over by short excerpts
+// flows to long ones. maxTokens <= 0 disables trimming.
+func budgetSources(question string, sources []promptSource, maxTokens int) contextStats {
+	stats := contextStats{Sources: len(sources)}
+	for _, src := range sources {
+		stats.TokensIn += rag.EstimateTokens(src.Excerpt)
+	}
+	if maxTokens <= 0 || len(sources) == 0 {
+		stats.TokensOut = stats.TokensIn
+		return stats
+	}
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
 	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(sources[order[a]].Excerpt) < len(sources[order[b]].Excerpt)
+	})
+	remaining := maxTokens
+	for n, i := range order {
+		share := remaining / (len(order) - n)
+		sel := rag.SelectPassages(question, sources[i].Excerpt, share)
+		sources[i].Excerpt = sel.Text
+		remaining -= sel.TokensOut
+		stats.TokensOut += sel.TokensOut
+		if sel.Trimmed {
+			stats.TrimmedSources++
+		}
+	}
+	return stats
+}
 
-	return sb.String(), ordered
+// modifiedDate renders the chunk's last-modified date as YYYY-MM-DD, or "" if unknown.
+func modifiedDate(ch rag.Chunk) string {
+	t, ok := ch.Modified()
+	if !ok {
+		return ""
+	}
+	return t.Format("2006-01-02")
 }
 
 func filterSources(ordered []promptSource, picked []sourceItem, max int) []sourceItem {
@@ -64,6 +139,7 @@ func filterSources(ordered []promptSource, picked []sourceItem, max int) []sourc
 		if src.Title == "" {
 			src.Title = title
 		}
+		src.Snippet = ""
 		cleanSources = append(cleanSources, src)
 		if len(cleanSources) >= max {
 			break
@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestBuildPromptDedupesAndDates(t *testing.T) {
+	hits := []rag.ScoredChunk{
+		{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", ModifiedGMT: "2023-11-27T00:04:25", Text: "The C6 costs 3.85 EUR."}},
+		{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: "duplicate"}},
+		{Chunk: rag.Chunk{Title: "Tram", URL: "https://b", Text: "Line 1 goes to Benidorm."}},
+	}
+	prompt, ordered, _ := buildPrompt("How much is the airport bus?", nil, hits, 3, 0)
+	if len(ordered) != 2 {
+		t.Fatalf("expected 2 unique sources, got %d", len(ordered))
+	}
+	if !strings.Contains(prompt, "Last updated: 2023-11-27") {
+		t.Fatalf("expected last-updated date in prompt")
+	}
+	if strings.Count(prompt, "Last updated:") != 1 {
+		t.Fatalf("expected date only for sources that have one")
+	}
+}
+
+func TestBuildPromptContextBudget(t *testing.T) {
+	var long strings.Builder
+	for i := 0; i < 200; i++ {
+		long.WriteString("Alicante has many sunny days and pleasant walks along the seafront.\n")
+	}
+	long.WriteString("Airport bus prices\n")
+	long.WriteString("The C6 airport bus ticket costs 3.85 EUR one way.\n")
+	for i := 0; i < 200; i++ {
+		long.WriteString("The old town has narrow streets and colourful houses to explore.\n")
+	}
+	hits := []rag.ScoredChunk{
+		{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: long.String()}},
+		{Chunk: rag.Chunk{Title: "Short", URL: "https://b", Text: "Short excerpt."}},
+	}
+	prompt, ordered, stats := buildPrompt("How much does the airport bus ticket cost?", nil, hits, 3, 300)
+	if stats.TokensIn <= stats.TokensOut || stats.TrimmedSources != 1 {
+		t.Fatalf("expected one trimmed source, got %+v", stats)
+	}
+	if stats.TokensOut > 330 {
+		t.Fatalf("expected context near budget, got %d tokens", stats.TokensOut)
+	}
+	if !strings.Contains(ordered[0].Excerpt, "3.85 EUR") || !strings.Contains(ordered[0].Excerpt, "Airport bus prices") {
+		t.Fatalf("expected relevant passage and heading to be kept: %q", ordered[0].Excerpt)
+	}
+	if ordered[1].Excerpt != "Short excerpt." || !strings.Contains(prompt, "Short excerpt.") {
+		t.Fatalf("short source should be untouched")
+	}
+}
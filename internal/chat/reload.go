@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"time"
+)
+
+// currentIndex returns the active index snapshot; never nil.
+func (s *Server) currentIndex() *Index {
+	if ix := s.index.Load(); ix != nil {
+		return ix
+	}
+	return &Index{}
+}
+
+// IndexInfo describes the active index and the last reload failure, if any.
+func (s *Server) IndexInfo() IndexInfo {
+	info := s.currentIndex().Info()
+	info.LastReload, _ = s.reloadErr.Load().(string)
+	return info
+}
+
+// Reload builds a fresh index and swaps it in atomically once it validates.
+// On any error the current index stays active. Reloads are serialized.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	load := s.loadIndex
+	if load == nil {
+		load = func() (*Index, error) { return LoadIndex(s.cfg) }
+	}
+	start := time.Now()
+	prev := s.currentIndex()
+	next, err := load()
+	if err == nil {
+		err = next.Validate(s.cfg, s.index.Load())
+	}
+	if err != nil {
+		s.reloadErr.Store(err.Error())
+		log.Printf("index reload failed keep_version=%s err=%v", prev.Version, err)
+		return err
+	}
+	s.index.Store(next)
+	s.reloadErr.Store("")
+	log.Printf("index reload ok version=%s prev_version=%s entries=%d took=%s", next.Version, prev.Version, len(next.Entries), fmtDuration(time.Since(start)))
+	return nil
+}
+
+// WatchIndexFiles polls the chunk and cache files and reloads once a change
+// has settled for one interval, so half-written files are not picked up.
+func (s *Server) WatchIndexFiles(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	seen := indexFilesStamp(s.cfg)
+	var pending time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		stamp := indexFilesStamp(s.cfg)
+		if stamp.Equal(seen) {
+			continue
+		}
+		if !stamp.Equal(pending) {
+			pending = stamp
+			continue
+		}
+		seen = stamp
+		log.Printf("index files changed; reloading")
+		_ = s.Reload()
+	}
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := http.StatusOK
+	if err := s.Reload(); err != nil {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSONStatus(w, status, s.IndexInfo())
+}
+
+func (s *Server) handleIndexInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.IndexInfo())
+}
+
+// withAdminToken guards admin endpoints with a static bearer token.
+func withAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := extractBearerToken(r.Header.Get("Authorization"))
+		if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
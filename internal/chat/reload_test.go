@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+func testIndex(cfg Config, ids ...string) *Index {
+	entries := make([]rag.Entry, len(ids))
+	for i, id := range ids {
+		entries[i] = rag.Entry{Chunk: rag.Chunk{ChunkID: id, Text: id}, Vec: []float32{1, 0}}
+	}
+	return NewIndex(cfg, cfg.EmbedModel, entries)
+}
+
+func TestReloadSwapsOnlyValidIndex(t *testing.T) {
+	cfg := Config{EmbedModel: "m", AdminToken: "secret"}
+	srv := NewServer(cfg, testIndex(cfg, "a"), nil, nil)
+	v1 := srv.currentIndex().Version
+
+	var next *Index
+	var loadErr error
+	srv.loadIndex = func() (*Index, error) { return next, loadErr }
+
+	next = testIndex(cfg, "a", "b")
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	v2 := srv.currentIndex().Version
+	if v2 == v1 || len(srv.currentIndex().Entries) != 2 {
+		t.Fatalf("expected new index to be active")
+	}
+
+	bad := []*Index{
+		testIndex(cfg), // empty
+		testIndex(Config{EmbedModel: "other"}, "a"),                                                   // wrong model
+		{Model: "m", Entries: []rag.Entry{{Chunk: rag.Chunk{ChunkID: "x"}, Vec: []float32{1, 0, 0}}}}, // dims changed
+	}
+	for i, ix := range bad {
+		next = ix
+		if err := srv.Reload(); err == nil {
+			t.Fatalf("case %d: expected validation error", i)
+		}
+		if srv.currentIndex().Version != v2 {
+			t.Fatalf("case %d: failed reload must keep the old index", i)
+		}
+	}
+	next, loadErr = nil, errors.New("bad cache")
+	if err := srv.Reload(); err == nil || srv.IndexInfo().LastReload != "bad cache" {
+		t.Fatalf("expected load error to be reported, got %+v", srv.IndexInfo())
+	}
+
+	mux := NewMux(srv)
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without admin token, got %d", rec.Code)
+	}
+
+	next, loadErr = testIndex(cfg, "c"), nil
+	req = httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var info IndexInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected reload response %d: %s", rec.Code, rec.Body.String())
+	}
+	if info.Version != srv.currentIndex().Version || info.Entries != 1 || info.LastReload != "" {
+		t.Fatalf("unexpected index info: %+v", info)
+	}
+
+	next, loadErr = nil, errors.New("bad cache")
+	req = httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("failed reload: got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestLoadIndexRequiresModelSection(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{ChunksPath: filepath.Join(dir, "chunks.jsonl"), CachePath: filepath.Join(dir, "cache.json"), EmbedModel: "m"}
+	if err := os.WriteFile(cfg.ChunksPath, []byte(`{"id":1,"type":"post","slug":"a","title":"A","url":"https://a","content_text":"Some text."}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache := &rag.EmbedCache{}
+	cache.ForModel("other")
+	if err := rag.SaveCache(cfg.CachePath, cache); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(cfg); err == nil || !strings.Contains(err.Error(), "other") {
+		t.Fatalf("expected missing model section error, got %v", err)
+	}
+}
+
+func TestLoadBundleIndexChecksDims(t *testing.T) {
+	chunks := []rag.Chunk{{ChunkID: "a-1", DocID: 1, Title: "A", Text: "alpha"}}
+	cache := &rag.EmbedCache{}
+	cache.ForModel(rag.ModelKey("m", 2)).Items["a-1"] = rag.EmbedCacheItem{ID: "a-1", Dim: 2, Vector: []float32{1, 0}}
+	b, err := rag.NewBundle(chunks, cache, "m", 2, "corpus", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{BundlePath: filepath.Join(t.TempDir(), "index.bundle"), EmbedModel: "m"}
+	if err := rag.WriteBundle(cfg.BundlePath, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// An API-shortened bundle would give an empty index at full dims.
+	if _, err := LoadIndex(cfg); err == nil || !strings.Contains(err.Error(), "EMBED_DIMS=2") {
+		t.Fatalf("expected dims error, got %v", err)
+	}
+	cfg.EmbedDims = 2
+	ix, err := LoadIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Entries) != 1 || ix.Model != "m" || ix.Validate(cfg, nil) != nil {
+		t.Fatalf("unexpected index %+v", ix.Info())
+	}
+}
@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"content-rag-chat/internal/rag"
+)
+
+// Reranker rescores retrieval candidates with a stronger relevance signal than
+// cosine similarity. Implementations return the hits sorted by the new score,
+// with Score replaced by a value in [0,1].
+type Reranker interface {
+	Rerank(ctx context.Context, question string, hits []rag.ScoredChunk) ([]rag.ScoredChunk, error)
+}
+
+const rerankExcerptChars = 1200
+
+// llmReranker asks the chat model to grade every passage in one batched call.
+type llmReranker struct {
+	srv   *Server
+	model string
+}
+
+func newReranker(s *Server) Reranker {
+	switch s.cfg.Reranker {
+	case "llm":
+		model := s.cfg.RerankModel
+		if model == "" {
+			model = s.cfg.ChatModel
+		}
+		return &llmReranker{srv: s, model: model}
+	default:
+		return nil
+	}
+}
+
+func (r *llmReranker) Rerank(ctx context.Context, question string, hits []rag.ScoredChunk) ([]rag.ScoredChunk, error) {
+	if len(hits) == 0 {
+		return hits, nil
+	}
+	req := CompletionRequest{
+		Model: r.model,
+		Messages: []Message{
+			{Role: "system", Content: "You grade search results. Output JSON."},
+			{Role: "user", Content: buildRerankPrompt(question, hits)},
+		},
+		Temperature: 0,
+		JSON:        true,
+	}
+	raw, err := r.srv.auxComplete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := parseRerankScores(raw, len(hits))
+	if err != nil {
+		return nil, err
+	}
+	return applyRerankScores(hits, scores), nil
+}
+
+func buildRerankPrompt(question string, hits []rag.ScoredChunk) string {
+	var sb strings.Builder
+	sb.WriteString("Rate how well each passage answers the question, from 0 (irrelevant) to 10 (directly answers it).\n")
+	sb.WriteString("Respond in JSON: {\"scores\": [{\"id\": <passage number>, \"score\": <0-10>}]} with one entry per passage.\n\n")
+	sb.WriteString("Question:\n")
+	sb.WriteString(question)
+	sb.WriteString("\n\nPassages:\n")
+	for i, h := range hits {
+		fmt.Fprintf(&sb, "\n[%d] %s\n%s\n", i+1, h.Chunk.Title, rag.TruncateRunes(h.Chunk.Text, rerankExcerptChars, "…"))
+	}
+	return sb.String()
+}
+
+// parseRerankScores maps the model's 0-10 grades onto [0,1] by passage index.
+// Passages the model skipped get 0.
+func parseRerankScores(raw string, n int) ([]float32, error) {
+	var out struct {
+		Scores []struct {
+			ID    int     `json:"id"`
+			Score float32 `json:"score"`
+		} `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("invalid rerank json: %w", err)
+	}
+	scores := make([]float32, n)
+	for _, s := range out.Scores {
+		if s.ID < 1 || s.ID > n {
+			continue
+		}
+		v := s.Score / 10
+		if v < 0 {
+			v = 0
+		}
+		if v > 1 {
+			v = 1
+		}
+		scores[s.ID-1] = v
+	}
+	return scores, nil
+}
+
+// applyRerankScores returns a copy of hits with new scores, sorted descending.
+// Ties keep the original (cosine) order.
+func applyRerankScores(hits []rag.ScoredChunk, scores []float32) []rag.ScoredChunk {
+	out := make([]rag.ScoredChunk, len(hits))
+	copy(out, hits)
+	for i := range out {
+		out[i].Score = scores[i]
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Score > out[j].Score
+	})
+	return out
+}
+
+// rerank applies the configured reranker to the first RerankTopN candidates.
+// On failure it logs and keeps cosine ordering, reporting reranked=false so the
+// caller gates on MinScore instead.
+func (s *Server) rerank(ctx context.Context, question string, results []rag.ScoredChunk) ([]rag.ScoredChunk, bool) {
+	if s.reranker == nil || len(results) == 0 {
+		return results, false
+	}
+	n := s.cfg.RerankTopN
+	if n <= 0 || n > len(results) {
+		n = len(results)
+	}
+	reranked, err := s.reranker.Rerank(ctx, question, results[:n])
+	if err != nil {
+		log.Printf("req_id=%s chat rerank error=%q", rag.RequestID(ctx), err.Error())
+		return results, false
+	}
+	return reranked, true
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestParseRerankScores(t *testing.T) {
+	scores, err := parseRerankScores(`{"scores":[{"id":2,"score":9},{"id":1,"score":3},{"id":7,"score":10}]}`, 3)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []float32{0.3, 0.9, 0}
+	for i := range want {
+		if diff := scores[i] - want[i]; diff > 1e-6 || diff < -1e-6 {
+			t.Fatalf("score %d: got %.2f want %.2f", i, scores[i], want[i])
+		}
+	}
+	if _, err := parseRerankScores("not json", 1); err == nil {
+		t.Fatalf("expected error for invalid json")
+	}
+}
+
+func TestHandleChatRerankOrdersAndGates(t *testing.T) {
+	hits := []rag.ScoredChunk{
+		{Chunk: rag.Chunk{Title: "Weather", URL: "https://weather"}, Score: 0.8},
+		{Chunk: rag.Chunk{Title: "Bus", URL: "https://bus"}, Score: 0.6},
+	}
+	var gotHits []rag.ScoredChunk
+	srv := &Server{
+		cfg: Config{
+			TopK:           2,
+			MinScore:       0.1,
+			RerankTopN:     5,
+			RerankMinScore: 0.5,
+		},
+		embedFunc: func(ctx context.Context, question string) ([]float32, error) {
+			return []float32{1, 0, 0}, nil
+		},
+		searchFunc: func(entries []rag.Entry, q []float32, k int, filter rag.Filter) []rag.ScoredChunk {
+			return hits
+		},
+		answerFunc: func(ctx context.Context, question string, _ []chatTurn, h []rag.ScoredChunk) (generated, error) {
+			gotHits = h
+			return generated{Answer: "Answer", Sources: []sourceItem{{Title: "Bus", URL: "https://bus"}}}, nil
+		},
+		reranker: stubReranker{scores: map[string]float32{"https://bus": 0.9, "https://weather": 0.2}},
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewBufferString(`{"question":"airport bus price","lang":"en"}`))
+	srv.handleChat(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if len(gotHits) != 2 || gotHits[0].Chunk.URL != "https://bus" {
+		t.Fatalf("expected reranked order, got %+v", gotHits)
+	}
+
+	srv.reranker = stubReranker{scores: map[string]float32{"https://bus": 0.2}}
+	gotHits = nil
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewBufferString(`{"question":"airport bus price","lang":"en"}`))
+	srv.handleChat(rec, req)
+	var out chatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Answer != fallbackAnswer || gotHits != nil {
+		t.Fatalf("expected fallback when reranked score is below threshold")
+	}
+}
+
+// stubReranker is a deterministic Reranker for tests: scores come from a fixed
+// map keyed by chunk URL; unknown URLs score 0.
+type stubReranker struct {
+	scores map[string]float32
+	err    error
+}
+
+func (r stubReranker) Rerank(_ context.Context, _ string, hits []rag.ScoredChunk) ([]rag.ScoredChunk, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	scores := make([]float32, len(hits))
+	for i, h := range hits {
+		scores[i] = r.scores[h.Chunk.URL]
+	}
+	return applyRerankScores(hits, scores), nil
+}
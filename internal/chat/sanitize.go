@@ -22,6 +22,23 @@ func SanitizeQuestion(input string) string {
 	return out
 }
 
+// SanitizeAnswer redacts emails and phone numbers the model may have
+// repeated from the question, and URLs other than the given sources. Times
+// and prices are kept, so a stored answer still works as history.
+func SanitizeAnswer(input string, sources []string) string {
+	out := emailPattern.ReplaceAllString(input, "[redacted_email]")
+	out = phonePattern.ReplaceAllString(out, "[redacted_phone]")
+	return urlPattern.ReplaceAllStringFunc(out, func(u string) string {
+		trimmed := strings.TrimRight(u, ".,;:!?)]")
+		for _, src := range sources {
+			if trimmed == src {
+				return u
+			}
+		}
+		return "[redacted_url]" + u[len(trimmed):]
+	})
+}
+
 func HashQuestion(input string) string {
 	h := sha256.Sum256([]byte(input))
 	return hex.EncodeToString(h[:])
@@ -3,11 +3,14 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"content-rag-chat/internal/rag"
@@ -15,16 +18,45 @@ import (
 )
 
 type Server struct {
-	cfg        Config
-	client     *http.Client
-	entries    []rag.Entry
+	cfg    Config
+	client *http.Client
+	// llm serves auxiliary calls (rerank, condense) on the primary provider;
+	// chain serves answers with fallbacks.
+	llm        LLM
+	chain      *llmChain
 	embedCache *embedCache
 	logger     storage.Logger
+	reranker   Reranker
+	verifier   Verifier
+	// outputStats counts structured output repairs, retries and failures.
+	outputStats outputStats
+	rewriter    *rag.QueryRewriter
+	// sessions holds conversations keyed by conversation_id; nil is stateless.
+	sessions storage.SessionStore
+	// calibration, when set, decides the gate's ambiguous zone.
+	calibration *rag.Calibration
 
-	embedFunc  func(ctx context.Context, question string) ([]float32, error)
-	searchFunc func(entries []rag.Entry, q []float32, k int) []rag.ScoredChunk
-	answerFunc func(ctx context.Context, question string, hits []rag.ScoredChunk) (string, []sourceItem, error)
-	streamFunc func(ctx context.Context, question string, hits []rag.ScoredChunk, w http.ResponseWriter) (string, error)
+	index     atomic.Pointer[Index]
+	reloadMu  sync.Mutex
+	reloadErr atomic.Value // string
+	loadIndex func() (*Index, error)
+
+	embedFunc    func(ctx context.Context, question string) ([]float32, error)
+	searchFunc   func(entries []rag.Entry, q []float32, k int, filter rag.Filter) []rag.ScoredChunk
+	answerFunc   func(ctx context.Context, question string, history []chatTurn, hits []rag.ScoredChunk) (generated, error)
+	streamFunc   func(ctx context.Context, question string, history []chatTurn, hits []rag.ScoredChunk, w http.ResponseWriter) (generated, error)
+	condenseFunc func(ctx context.Context, history []chatTurn, question string) (string, error)
+}
+
+// generated is the outcome of answer generation. Model is the
+// "provider:model" route that actually answered; Faithfulness is set when
+// the answer was verified against its sources.
+type generated struct {
+	Answer       string
+	Sources      []sourceItem
+	Citations    []citation
+	Model        string
+	Faithfulness *float32
 }
 
 const (
@@ -32,19 +64,34 @@ const (
 	langFallback   = "Sorry, English only for now."
 )
 
-func NewServer(cfg Config, entries []rag.Entry, client *http.Client, logger storage.Logger) *Server {
+func NewServer(cfg Config, ix *Index, client *http.Client, logger storage.Logger) *Server {
 	if client == nil {
 		client = &http.Client{Timeout: cfg.Timeout}
 	}
 	srv := &Server{
-		cfg:     cfg,
-		client:  client,
-		entries: entries,
-		logger:  logger,
+		cfg:    cfg,
+		client: client,
+		logger: logger,
+	}
+	srv.index.Store(ix)
+	chain, llm, err := newLLMChain(cfg, client)
+	if err != nil {
+		log.Printf("llm provider=%s error=%q", cfg.LLMProvider, err.Error())
+		llm = errLLM{err: err}
+		chain = singleRoute(cfg, llm)
 	}
+	srv.llm, srv.chain = llm, chain
 	if cfg.EmbedCacheMax > 0 {
 		srv.embedCache = newEmbedCache(cfg.EmbedCacheMax)
 	}
+	srv.reranker = newReranker(srv)
+	srv.verifier = newVerifier(srv)
+	if cfg.QueryRewrite {
+		srv.rewriter = rag.NewQueryRewriter(rag.DefaultGazetteer, cfg.QueryExpand)
+	}
+	if cfg.SessionTTL > 0 {
+		srv.sessions = storage.NewMemorySessionStore(cfg.SessionMax)
+	}
 	return srv
 }
 
@@ -75,6 +122,10 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "only English is supported", http.StatusBadRequest)
 		return
 	}
+	if req.ConversationID != "" && !validConversationID(req.ConversationID) {
+		http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+		return
+	}
 
 	ctx := r.Context()
 	reqID := rag.RequestID(ctx)
@@ -87,25 +138,44 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 			writeStreamFallback(w, langFallback)
 		} else {
 			writeJSON(w, chatResponse{
-				Answer:  langFallback,
-				Sources: nil,
+				Answer:         langFallback,
+				Sources:        nil,
+				ConversationID: req.ConversationID,
+				AnswerType:     answerNoAnswer,
 			})
 		}
-		s.logChat(ctx, req.Question, "no_answer", nil, start)
+		s.logChat(ctx, req.Question, answerNoAnswer, generated{}, nil, nil, start)
 		log.Printf("req_id=%s chat done=%s fallback=true reason=non_english", reqID, fmtDuration(time.Since(start)))
 		return
 	}
-	log.Printf("req_id=%s chat start question_len=%d", reqID, len(req.Question))
+	ix := s.currentIndex()
+	sess := s.loadSession(ctx, req)
+	req.ConversationID = ""
+	if sess != nil {
+		req.ConversationID = sess.ID
+		w.Header().Set("X-Conversation-ID", sess.ID)
+	}
+	history := s.conversationHistory(req, sess)
+	log.Printf("req_id=%s chat start question_len=%d history_turns=%d index=%s", reqID, len(req.Question), len(history), ix.Version)
+	standalone := s.condense(ctx, history, req.Question)
+	if standalone != req.Question {
+		log.Printf("req_id=%s chat condense query=%q", reqID, SanitizeQuestion(standalone))
+	}
+	rw := s.rewriter.Rewrite(standalone)
+	query := rw.Text()
+	if rw.Changed() {
+		log.Printf("req_id=%s chat rewrite query=%q matches=%q expansions=%q", reqID, SanitizeQuestion(query), rw.Matches, rw.Expansions)
+	}
 	embed := s.embedFunc
 	if embed == nil {
 		embed = func(ctx context.Context, question string) ([]float32, error) {
-			return rag.EmbedQuery(ctx, s.client, s.cfg.Provider, os.Getenv("OPENAI_API_KEY"), s.cfg.EmbedModel, question)
+			return rag.EmbedQueryDims(ctx, s.client, s.cfg.Provider, os.Getenv("OPENAI_API_KEY"), s.cfg.EmbedModel, s.cfg.EmbedDims, question)
 		}
 	}
 	tEmbed := time.Now()
 	var qVec []float32
 	var err error
-	cacheKey := s.cfg.Provider + ":" + s.cfg.EmbedModel + ":" + req.Lang + ":" + req.Question
+	cacheKey := s.cfg.Provider + ":" + rag.ModelKey(s.cfg.EmbedModel, s.cfg.EmbedDims) + ":" + req.Lang + ":" + query
 	if s.embedCache != nil {
 		if v, ok := s.embedCache.Get(cacheKey); ok {
 			qVec = v
@@ -113,9 +183,20 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if qVec == nil {
-		qVec, err = embed(ctx, req.Question)
+		qVec, err = embed(ctx, query)
 		if err != nil {
-			http.Error(w, "embedding error", http.StatusInternalServerError)
+			log.Printf("req_id=%s chat embed error=%q", reqID, err.Error())
+			if !s.cfg.DegradedAnswers {
+				http.Error(w, "embedding error", http.StatusInternalServerError)
+				return
+			}
+			// Embedding is down: answer from the keyword index alone.
+			hits := lexicalHits(ix, query, s.cfg.TopK, s.cfg.SearchFilter)
+			if len(hits) == 0 {
+				s.writeNoAnswer(ctx, w, req, sess, nil, nil, start)
+				return
+			}
+			s.writeDegraded(ctx, w, r, req, sess, query, hits, answerLexicalOnly, nil, start)
 			return
 		}
 		if s.embedCache != nil {
@@ -123,23 +204,63 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 			log.Printf("req_id=%s chat embed_cache_hit=false", reqID)
 		}
 	}
-	rag.Normalize(qVec)
+	qVec = rag.TruncateVector(qVec, s.cfg.EmbedDims)
 	log.Printf("req_id=%s chat embed=%s", reqID, fmtDuration(time.Since(tEmbed)))
 
 	search := s.searchFunc
 	if search == nil {
-		search = rag.TopKSearch
+		search = func(entries []rag.Entry, q []float32, k int, filter rag.Filter) []rag.ScoredChunk {
+			return rag.TopKSearchWeighted(entries, q, k, filter, s.cfg.VectorWeights)
+		}
 	}
 	tSearch := time.Now()
-	results := search(s.entries, qVec, s.cfg.TopK)
+	results := search(ix.Entries, qVec, maxInt(s.cfg.TopK, s.cfg.SearchCandidates), s.cfg.SearchFilter)
 	log.Printf("req_id=%s chat search=%s results=%d top_score=%.4f", reqID, fmtDuration(time.Since(tSearch)), len(results), topScore(results))
-	if len(results) == 0 || results[0].Score < s.cfg.MinScore {
-		writeJSON(w, chatResponse{
-			Answer:  fallbackAnswer,
-			Sources: nil,
-		})
-		s.logChat(ctx, req.Question, "no_answer", results, start)
-		log.Printf("req_id=%s chat done=%s fallback=true", reqID, fmtDuration(time.Since(start)))
+	lexical := ix.Lexical.Search(query, maxInt(s.cfg.TopK, s.cfg.SearchCandidates), s.cfg.SearchFilter)
+	gate := s.gatePolicy().Decide(rag.ComputeGateSignals(query, results, lexical))
+	logGate(ctx, gate)
+	if !gate.Answer {
+		s.writeNoAnswer(ctx, w, req, sess, results, &gate, start)
+		return
+	}
+
+	// The reranker only sees candidates that passed the gate, so it can
+	// tighten the decision (RerankMinScore) but not overturn a decline.
+	tRerank := time.Now()
+	results, reranked := s.rerank(ctx, rw.Rewritten, results)
+	if reranked {
+		log.Printf("req_id=%s chat rerank=%s top_score=%.4f", reqID, fmtDuration(time.Since(tRerank)), topScore(results))
+		if results[0].Score < s.cfg.RerankMinScore {
+			gate.Answer, gate.Reason = false, rag.GateRerankLow
+			logGate(ctx, gate)
+			s.writeNoAnswer(ctx, w, req, sess, results, &gate, start)
+			return
+		}
+	}
+	if s.cfg.FreshnessWeight > 0 && rag.IsTimeSensitive(query) {
+		results = rag.ApplyFreshness(results, rag.Freshness{Weight: s.cfg.FreshnessWeight, HalfLife: s.cfg.FreshnessHalfLife})
+		log.Printf("req_id=%s chat freshness=true top_score=%.4f", reqID, topScore(results))
+	}
+	results = s.diversify(results)
+	results = ix.Docs.Expand(results, rag.ExpandOptions{Mode: s.cfg.ExpandMode, Window: s.cfg.ExpandWindow, MaxChars: s.cfg.ExpandMaxChars})
+
+	if g, ok := s.extractiveAnswer(ctx, standalone, results); ok {
+		resp := chatResponse{
+			Answer:         g.Answer,
+			Sources:        g.Sources,
+			Citations:      g.Citations,
+			ConversationID: req.ConversationID,
+			Model:          g.Model,
+			AnswerType:     answerGrounded,
+		}
+		if wantsStream(r) {
+			writeStreamResult(w, resp)
+		} else {
+			writeJSON(w, resp)
+		}
+		s.rememberTurn(ctx, sess, req.Question, g.Answer, results)
+		s.logChat(ctx, req.Question, answerGrounded, g, results, &gate, start)
+		log.Printf("req_id=%s chat done=%s extractive=true", reqID, fmtDuration(time.Since(start)))
 		return
 	}
 
@@ -148,13 +269,18 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 		if stream == nil {
 			stream = s.generateAnswerStream
 		}
-		answerType, err := stream(ctx, req.Question, results, w)
+		g, err := stream(ctx, req.Question, history, results, w)
 		if err != nil {
+			if s.cfg.DegradedAnswers && errors.Is(err, errGenerationUnavailable) {
+				s.writeDegraded(ctx, w, r, req, sess, query, results, answerSearchOnly, &gate, start)
+				return
+			}
 			http.Error(w, "streaming error", http.StatusInternalServerError)
 			return
 		}
-		s.logChat(ctx, req.Question, answerType, results, start)
-		log.Printf("req_id=%s chat done=%s streamed=true", reqID, fmtDuration(time.Since(start)))
+		s.rememberTurn(ctx, sess, req.Question, g.Answer, results)
+		s.logChat(ctx, req.Question, answerTypeOf(g), g, results, &gate, start)
+		log.Printf("req_id=%s chat done=%s streamed=true model=%s", reqID, fmtDuration(time.Since(start)), g.Model)
 		return
 	}
 
@@ -163,127 +289,215 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 		answerFn = s.generateAnswer
 	}
 	tAnswer := time.Now()
-	answer, sources, err := answerFn(ctx, req.Question, results)
+	g, err := answerFn(ctx, req.Question, history, results)
 	if err != nil {
+		log.Printf("req_id=%s chat generation error=%q", reqID, err.Error())
+		if s.cfg.DegradedAnswers {
+			s.writeDegraded(ctx, w, r, req, sess, query, results, answerSearchOnly, &gate, start)
+			return
+		}
 		http.Error(w, "generation error", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("req_id=%s chat answer=%s sources=%d", reqID, fmtDuration(time.Since(tAnswer)), len(sources))
+	log.Printf("req_id=%s chat answer=%s sources=%d model=%s", reqID, fmtDuration(time.Since(tAnswer)), len(g.Sources), g.Model)
 
 	writeJSON(w, chatResponse{
-		Answer:  answer,
-		Sources: sources,
+		Answer:         g.Answer,
+		Sources:        g.Sources,
+		Citations:      g.Citations,
+		ConversationID: req.ConversationID,
+		Model:          g.Model,
+		AnswerType:     answerTypeOf(g),
 	})
-	answerType := "grounded"
-	if isFallbackAnswer(answer, sources) {
-		answerType = "no_answer"
-	}
-	s.logChat(ctx, req.Question, answerType, results, start)
-	log.Printf("req_id=%s chat done=%s fallback=%t", reqID, fmtDuration(time.Since(start)), answerType == "no_answer")
+	s.rememberTurn(ctx, sess, req.Question, g.Answer, results)
+	answerType := answerTypeOf(g)
+	s.logChat(ctx, req.Question, answerType, g, results, &gate, start)
+	log.Printf("req_id=%s chat done=%s fallback=%t", reqID, fmtDuration(time.Since(start)), answerType == answerNoAnswer)
 }
 
-func (s *Server) generateAnswer(ctx context.Context, question string, hits []rag.ScoredChunk) (string, []sourceItem, error) {
-	prompt, ordered := buildPrompt(question, hits, s.cfg.TopK)
-	req := chatCompletionRequest{
+func (s *Server) generateAnswer(ctx context.Context, question string, history []chatTurn, hits []rag.ScoredChunk) (generated, error) {
+	prompt, ordered, stats := buildPrompt(question, history, hits, s.cfg.TopK, s.cfg.ContextMaxTokens)
+	logContextStats(ctx, stats)
+	req := CompletionRequest{
 		Model: s.cfg.ChatModel,
-		Messages: []chatMessage{
+		Messages: []Message{
 			{Role: "system", Content: "You must follow the instructions. Output JSON."},
 			{Role: "user", Content: prompt},
 		},
 		Temperature: 0.2,
-		ResponseFormat: &chatResponseFormat{
-			Type: "json_object",
-		},
+		JSON:        true,
+		Schema:      answerSchema,
 	}
 
-	raw, err := s.callChatCompletion(ctx, req)
+	raw, route, err := s.generation().Complete(ctx, req)
 	if err != nil {
-		return "", nil, err
+		return generated{}, err
 	}
 
-	var out struct {
-		Answer  string       `json:"answer"`
-		Sources []sourceItem `json:"sources"`
-	}
-	if err := json.Unmarshal([]byte(raw), &out); err != nil {
-		return "", nil, fmt.Errorf("invalid model json: %w", err)
-	}
-	out.Answer = strings.TrimSpace(out.Answer)
-
-	cleanSources := filterSources(ordered, out.Sources, s.cfg.MaxSources)
-	if out.Answer == "" {
-		out.Answer = fallbackAnswer
-		cleanSources = nil
+	out, route, err := s.decodeAnswer(ctx, req, raw, route)
+	if err != nil {
+		return generated{Model: route.String()}, err
 	}
-
-	return out.Answer, cleanSources, nil
+	return s.finishAnswer(ctx, out.Answer, out.Sources, ordered, route.String()), nil
 }
 
-func (s *Server) generateAnswerStream(ctx context.Context, question string, hits []rag.ScoredChunk, w http.ResponseWriter) (string, error) {
+func (s *Server) generateAnswerStream(ctx context.Context, question string, history []chatTurn, hits []rag.ScoredChunk, w http.ResponseWriter) (generated, error) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		return "grounded", fmt.Errorf("streaming not supported")
+		return generated{}, fmt.Errorf("streaming not supported")
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	prompt, ordered := buildPrompt(question, hits, s.cfg.TopK)
-	req := chatCompletionRequest{
+	prompt, ordered, stats := buildPrompt(question, history, hits, s.cfg.TopK, s.cfg.ContextMaxTokens)
+	logContextStats(ctx, stats)
+	req := CompletionRequest{
 		Model: s.cfg.ChatModel,
-		Messages: []chatMessage{
+		Messages: []Message{
 			{Role: "system", Content: "You must follow the instructions. Output JSON."},
 			{Role: "user", Content: prompt},
 		},
 		Temperature: 0.2,
-		ResponseFormat: &chatResponseFormat{
-			Type: "json_object",
-		},
-		Stream: true,
+		JSON:        true,
+		Schema:      answerSchema,
 	}
 
+	// Only the decoded answer text, without citation markers, is sent as
+	// "delta"; the model's source picks follow as "sources" once their array is complete. With
+	// verification on, nothing is streamed: claims the verifier drops must
+	// not reach the client, so only the result event is sent.
+	answer := newAnswerStream(func(text string) {
+		_ = writeSSEEvent(w, "delta", map[string]string{"delta": text})
+		flusher.Flush()
+	}, func(picked []sourceItem) {
+		if sources := filterSources(ordered, picked, s.cfg.MaxSources); len(sources) > 0 {
+			_ = writeSSEEvent(w, "sources", map[string][]sourceItem{"sources": sources})
+			flusher.Flush()
+		}
+	})
+	answer.markers = len(ordered)
 	var full strings.Builder
-	err := s.callChatCompletionStream(ctx, req, func(delta string) {
+	route, err := s.generation().Stream(ctx, req, func(delta string) {
 		if delta == "" {
 			return
 		}
 		full.WriteString(delta)
-		_ = writeSSEEvent(w, "delta", map[string]string{"delta": delta})
-		flusher.Flush()
+		if s.verifier == nil {
+			answer.Write(delta)
+		}
 	})
 	if err != nil {
+		if full.Len() == 0 && s.cfg.DegradedAnswers {
+			// Nothing sent yet: let the handler answer from search results.
+			return generated{}, fmt.Errorf("%w: %w", errGenerationUnavailable, err)
+		}
 		_ = writeSSEEvent(w, "error", map[string]string{"error": err.Error()})
 		flusher.Flush()
-		return "grounded", err
+		return generated{}, err
 	}
 
-	var out struct {
-		Answer  string       `json:"answer"`
-		Sources []sourceItem `json:"sources"`
-	}
-	if err := json.Unmarshal([]byte(full.String()), &out); err != nil {
-		_ = writeSSEEvent(w, "error", map[string]string{"error": "invalid model json"})
+	// The streamed text may still be repaired or retried below; the result
+	// event replaces it and the streamed sources with the final answer.
+	out, route, err := s.decodeAnswer(ctx, req, full.String(), route)
+	if err != nil {
+		_ = writeSSEEvent(w, "error", map[string]string{"error": "invalid model output"})
 		flusher.Flush()
-		return "grounded", fmt.Errorf("invalid model json: %w", err)
+		return generated{Model: route.String()}, err
 	}
-	out.Answer = strings.TrimSpace(out.Answer)
-	cleanSources := filterSources(ordered, out.Sources, s.cfg.MaxSources)
-	if out.Answer == "" {
-		out.Answer = fallbackAnswer
-		cleanSources = nil
-	}
-
+	g := s.finishAnswer(ctx, out.Answer, out.Sources, ordered, route.String())
 	if err := writeSSEEvent(w, "result", chatResponse{
-		Answer:  out.Answer,
-		Sources: cleanSources,
+		Answer:     g.Answer,
+		Sources:    g.Sources,
+		Citations:  g.Citations,
+		Model:      g.Model,
+		AnswerType: answerTypeOf(g),
 	}); err != nil {
-		return "grounded", err
+		return g, err
+	}
+	return g, nil
+}
+
+// generation returns the answer chain; servers built without NewServer
+// (tests) get a single route over s.llm.
+func (s *Server) generation() *llmChain {
+	if s.chain != nil {
+		return s.chain
 	}
-	if isFallbackAnswer(out.Answer, cleanSources) {
-		return "no_answer", nil
+	return singleRoute(s.cfg, s.llm)
+}
+
+func answerTypeOf(g generated) string {
+	if isFallbackAnswer(g.Answer, g.Sources) {
+		return answerNoAnswer
+	}
+	return answerGrounded
+}
+
+func (s *Server) writeNoAnswer(ctx context.Context, w http.ResponseWriter, req chatRequest, sess *storage.Session, results []rag.ScoredChunk, gate *rag.GateDecision, start time.Time) {
+	writeJSON(w, chatResponse{
+		Answer:         fallbackAnswer,
+		Sources:        nil,
+		ConversationID: req.ConversationID,
+		AnswerType:     answerNoAnswer,
+	})
+	s.rememberTurn(ctx, sess, req.Question, fallbackAnswer, results)
+	s.logChat(ctx, req.Question, answerNoAnswer, generated{}, results, gate, start)
+	log.Printf("req_id=%s chat done=%s fallback=true", rag.RequestID(ctx), fmtDuration(time.Since(start)))
+}
+
+// gatePolicy builds the relevance gate from config. A zero GateStrongScore
+// reduces it to the plain MinScore threshold.
+func (s *Server) gatePolicy() rag.GatePolicy {
+	return rag.GatePolicy{
+		MinScore:     s.cfg.MinScore,
+		StrongScore:  s.cfg.GateStrongScore,
+		MinMargin:    s.cfg.GateMinMargin,
+		LexicalCheck: s.cfg.GateLexical,
+		Calibration:  s.calibration,
 	}
-	return "grounded", nil
+}
+
+// LoadCalibration installs a gate calibration; call before serving.
+func (s *Server) LoadCalibration(path string) error {
+	c, err := rag.LoadCalibration(path)
+	if err != nil {
+		return err
+	}
+	s.calibration = c
+	return nil
+}
+
+func logGate(ctx context.Context, d rag.GateDecision) {
+	sig := d.Signals
+	log.Printf("req_id=%s chat gate answer=%t reason=%s top=%.4f margin=%.4f lexical_agree=%t lexical_top=%.2f query_terms=%d p=%.3f",
+		rag.RequestID(ctx), d.Answer, d.Reason, sig.Top, sig.Margin, sig.LexicalAgree, sig.LexicalTop, sig.QueryTerms, sig.Probability)
+}
+
+// diversify applies MMR over the candidate pool so near-identical chunks
+// (e.g. sibling monthly weather posts) don't fill every prompt slot.
+func (s *Server) diversify(results []rag.ScoredChunk) []rag.ScoredChunk {
+	if l := s.cfg.MMRLambda; l > 0 && l < 1 {
+		return rag.MMR(results, l, s.cfg.TopK)
+	}
+	if len(results) > s.cfg.TopK {
+		return results[:s.cfg.TopK]
+	}
+	return results
+}
+
+func entryChunks(entries []rag.Entry) []rag.Chunk {
+	chunks := make([]rag.Chunk, len(entries))
+	for i, e := range entries {
+		chunks[i] = e.Chunk
+	}
+	return chunks
+}
+
+func logContextStats(ctx context.Context, stats contextStats) {
+	log.Printf("req_id=%s chat context sources=%d tokens_in=%d tokens_out=%d trimmed_sources=%d",
+		rag.RequestID(ctx), stats.Sources, stats.TokensIn, stats.TokensOut, stats.TrimmedSources)
 }
 
 func isFallbackAnswer(answer string, sources []sourceItem) bool {
@@ -291,17 +505,19 @@ func isFallbackAnswer(answer string, sources []sourceItem) bool {
 }
 
 func writeStreamFallback(w http.ResponseWriter, answer string) {
+	writeStreamResult(w, chatResponse{Answer: answer, AnswerType: answerNoAnswer})
+}
+
+// writeStreamResult sends resp as a single SSE "result" event.
+func writeStreamResult(w http.ResponseWriter, resp chatResponse) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		writeJSON(w, chatResponse{Answer: answer})
+		writeJSON(w, resp)
 		return
 	}
 	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	_ = writeSSEEvent(w, "result", chatResponse{
-		Answer:  answer,
-		Sources: nil,
-	})
+	_ = writeSSEEvent(w, "result", resp)
 	flusher.Flush()
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"content-rag-chat/internal/rag"
+	"content-rag-chat/internal/storage"
 )
 
 func TestWithCORSPreflight(t *testing.T) {
@@ -174,7 +175,7 @@ func TestHandleChatLowScore(t *testing.T) {
 		embedFunc: func(ctx context.Context, question string) ([]float32, error) {
 			return []float32{1, 0, 0}, nil
 		},
-		searchFunc: func(entries []rag.Entry, q []float32, k int) []rag.ScoredChunk {
+		searchFunc: func(entries []rag.Entry, q []float32, k int, filter rag.Filter) []rag.ScoredChunk {
 			return []rag.ScoredChunk{
 				{Chunk: rag.Chunk{Title: "A", URL: "https://a"}, Score: 0.1},
 			}
@@ -201,6 +202,61 @@ func TestHandleChatLowScore(t *testing.T) {
 	}
 }
 
+type recordingLogger struct {
+	records []storage.ChatLog
+}
+
+func (l *recordingLogger) Log(_ context.Context, rec storage.ChatLog) {
+	l.records = append(l.records, rec)
+}
+
+func TestHandleChatGateRecordsDecision(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := &Server{
+		cfg: Config{
+			TopK:            3,
+			MaxSources:      2,
+			MinScore:        0.25,
+			GateStrongScore: 0.4,
+			GateMinMargin:   0.05,
+			GateLexical:     true,
+		},
+		logger: logger,
+		embedFunc: func(ctx context.Context, question string) ([]float32, error) {
+			return []float32{1, 0, 0}, nil
+		},
+		searchFunc: func(entries []rag.Entry, q []float32, k int, filter rag.Filter) []rag.ScoredChunk {
+			return []rag.ScoredChunk{
+				{Chunk: rag.Chunk{DocID: 1, Title: "A", URL: "https://a"}, Score: 0.31},
+				{Chunk: rag.Chunk{DocID: 2, Title: "B", URL: "https://b"}, Score: 0.30},
+			}
+		},
+		answerFunc: func(ctx context.Context, question string, _ []chatTurn, hits []rag.ScoredChunk) (generated, error) {
+			t.Fatal("ambiguous retrieval should not reach generation")
+			return generated{}, nil
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/chat", bytes.NewBufferString(`{"question":"what is the best paella","lang":"en"}`))
+	srv.handleChat(rec, req)
+
+	var out chatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Answer != fallbackAnswer {
+		t.Fatalf("expected fallback, got %q", out.Answer)
+	}
+	if len(logger.records) != 1 {
+		t.Fatalf("expected one log record, got %d", len(logger.records))
+	}
+	got := logger.records[0]
+	if got.AnswerType != "no_answer" || got.GateReason != rag.GateAmbiguous || !strings.Contains(got.GateSignals, `"margin"`) {
+		t.Fatalf("gate decision not recorded: %+v", got)
+	}
+}
+
 func TestHandleChatSuccess(t *testing.T) {
 	srv := &Server{
 		cfg: Config{
@@ -210,13 +266,13 @@ func TestHandleChatSuccess(t *testing.T) {
 		embedFunc: func(ctx context.Context, question string) ([]float32, error) {
 			return []float32{1, 0, 0}, nil
 		},
-		searchFunc: func(entries []rag.Entry, q []float32, k int) []rag.ScoredChunk {
+		searchFunc: func(entries []rag.Entry, q []float32, k int, filter rag.Filter) []rag.ScoredChunk {
 			return []rag.ScoredChunk{
 				{Chunk: rag.Chunk{Title: "Post A", URL: "https://a"}, Score: 0.9},
 			}
 		},
-		answerFunc: func(ctx context.Context, question string, hits []rag.ScoredChunk) (string, []sourceItem, error) {
-			return "Answer", []sourceItem{{Title: "Post A", URL: "https://a"}}, nil
+		answerFunc: func(ctx context.Context, question string, _ []chatTurn, hits []rag.ScoredChunk) (generated, error) {
+			return generated{Answer: "Answer", Sources: []sourceItem{{Title: "Post A", URL: "https://a"}}}, nil
 		},
 	}
 
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"content-rag-chat/internal/rag"
+	"content-rag-chat/internal/storage"
+)
+
+// UseSessionStore replaces the default in-memory session store (e.g. with
+// Postgres); call before serving. A nil store disables sessions.
+func (s *Server) UseSessionStore(st storage.SessionStore) {
+	s.sessions = st
+}
+
+// loadSession returns the request's session, or a new one when the id is
+// missing, unknown or expired. It returns nil when sessions are disabled or
+// the store fails, in which case the request is served statelessly.
+func (s *Server) loadSession(ctx context.Context, req chatRequest) *storage.Session {
+	if s.sessions == nil {
+		return nil
+	}
+	if req.ConversationID != "" {
+		sess, err := s.sessions.Get(ctx, req.ConversationID)
+		if err == nil {
+			return sess
+		}
+		if !errors.Is(err, storage.ErrSessionNotFound) {
+			log.Printf("req_id=%s chat session error=%q", rag.RequestID(ctx), err.Error())
+			return nil
+		}
+	}
+	now := time.Now()
+	return &storage.Session{ID: newSessionID(), CreatedAt: now, ExpiresAt: now.Add(s.cfg.SessionTTL)}
+}
+
+// rememberTurn appends the question, the answer and the retrieved sources
+// to the session and extends its expiry. Sessions never hold raw user
+// input: the question is redacted, and so is the answer, which may repeat
+// it, keeping only times, prices and the retrieved source URLs.
+func (s *Server) rememberTurn(ctx context.Context, sess *storage.Session, question, answer string, results []rag.ScoredChunk) {
+	if sess == nil {
+		return
+	}
+	now := time.Now()
+	sources, _ := topSources(results, s.cfg.MaxSources)
+	sess.Turns = append(sess.Turns,
+		storage.SessionTurn{Role: roleUser, Content: SanitizeQuestion(question), At: now},
+		storage.SessionTurn{Role: roleAssistant, Content: SanitizeAnswer(answer, sources), Sources: sources, At: now},
+	)
+	if keep := s.cfg.HistoryMaxTurns; len(sess.Turns) > keep {
+		sess.Turns = sess.Turns[len(sess.Turns)-max(keep, 0):]
+	}
+	sess.ExpiresAt = now.Add(s.cfg.SessionTTL)
+	if err := s.sessions.Save(ctx, sess); err != nil {
+		log.Printf("req_id=%s chat session save error=%q", rag.RequestID(ctx), err.Error())
+	}
+}
+
+// handleDeleteSession forgets a conversation: DELETE /chat/session?conversation_id=...
+// Deleting an unknown or expired id succeeds, so clients can always call it on close.
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("conversation_id")
+	if !validConversationID(id) {
+		http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+		return
+	}
+	if s.sessions != nil {
+		if err := s.sessions.Delete(r.Context(), id); err != nil {
+			log.Printf("req_id=%s chat session delete error=%q", rag.RequestID(r.Context()), err.Error())
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Printf("req_id=%s chat session deleted", rag.RequestID(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSessionSweeper removes expired sessions every interval until ctx is done.
+func (s *Server) RunSessionSweeper(ctx context.Context, every time.Duration) {
+	if s.sessions == nil || every <= 0 {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := s.sessions.Sweep(ctx)
+			if err != nil {
+				log.Printf("session sweep error=%q", err.Error())
+			} else if n > 0 {
+				log.Printf("session sweep expired=%d", n)
+			}
+		}
+	}
+}
+
+// newSessionID returns 32 random hex characters.
+func newSessionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return newReqID()
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,318 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"content-rag-chat/internal/rag"
+)
+
+// JSONSchema is a named JSON schema for structured output. Providers that
+// support it (OpenAI json_schema) enforce it strictly; others fall back to
+// plain JSON mode.
+type JSONSchema struct {
+	Name   string
+	Schema json.RawMessage
+}
+
+// answerSchema is the shape generation must produce.
+var answerSchema = &JSONSchema{
+	Name: "answer",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "answer": {"type": "string"},
+    "sources": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {"title": {"type": "string"}, "url": {"type": "string"}},
+        "required": ["title", "url"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["answer", "sources"],
+  "additionalProperties": false
+}`),
+}
+
+// Structured output outcomes, counted per kind. The first five are failure
+// modes of a single reply; the rest describe how the request ended.
+const (
+	outputCodeFence   = "code_fence"       // wrapped in ``` fences or prose
+	outputTruncated   = "truncated"        // cut off mid-object
+	outputInvalidJSON = "invalid_json"     // not JSON, even after repair
+	outputSchema      = "schema_violation" // JSON of the wrong shape
+	outputEmptyAnswer = "empty_answer"
+	outputRepaired    = "repaired" // accepted after a local repair
+	outputRetried     = "retried"  // second call with an error hint
+	outputRetryOK     = "retry_ok" // the retry produced a valid reply
+	outputGaveUp      = "gave_up"  // both attempts failed
+)
+
+// outputError is a reply that failed validation; Kind is one of the
+// failure modes above.
+type outputError struct {
+	Kind string
+	Err  error
+}
+
+func (e *outputError) Error() string { return e.Kind + ": " + e.Err.Error() }
+
+func (e *outputError) Unwrap() error { return e.Err }
+
+// modelAnswer is the validated generation output.
+type modelAnswer struct {
+	Answer  string       `json:"answer"`
+	Sources []sourceItem `json:"sources"`
+}
+
+// outputStats counts structured output outcomes since start.
+type outputStats struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (o *outputStats) add(kind string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.counts == nil {
+		o.counts = map[string]int64{}
+	}
+	o.counts[kind]++
+}
+
+func (o *outputStats) snapshot() map[string]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := make(map[string]int64, len(o.counts))
+	for k, v := range o.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// parseModelAnswer validates raw against answerSchema, repairing code
+// fences, leading prose and truncation first. repairs lists the repairs
+// that were needed. A reply cut off after the answer string is closed
+// locally; one cut off inside it is an error, as the answer is incomplete.
+func parseModelAnswer(raw string) (ans modelAnswer, repairs []string, err error) {
+	s := strings.TrimSpace(raw)
+	if unwrapped := unwrapJSON(s); unwrapped != s {
+		s = unwrapped
+		repairs = append(repairs, outputCodeFence)
+	}
+	if !json.Valid([]byte(s)) {
+		if strings.HasPrefix(s, "{") && !answerComplete(s) {
+			return modelAnswer{}, repairs, &outputError{Kind: outputTruncated, Err: errors.New(`reply cut off inside "answer"`)}
+		}
+		closed, ok := closeTruncated(s)
+		if !ok {
+			return modelAnswer{}, repairs, &outputError{Kind: outputInvalidJSON, Err: errors.New("reply is not a JSON object")}
+		}
+		s = closed
+		repairs = append(repairs, outputTruncated)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return modelAnswer{}, repairs, &outputError{Kind: outputSchema, Err: errors.New("reply is not a JSON object")}
+	}
+	rawAnswer, ok := fields["answer"]
+	if !ok {
+		return modelAnswer{}, repairs, &outputError{Kind: outputSchema, Err: errors.New(`missing "answer"`)}
+	}
+	if err := json.Unmarshal(rawAnswer, &ans.Answer); err != nil {
+		return modelAnswer{}, repairs, &outputError{Kind: outputSchema, Err: errors.New(`"answer" must be a string`)}
+	}
+	rawSources, ok := fields["sources"]
+	if !ok {
+		return modelAnswer{}, repairs, &outputError{Kind: outputSchema, Err: errors.New(`missing "sources"`)}
+	}
+	if err := json.Unmarshal(rawSources, &ans.Sources); err != nil || string(rawSources) == "null" {
+		return modelAnswer{}, repairs, &outputError{Kind: outputSchema, Err: errors.New(`"sources" must be an array of {title, url} strings`)}
+	}
+	if strings.TrimSpace(ans.Answer) == "" {
+		return modelAnswer{}, repairs, &outputError{Kind: outputEmptyAnswer, Err: errors.New(`"answer" is empty`)}
+	}
+	return ans, repairs, nil
+}
+
+// unwrapJSON strips ``` fences and any prose around the outermost object.
+func unwrapJSON(s string) string {
+	if strings.HasPrefix(s, "```") {
+		s = strings.TrimPrefix(s, "```")
+		s = strings.TrimPrefix(s, "json")
+		s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+		s = strings.TrimSpace(s)
+	}
+	if i := strings.Index(s, "{"); i > 0 {
+		s = s[i:]
+	}
+	if i := strings.LastIndex(s, "}"); i >= 0 && i < len(s)-1 && json.Valid([]byte(s[:i+1])) {
+		s = s[:i+1]
+	}
+	return s
+}
+
+// answerComplete reports whether the top-level "answer" string of a
+// possibly truncated reply was closed.
+func answerComplete(s string) bool {
+	a := newAnswerStream(nil, nil)
+	a.Write(s)
+	return a.answered
+}
+
+// closeTruncated completes JSON cut off mid-reply: it closes an open string
+// and every open container. If that is not enough, it drops the last
+// incomplete member and tries again.
+func closeTruncated(s string) (string, bool) {
+	if !strings.HasPrefix(s, "{") {
+		return "", false
+	}
+	for tries := 0; tries < 32; tries++ {
+		if c := closeJSON(s); json.Valid([]byte(c)) {
+			return c, true
+		}
+		i := lastCommaOutsideString(s)
+		if i < 0 {
+			return "", false
+		}
+		s = s[:i]
+	}
+	return "", false
+}
+
+func closeJSON(s string) string {
+	var stack []byte
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			stack = append(stack, c)
+		case (c == '}' || c == ']') && len(stack) > 0:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(s)
+	if inString {
+		if escaped {
+			trimmed := strings.TrimSuffix(sb.String(), `\`)
+			sb.Reset()
+			sb.WriteString(trimmed)
+		}
+		sb.WriteByte('"')
+	}
+	out := strings.TrimRight(sb.String(), " \t\r\n")
+	out = strings.TrimSuffix(out, ",")
+	if strings.HasSuffix(out, ":") {
+		out += "null"
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == '{' {
+			out += "}"
+		} else {
+			out += "]"
+		}
+	}
+	return out
+}
+
+func lastCommaOutsideString(s string) int {
+	last := -1
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && c == ',':
+			last = i
+		}
+	}
+	return last
+}
+
+// decodeAnswer validates the model's first reply and, if it fails even
+// after local repair, retries once with the validation error as a hint.
+// Every failure mode and outcome is counted in s.outputStats.
+func (s *Server) decodeAnswer(ctx context.Context, req CompletionRequest, raw string, route llmRoute) (modelAnswer, llmRoute, error) {
+	ans, err := s.checkOutput(ctx, raw)
+	if err == nil {
+		return ans, route, nil
+	}
+	s.outputStats.add(outputRetried)
+	retry := req
+	retry.Messages = append(append([]Message(nil), req.Messages...),
+		Message{Role: "assistant", Content: raw},
+		Message{Role: "user", Content: fmt.Sprintf("Your reply was rejected (%s). Reply again with only a JSON object of the form {\"answer\": string, \"sources\": [{\"title\": string, \"url\": string}]}.", err.Error())},
+	)
+	raw, route, callErr := s.generation().Complete(ctx, retry)
+	if callErr != nil {
+		s.outputStats.add(outputGaveUp)
+		return modelAnswer{}, route, fmt.Errorf("invalid model output (%w), retry failed: %v", err, callErr)
+	}
+	ans, err = s.checkOutput(ctx, raw)
+	if err != nil {
+		s.outputStats.add(outputGaveUp)
+		return modelAnswer{}, route, fmt.Errorf("invalid model output after retry: %w", err)
+	}
+	s.outputStats.add(outputRetryOK)
+	return ans, route, nil
+}
+
+// checkOutput parses one reply, counting and logging what went wrong.
+func (s *Server) checkOutput(ctx context.Context, raw string) (modelAnswer, error) {
+	ans, repairs, err := parseModelAnswer(raw)
+	for _, r := range repairs {
+		s.outputStats.add(r)
+	}
+	var oe *outputError
+	if errors.As(err, &oe) {
+		s.outputStats.add(oe.Kind)
+	}
+	if err == nil && len(repairs) > 0 {
+		s.outputStats.add(outputRepaired)
+	}
+	if err != nil || len(repairs) > 0 {
+		errText := ""
+		if err != nil {
+			errText = err.Error()
+		}
+		log.Printf("req_id=%s chat output repairs=%q error=%q bytes=%d", rag.RequestID(ctx), strings.Join(repairs, ","), errText, len(raw))
+	}
+	return ans, err
+}
+
+// OutputStats returns the structured output counters.
+func (s *Server) OutputStats() map[string]int64 {
+	return s.outputStats.snapshot()
+}
+
+func (s *Server) handleOutputStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]any{"structured_output": s.OutputStats()})
+}
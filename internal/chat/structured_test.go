@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"content-rag-chat/internal/rag"
+)
+
+func TestParseModelAnswer(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		answer  string
+		repairs string
+		kind    string
+	}{
+		{name: "valid", raw: `{"answer":"Hi.","sources":[{"title":"T","url":"https://a"}]}`, answer: "Hi."},
+		{name: "fenced", raw: "```json\n{\"answer\":\"Hi.\",\"sources\":[]}\n```", answer: "Hi.", repairs: outputCodeFence},
+		{name: "prose", raw: `Sure! {"answer":"Hi.","sources":[]} Hope that helps.`, answer: "Hi.", repairs: outputCodeFence},
+		{name: "cut in answer", raw: `{"answer":"The bus runs every 20`, kind: outputTruncated},
+		{name: "cut after answer", raw: `{"answer":"Hi.","sources":[{"title":"T","url":"ht`, answer: "Hi.", repairs: outputTruncated},
+		{name: "cut in sources", raw: `{"answer":"Hi.","sources":[{"title":"T","url":"https://a"},{"title":"U","u`, answer: "Hi.", repairs: outputTruncated},
+		{name: "not json", raw: `I don't know.`, kind: outputInvalidJSON},
+		{name: "wrong type", raw: `{"answer":["Hi."],"sources":[]}`, kind: outputSchema},
+		{name: "bad sources", raw: `{"answer":"Hi.","sources":"https://a"}`, kind: outputSchema},
+		{name: "missing answer", raw: `{"text":"Hi."}`, kind: outputSchema},
+		{name: "missing sources", raw: `{"answer":"Hi."}`, kind: outputSchema},
+		{name: "empty", raw: `{"answer":"  ","sources":[]}`, kind: outputEmptyAnswer},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ans, repairs, err := parseModelAnswer(tc.raw)
+			if tc.kind != "" {
+				var oe *outputError
+				if !errors.As(err, &oe) || oe.Kind != tc.kind {
+					t.Fatalf("expected %s, got %v", tc.kind, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ans.Answer != tc.answer || strings.Join(repairs, ",") != tc.repairs {
+				t.Fatalf("got %q repairs=%v", ans.Answer, repairs)
+			}
+		})
+	}
+}
+
+func TestGenerateAnswerRetriesInvalidOutput(t *testing.T) {
+	hits := []rag.ScoredChunk{{Chunk: rag.Chunk{Title: "Airport bus", URL: "https://a", Text: "The C6 bus runs every 20 minutes."}, Score: 0.9}}
+
+	llm := &ScriptedLLM{Replies: []string{`{"answer":42}`, `{"answer":"The C6 bus runs every 20 minutes.","sources":[{"title":"Airport bus","url":"https://a"}]}`}}
+	srv := &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m"}, llm: llm}
+	g, err := srv.generateAnswer(context.Background(), "How often does the bus run?", nil, hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Answer != "The C6 bus runs every 20 minutes." || len(llm.Calls) != 2 {
+		t.Fatalf("unexpected result %+v after %d calls", g, len(llm.Calls))
+	}
+	if llm.Calls[0].Schema != answerSchema {
+		t.Fatalf("expected the answer schema on the request")
+	}
+	retry := llm.Calls[1].Messages
+	if last := retry[len(retry)-1]; last.Role != "user" || !strings.Contains(last.Content, outputSchema) || retry[len(retry)-2].Content != `{"answer":42}` {
+		t.Fatalf("unexpected retry messages %+v", retry)
+	}
+	stats := srv.OutputStats()
+	if stats[outputSchema] != 1 || stats[outputRetried] != 1 || stats[outputRetryOK] != 1 || stats[outputGaveUp] != 0 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+
+	// A repairable reply needs no retry; two bad replies give up.
+	llm = &ScriptedLLM{Replies: []string{"```json\n{\"answer\":\"The C6 bus runs every 20 minutes.\",\"sources\":[]}\n```"}}
+	srv = &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m"}, llm: llm}
+	if _, err := srv.generateAnswer(context.Background(), "q", nil, hits); err != nil || len(llm.Calls) != 1 {
+		t.Fatalf("expected a repaired answer, err=%v calls=%d", err, len(llm.Calls))
+	}
+	if stats := srv.OutputStats(); stats[outputCodeFence] != 1 || stats[outputRepaired] != 1 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+
+	llm = &ScriptedLLM{Replies: []string{`no json here`}}
+	srv = &Server{cfg: Config{TopK: 3, MaxSources: 2, ChatModel: "m"}, llm: llm}
+	if _, err := srv.generateAnswer(context.Background(), "q", nil, hits); err == nil || len(llm.Calls) != 2 {
+		t.Fatalf("expected to give up after one retry, err=%v calls=%d", err, len(llm.Calls))
+	}
+	if stats := srv.OutputStats(); stats[outputInvalidJSON] != 2 || stats[outputGaveUp] != 1 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+func TestOpenAIStrictSchema(t *testing.T) {
+	req := CompletionRequest{Model: "m", JSON: true, Schema: answerSchema}
+	wire := (&openAILLM{strictSchema: true}).wireRequest(req, false)
+	if wire.ResponseFormat.Type != "json_schema" || !wire.ResponseFormat.JSONSchema.Strict || wire.ResponseFormat.JSONSchema.Name != "answer" {
+		t.Fatalf("unexpected response format %+v", wire.ResponseFormat)
+	}
+	body, _ := json.Marshal(wire)
+	if !strings.Contains(string(body), `"additionalProperties":false`) {
+		t.Fatalf("schema not sent: %s", body)
+	}
+	if wire := (&openAILLM{}).wireRequest(req, false); wire.ResponseFormat.Type != "json_object" || wire.ResponseFormat.JSONSchema != nil {
+		t.Fatalf("expected json_object without strict schema, got %+v", wire.ResponseFormat)
+	}
+}
@@ -0,0 +1,220 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"content-rag-chat/internal/rag"
+)
+
+// Verifier decides which claims of a generated answer the source excerpts
+// support. Implementations return one verdict per claim.
+type Verifier interface {
+	Verify(ctx context.Context, claims []string, sources []promptSource) ([]bool, error)
+}
+
+// Verification modes for Config.Verify.
+const (
+	verifyLexical = "lexical"
+	verifyLLM     = "llm"
+)
+
+// lexicalSupportMin is the share of a claim's terms one excerpt must
+// contain for the lexical verifier to accept it.
+const lexicalSupportMin = 0.6
+
+func newVerifier(s *Server) Verifier {
+	switch s.cfg.Verify {
+	case verifyLexical:
+		return lexicalVerifier{}
+	case verifyLLM:
+		model := s.cfg.VerifyModel
+		if model == "" {
+			model = s.cfg.ChatModel
+		}
+		return &llmVerifier{srv: s, model: model}
+	default:
+		return nil
+	}
+}
+
+// lexicalVerifier accepts a claim when a single excerpt contains most of its
+// terms and every number it states.
+type lexicalVerifier struct{}
+
+func (lexicalVerifier) Verify(_ context.Context, claims []string, sources []promptSource) ([]bool, error) {
+	out := make([]bool, len(claims))
+	for i, c := range claims {
+		for _, src := range sources {
+			if rag.LexicalSupport(c, verifyText(src)) >= lexicalSupportMin {
+				out[i] = true
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// verifyText is what a claim is checked against: the source as the model
+// saw it, including the "Last updated" date that "as of" claims repeat.
+func verifyText(src promptSource) string {
+	text := src.Title + "\n" + src.Excerpt
+	if src.Modified != "" {
+		text += "\nLast updated: " + src.Modified
+	}
+	return text
+}
+
+// llmVerifier asks the chat model for an entailment verdict on every claim
+// in one batched call.
+type llmVerifier struct {
+	srv   *Server
+	model string
+}
+
+func (v *llmVerifier) Verify(ctx context.Context, claims []string, sources []promptSource) ([]bool, error) {
+	req := CompletionRequest{
+		Model: v.model,
+		Messages: []Message{
+			{Role: "system", Content: "You check answers against sources. Output JSON."},
+			{Role: "user", Content: buildVerifyPrompt(claims, sources)},
+		},
+		Temperature: 0,
+		JSON:        true,
+	}
+	raw, err := v.srv.auxComplete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseVerdicts(raw, len(claims))
+}
+
+func buildVerifyPrompt(claims []string, sources []promptSource) string {
+	var sb strings.Builder
+	sb.WriteString("For each claim, decide whether the sources entail it. A claim is supported only if the sources state it or it follows directly from them; ")
+	sb.WriteString("numbers, prices, times and names must match exactly.\n")
+	sb.WriteString("Respond in JSON: {\"claims\": [{\"id\": <claim number>, \"supported\": true|false}]} with one entry per claim.\n\nSources:\n")
+	for i, src := range sources {
+		fmt.Fprintf(&sb, "\n[%d] %s\n", i+1, verifyText(src))
+	}
+	sb.WriteString("\nClaims:\n")
+	for i, c := range claims {
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, c)
+	}
+	return sb.String()
+}
+
+// parseVerdicts maps the model's verdicts onto claims by number. Claims the
+// model skipped count as unsupported.
+func parseVerdicts(raw string, n int) ([]bool, error) {
+	var out struct {
+		Claims []struct {
+			ID        int  `json:"id"`
+			Supported bool `json:"supported"`
+		} `json:"claims"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("invalid verify json: %w", err)
+	}
+	verdicts := make([]bool, n)
+	for _, c := range out.Claims {
+		if c.ID >= 1 && c.ID <= n {
+			verdicts[c.ID-1] = c.Supported
+		}
+	}
+	return verdicts, nil
+}
+
+// verifyAnswer checks g against the excerpts the model saw and sets its
+// Faithfulness, the share of claims supported. Unsupported claims are
+// dropped; below VerifyMinSupport the answer becomes the fallback.
+// Sentences without content terms are kept and not counted. If the LLM
+// verifier fails, the lexical one stands in.
+func (s *Server) verifyAnswer(ctx context.Context, g generated, sources []promptSource) generated {
+	if s.verifier == nil || isFallbackAnswer(g.Answer, g.Sources) {
+		return g
+	}
+	sentences := rag.Sentences(g.Answer)
+	var claims []string
+	var claimIdx []int
+	for i, sent := range sentences {
+		if len(rag.Terms(sent)) > 0 {
+			claims = append(claims, sent)
+			claimIdx = append(claimIdx, i)
+		}
+	}
+	if len(claims) == 0 {
+		return g
+	}
+	verdicts, err := s.verifier.Verify(ctx, claims, sources)
+	if err != nil {
+		log.Printf("req_id=%s chat verify error=%q", rag.RequestID(ctx), err.Error())
+		verdicts, _ = lexicalVerifier{}.Verify(ctx, claims, sources)
+	}
+	supported := 0
+	drop := map[int]bool{}
+	for i, ok := range verdicts {
+		if ok {
+			supported++
+		} else {
+			drop[claimIdx[i]] = true
+		}
+	}
+	faithfulness := float32(supported) / float32(len(claims))
+	g.Faithfulness = &faithfulness
+
+	action := "keep"
+	switch {
+	case faithfulness < s.cfg.VerifyMinSupport:
+		action = "fallback"
+		g.Answer, g.Sources = fallbackAnswer, nil
+	case len(drop) > 0:
+		action = "drop"
+		g.Answer = removeSentences(g.Answer, sentences, drop)
+	}
+	log.Printf("req_id=%s chat verify claims=%d supported=%d faithfulness=%.2f action=%s", rag.RequestID(ctx), len(claims), supported, faithfulness, action)
+	return g
+}
+
+// removeSentences cuts the dropped sentences out of text, which sentences
+// (from rag.Sentences) splits line by line, and keeps the rest of the
+// layout: paragraph breaks and list lines survive, and a line left with
+// nothing but a list marker is removed.
+func removeSentences(text string, sentences []string, drop map[int]bool) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	next := 0
+	for _, line := range lines {
+		var sb strings.Builder
+		pos, cut := 0, false
+		for next < len(sentences) {
+			j := strings.Index(line[pos:], sentences[next])
+			if j < 0 {
+				break
+			}
+			start, end := pos+j, pos+j+len(sentences[next])
+			if drop[next] {
+				sb.WriteString(line[pos:start])
+				end += len(line[end:]) - len(strings.TrimLeft(line[end:], " \t"))
+				cut = true
+			} else {
+				sb.WriteString(line[pos:end])
+			}
+			pos = end
+			next++
+		}
+		sb.WriteString(line[pos:])
+		kept := strings.TrimRight(sb.String(), " \t")
+		if cut && strings.Trim(kept, " \t-*•0123456789.") == "" {
+			continue // the whole line was dropped
+		}
+		if kept == "" && len(out) > 0 && out[len(out)-1] == "" {
+			continue // a dropped paragraph leaves no double gap
+		}
+		out = append(out, kept)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var verifySources = []promptSource{{
+	Title:   "Airport bus",
+	URL:     "https://a",
+	Excerpt: "The C6 bus runs from the airport to the city centre every 20 minutes. A single ticket costs 3.85 €.",
+}}
+
+func TestVerifyAnswerDropsUnsupportedClaims(t *testing.T) {
+	srv := &Server{cfg: Config{Verify: verifyLexical, VerifyMinSupport: 0.5}}
+	srv.verifier = newVerifier(srv)
+	g := generated{
+		Answer:  "The C6 bus runs from the airport to the city centre every 20 minutes. A single ticket costs 3.85 €. The bus has free wifi on board.",
+		Sources: []sourceItem{{Title: "Airport bus", URL: "https://a"}},
+	}
+
+	got := srv.verifyAnswer(context.Background(), g, verifySources)
+	want := "The C6 bus runs from the airport to the city centre every 20 minutes. A single ticket costs 3.85 €."
+	if got.Answer != want || len(got.Sources) != 1 {
+		t.Fatalf("unexpected answer %q", got.Answer)
+	}
+	if got.Faithfulness == nil || *got.Faithfulness < 0.66 || *got.Faithfulness > 0.67 {
+		t.Fatalf("unexpected faithfulness %v", got.Faithfulness)
+	}
+}
+
+func TestVerifyAnswerKeepsLayout(t *testing.T) {
+	srv := &Server{cfg: Config{Verify: verifyLexical, VerifyMinSupport: 0.5}}
+	srv.verifier = newVerifier(srv)
+	g := generated{
+		Answer:  "The C6 bus runs from the airport to the city centre every 20 minutes. The bus has free wifi on board.\n\nTickets:\n- A single ticket costs 3.85 €.\n- Children ride free on weekends.\n\nThe bus has free wifi on board.\n\nA single ticket costs 3.85 €.",
+		Sources: []sourceItem{{Title: "Airport bus", URL: "https://a"}},
+	}
+	got := srv.verifyAnswer(context.Background(), g, verifySources)
+	want := "The C6 bus runs from the airport to the city centre every 20 minutes.\n\nTickets:\n- A single ticket costs 3.85 €.\n\nA single ticket costs 3.85 €."
+	if got.Answer != want {
+		t.Fatalf("layout lost: %q", got.Answer)
+	}
+}
+
+func TestVerifyAnswerFallsBackWhenUnsupported(t *testing.T) {
+	srv := &Server{cfg: Config{Verify: verifyLexical, VerifyMinSupport: 0.5}}
+	srv.verifier = newVerifier(srv)
+	g := generated{
+		Answer:  "A single ticket costs 5 €. Taxis wait at the marina.",
+		Sources: []sourceItem{{Title: "Airport bus", URL: "https://a"}},
+	}
+
+	got := srv.verifyAnswer(context.Background(), g, verifySources)
+	if got.Answer != fallbackAnswer || got.Sources != nil || got.Faithfulness == nil || *got.Faithfulness != 0 {
+		t.Fatalf("expected fallback, got %+v", got)
+	}
+}
+
+func TestLLMVerifier(t *testing.T) {
+	llm := &ScriptedLLM{Replies: []string{`{"claims":[{"id":1,"supported":true},{"id":2,"supported":false}]}`}}
+	srv := &Server{cfg: Config{Verify: verifyLLM, ChatModel: "m", VerifyMinSupport: 0.5}, llm: llm}
+	srv.verifier = newVerifier(srv)
+	g := generated{Answer: "The C6 bus runs every 20 minutes. It stops at the castle.", Sources: []sourceItem{{URL: "https://a"}}}
+
+	got := srv.verifyAnswer(context.Background(), g, verifySources)
+	if got.Answer != "The C6 bus runs every 20 minutes." {
+		t.Fatalf("unexpected answer %q", got.Answer)
+	}
+	if len(llm.Calls) != 1 || !llm.Calls[0].JSON || llm.Calls[0].Model != "m" {
+		t.Fatalf("unexpected calls %+v", llm.Calls)
+	}
+
+	// A failing verifier model falls back to the lexical check.
+	llm.Err = errors.New("down")
+	got = srv.verifyAnswer(context.Background(), g, verifySources)
+	if got.Answer != "The C6 bus runs every 20 minutes." || got.Faithfulness == nil {
+		t.Fatalf("expected lexical fallback, got %+v", got)
+	}
+}
+
+func TestLexicalVerifierAcceptsAsOfDate(t *testing.T) {
+	sources := []promptSource{{Title: verifySources[0].Title, URL: verifySources[0].URL, Excerpt: verifySources[0].Excerpt, Modified: "2024-05-01"}}
+	srv := &Server{cfg: Config{Verify: verifyLexical, VerifyMinSupport: 0.5}}
+	srv.verifier = newVerifier(srv)
+	g := generated{
+		Answer:  "A single ticket on the C6 bus costs 3.85 € as of 2024-05-01.",
+		Sources: []sourceItem{{Title: "Airport bus", URL: "https://a"}},
+	}
+
+	got := srv.verifyAnswer(context.Background(), g, sources)
+	if got.Answer != g.Answer || got.Faithfulness == nil || *got.Faithfulness != 1 {
+		t.Fatalf("expected the dated claim to be supported, got %+v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package rag
+
+import (
+	"math"
+	"sort"
+)
+
+// BM25 is a small in-memory lexical index over chunk titles and text,
+// used to cross-check vector retrieval.
+type BM25 struct {
+	chunks   []Chunk
+	lengths  []int
+	avgLen   float64
+	postings map[string][]bm25Posting
+}
+
+type bm25Posting struct {
+	doc  int
+	freq int
+}
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// NewBM25 indexes chunks with the same tokenizer as passage selection (Terms).
+func NewBM25(chunks []Chunk) *BM25 {
+	ix := &BM25{chunks: chunks, lengths: make([]int, len(chunks)), postings: map[string][]bm25Posting{}}
+	total := 0
+	for i, ch := range chunks {
+		freq := map[string]int{}
+		terms := Terms(ch.Title + "\n" + ch.Text)
+		for _, t := range terms {
+			freq[t]++
+		}
+		for t, f := range freq {
+			ix.postings[t] = append(ix.postings[t], bm25Posting{doc: i, freq: f})
+		}
+		ix.lengths[i] = len(terms)
+		total += len(terms)
+	}
+	if len(chunks) > 0 {
+		ix.avgLen = float64(total) / float64(len(chunks))
+	}
+	return ix
+}
+
+// Search returns the k best chunks passing filter for query. A nil index
+// returns nil.
+func (ix *BM25) Search(query string, k int, filter Filter) []ScoredChunk {
+	if ix == nil || k <= 0 || ix.avgLen == 0 {
+		return nil
+	}
+	scores := map[int]float64{}
+	n := float64(len(ix.chunks))
+	seen := map[string]bool{}
+	for _, t := range Terms(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		posts := ix.postings[t]
+		if len(posts) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(len(posts))+0.5)/(float64(len(posts))+0.5))
+		for _, p := range posts {
+			tf := float64(p.freq)
+			norm := tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(ix.lengths[p.doc])/ix.avgLen))
+			scores[p.doc] += idf * norm
+		}
+	}
+	results := make([]ScoredChunk, 0, len(scores))
+	for doc, s := range scores {
+		if !filter.Match(ix.chunks[doc]) {
+			continue
+		}
+		results = append(results, ScoredChunk{Chunk: ix.chunks[doc], Score: float32(s)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Chunk.ChunkID < results[j].Chunk.ChunkID
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
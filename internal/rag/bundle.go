@@ -0,0 +1,274 @@
+package rag
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BundleFormat is the bundle layout version written by WriteBundle.
+// Format 2 checksums the manifest fields as well as the payload files.
+const BundleFormat = 2
+
+// Files inside a bundle (a gzipped tar).
+const (
+	bundleManifestFile = "manifest.json"
+	bundleChunksFile   = "chunks.jsonl"
+	bundleVectorsFile  = "vectors.json"
+)
+
+// BundleManifest describes a bundle. Files maps each payload file to its
+// sha256; Checksum is the sha256 over the rest of the manifest, Files
+// included, and identifies the bundle.
+type BundleManifest struct {
+	Format     int               `json:"format"`
+	Model      string            `json:"model"`
+	ModelKey   string            `json:"model_key"` // cache section, e.g. model@256
+	Dims       int               `json:"dims"`
+	Template   string            `json:"template"`
+	Kinds      map[string]int    `json:"kinds"`
+	BuiltAt    string            `json:"built_at"`
+	CorpusHash string            `json:"corpus_hash"` // sha256 of the source chunk file
+	Chunks     int               `json:"chunks"`
+	Vectors    int               `json:"vectors"`
+	Files      map[string]string `json:"files"`
+	Checksum   string            `json:"checksum"`
+}
+
+// Bundle packages chunks with the vectors of one model so they cannot drift apart.
+type Bundle struct {
+	Manifest BundleManifest
+	Chunks   []Chunk
+	Cache    *EmbedCache // a single section, keyed by Manifest.ModelKey
+}
+
+// NewBundle selects the vectors of model at dims (see BuildIndexDims) for
+// chunks, dropping items of other chunks. Every chunk must have a valid body
+// vector unless allowMissing is set.
+func NewBundle(chunks []Chunk, cache *EmbedCache, model string, dims int, corpusHash string, allowMissing bool) (*Bundle, error) {
+	key := ModelKey(model, dims)
+	mv := cache.Models[key]
+	if mv == nil {
+		key, mv = model, cache.Models[model]
+	}
+	if mv == nil {
+		return nil, fmt.Errorf("cache has no vectors for model %s", model)
+	}
+	ids := make(map[string]bool, len(chunks))
+	for _, ch := range chunks {
+		ids[ch.ChunkID] = true
+	}
+	section := &ModelVectors{Kinds: mv.Kinds, Template: mv.Template, Items: map[string]EmbedCacheItem{}}
+	for k, it := range mv.Items {
+		if ids[it.ID] && CheckVector(it, 0) == "" {
+			section.Items[k] = it
+		}
+	}
+	var missing []string
+	for _, ch := range chunks {
+		if _, ok := section.Items[ch.ChunkID]; !ok {
+			missing = append(missing, ch.ChunkID)
+		}
+	}
+	if len(missing) > 0 && !allowMissing {
+		return nil, fmt.Errorf("%d chunks have no valid body vector (e.g. %s); run cmd/search to embed them", len(missing), missing[0])
+	}
+	b := &Bundle{
+		Chunks: chunks,
+		Cache:  &EmbedCache{Version: CacheVersion, Models: map[string]*ModelVectors{key: section}},
+		Manifest: BundleManifest{
+			Format:     BundleFormat,
+			Model:      model,
+			ModelKey:   key,
+			Dims:       commonDim(section.Items),
+			Template:   mv.Template,
+			Kinds:      mv.Kinds,
+			BuiltAt:    time.Now().UTC().Format(time.RFC3339),
+			CorpusHash: corpusHash,
+			Chunks:     len(chunks),
+			Vectors:    len(section.Items),
+		},
+	}
+	return b, nil
+}
+
+// WriteBundle writes b as a gzipped tar of manifest, chunks and vectors,
+// filling in the manifest checksums. The file is written then renamed.
+func WriteBundle(path string, b *Bundle) error {
+	var chunks bytes.Buffer
+	enc := json.NewEncoder(&chunks)
+	for _, ch := range b.Chunks {
+		ch.Alternates = nil
+		if err := enc.Encode(ch); err != nil {
+			return err
+		}
+	}
+	vectors, err := json.Marshal(b.Cache)
+	if err != nil {
+		return err
+	}
+	payload := map[string][]byte{bundleChunksFile: chunks.Bytes(), bundleVectorsFile: vectors}
+	b.Manifest.Files = map[string]string{}
+	for name, data := range payload {
+		b.Manifest.Files[name] = sha256Hex(data)
+	}
+	if b.Manifest.Checksum, err = manifestChecksum(b.Manifest); err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{bundleManifestFile, manifest}, {bundleChunksFile, payload[bundleChunksFile]}, {bundleVectorsFile, payload[bundleVectorsFile]}} {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadBundle loads a bundle and refuses it if any checksum, count or the
+// format does not match its manifest.
+func ReadBundle(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", path, err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bundle %s: %w", path, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("bundle %s: %w", path, err)
+		}
+		files[h.Name] = data
+	}
+
+	b := &Bundle{}
+	if err := json.Unmarshal(files[bundleManifestFile], &b.Manifest); err != nil {
+		return nil, fmt.Errorf("bundle %s: bad manifest: %w", path, err)
+	}
+	m := b.Manifest
+	if m.Format != BundleFormat {
+		return nil, fmt.Errorf("bundle %s: format %d, want %d", path, m.Format, BundleFormat)
+	}
+	for _, name := range []string{bundleChunksFile, bundleVectorsFile} {
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("bundle %s: missing %s", path, name)
+		}
+		if got := sha256Hex(data); got != m.Files[name] {
+			return nil, fmt.Errorf("bundle %s: %s checksum mismatch (corrupt or modified)", path, name)
+		}
+	}
+	if sum, err := manifestChecksum(m); err != nil || sum != m.Checksum {
+		return nil, fmt.Errorf("bundle %s: manifest checksum mismatch", path)
+	}
+
+	if b.Chunks, err = readChunksJSONL(bytes.NewReader(files[bundleChunksFile])); err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", path, err)
+	}
+	b.Cache = &EmbedCache{}
+	if err := json.Unmarshal(files[bundleVectorsFile], b.Cache); err != nil {
+		return nil, fmt.Errorf("bundle %s: bad vectors: %w", path, err)
+	}
+	mv := b.Cache.Models[m.ModelKey]
+	if len(b.Chunks) != m.Chunks || mv == nil || len(mv.Items) != m.Vectors {
+		return nil, fmt.Errorf("bundle %s: contents do not match manifest counts", path)
+	}
+	return b, nil
+}
+
+// VerifyBundle checks every vector in the bundle (dims, NaN, stale hashes)
+// and that no chunk lacks a body vector. It returns one line per problem.
+func VerifyBundle(b *Bundle) []string {
+	var problems []string
+	for _, r := range VerifyCache(b.Cache, b.Chunks) {
+		for p, n := range r.Counts() {
+			problems = append(problems, fmt.Sprintf("%s: %d %s vectors", r.Model, n, p))
+		}
+		if r.Dim != b.Manifest.Dims {
+			problems = append(problems, fmt.Sprintf("%s: dim %d, manifest says %d", r.Model, r.Dim, b.Manifest.Dims))
+		}
+		if missing := r.Chunks - r.Covered[KindBody]; missing > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %d chunks without a body vector", r.Model, missing))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// FileSHA256 hashes a file, e.g. the source corpus for BundleManifest.CorpusHash.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// manifestChecksum hashes every manifest field except Checksum itself, so
+// editing the model, dims or template invalidates the bundle just like
+// editing a payload file. encoding/json sorts map keys, so it is stable.
+func manifestChecksum(m BundleManifest) (string, error) {
+	m.Checksum = ""
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex(b), nil
+}
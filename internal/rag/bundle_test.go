@@ -0,0 +1,113 @@
+package rag
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundleRoundTripAndTamper(t *testing.T) {
+	chunks := []Chunk{{ChunkID: "a-1", DocID: 1, Title: "A", Text: "alpha"}, {ChunkID: "b-2", DocID: 2, Title: "B", Text: "beta"}}
+	cache := &EmbedCache{}
+	mv := cache.ForModel("m")
+	mv.Template = "default@v1"
+	mv.Items = map[string]EmbedCacheItem{
+		"a-1":  {ID: "a-1", Hash: testItemHash(t, KindBody, chunks[0]), Dim: 2, Vector: []float32{1, 0}},
+		"b-2":  {ID: "b-2", Hash: testItemHash(t, KindBody, chunks[1]), Dim: 2, Vector: []float32{0, 1}},
+		"gone": {ID: "gone", Dim: 2, Vector: []float32{1, 1}},
+	}
+	cache.ForModel("other").Items["a-1"] = EmbedCacheItem{ID: "a-1", Dim: 3, Vector: []float32{1, 0, 0}}
+
+	if _, err := NewBundle(append(chunks, Chunk{ChunkID: "c-3"}), cache, "m", 0, "h", false); err == nil {
+		t.Fatalf("expected error for chunk without vector")
+	}
+	b, err := NewBundle(chunks, cache, "m", 0, "corpus", false)
+	if err != nil {
+		t.Fatalf("new bundle: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "index.bundle")
+	if err := WriteBundle(path, b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got, err := ReadBundle(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	m := got.Manifest
+	if m.Model != "m" || m.Dims != 2 || m.Chunks != 2 || m.Vectors != 2 || m.Template != "default@v1" || m.CorpusHash != "corpus" || m.Checksum == "" {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	if len(got.Cache.Models) != 1 || len(BuildIndex(got.Chunks, got.Cache, "m")) != 2 {
+		t.Fatalf("bundle should hold exactly the selected model's vectors")
+	}
+	if problems := VerifyBundle(got); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	// Rewrite the bundle with one chunk edited but the original manifest.
+	tampered := rewriteBundle(t, path, func(name string, data []byte) []byte {
+		if name == bundleChunksFile {
+			return bytes.Replace(data, []byte("alpha"), []byte("alpha!"), 1)
+		}
+		return data
+	})
+	if _, err := ReadBundle(tampered); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+
+	// Manifest fields are covered too: relabelling the dims is refused.
+	tampered = rewriteBundle(t, path, func(name string, data []byte) []byte {
+		if name == bundleManifestFile {
+			return bytes.Replace(data, []byte(`"dims": 2`), []byte(`"dims": 256`), 1)
+		}
+		return data
+	})
+	if _, err := ReadBundle(tampered); err == nil || !strings.Contains(err.Error(), "manifest checksum mismatch") {
+		t.Fatalf("expected manifest checksum error, got %v", err)
+	}
+}
+
+func rewriteBundle(t *testing.T, path string, edit func(name string, data []byte) []byte) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		data = edit(h.Name, data)
+		h.Size = int64(len(data))
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+	dst := path + ".tampered"
+	if err := os.WriteFile(dst, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dst
+}
@@ -0,0 +1,208 @@
+package rag
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problems reported by VerifyCache for a cache item.
+const (
+	ProblemOrphan = "orphan"    // chunk no longer exists
+	ProblemKey    = "key"       // map key does not match id/kind
+	ProblemDim    = "dim"       // dim missing, or differs from the vector or the model
+	ProblemNaN    = "nan"       // vector contains NaN or Inf
+	ProblemZero   = "zero-norm" // all-zero vector, cannot be normalized
+	ProblemStale  = "stale"     // hash does not match the current chunk input
+)
+
+// ForModel returns the vectors stored for model, creating the section if needed.
+func (c *EmbedCache) ForModel(model string) *ModelVectors {
+	if c.Models == nil {
+		c.Models = map[string]*ModelVectors{}
+	}
+	mv := c.Models[model]
+	if mv == nil {
+		mv = &ModelVectors{Items: map[string]EmbedCacheItem{}}
+		c.Models[model] = mv
+	}
+	if mv.Items == nil {
+		mv.Items = map[string]EmbedCacheItem{}
+	}
+	return mv
+}
+
+// ModelNames lists the models with stored vectors, sorted.
+func (c *EmbedCache) ModelNames() []string {
+	names := make([]string, 0, len(c.Models))
+	for m := range c.Models {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckVector validates an item's vector on its own. dim > 0 also requires
+// the vector to have that many dimensions. It returns "" or a Problem constant.
+func CheckVector(item EmbedCacheItem, dim int) string {
+	if item.Dim <= 0 || len(item.Vector) != item.Dim || (dim > 0 && item.Dim != dim) {
+		return ProblemDim
+	}
+	var sum float64
+	for _, x := range item.Vector {
+		f := float64(x)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return ProblemNaN
+		}
+		sum += f * f
+	}
+	if sum == 0 {
+		return ProblemZero
+	}
+	return ""
+}
+
+// CacheIssue is one item that failed verification.
+type CacheIssue struct {
+	Key     string
+	Problem string
+}
+
+// ModelReport summarizes VerifyCache for one model.
+type ModelReport struct {
+	Model    string
+	Template string
+	Items    int
+	// Dim is the most common vector dimension; items with another are flagged.
+	Dim int
+	// Covered counts chunks with a valid, up-to-date vector, per kind.
+	Covered map[string]int
+	Chunks  int
+	// HashChecked is false for body vectors built with a custom template
+	// that cannot be re-rendered here.
+	HashChecked bool
+	Issues      []CacheIssue
+}
+
+// Coverage is the share of chunks with a valid vector of kind.
+func (r ModelReport) Coverage(kind string) float64 {
+	if r.Chunks == 0 {
+		return 0
+	}
+	return float64(r.Covered[kind]) / float64(r.Chunks)
+}
+
+// Counts tallies issues by problem.
+func (r ModelReport) Counts() map[string]int {
+	counts := map[string]int{}
+	for _, is := range r.Issues {
+		counts[is.Problem]++
+	}
+	return counts
+}
+
+// VerifyCache checks every stored item against chunks: orphans, key/kind
+// consistency, dimensions, NaN/Inf, zero vectors and (where the template is
+// known) stale hashes. Reports are sorted by model; issues by key.
+func VerifyCache(c *EmbedCache, chunks []Chunk) []ModelReport {
+	byID := make(map[string]Chunk, len(chunks))
+	for _, ch := range chunks {
+		byID[ch.ChunkID] = ch
+	}
+	var reports []ModelReport
+	for _, model := range c.ModelNames() {
+		mv := c.Models[model]
+		r := ModelReport{Model: model, Template: mv.Template, Items: len(mv.Items), Chunks: len(chunks), Covered: map[string]int{}}
+		r.Dim = commonDim(mv.Items)
+		tmpl, tmplErr := templateByID(mv.Template)
+		r.HashChecked = tmplErr == nil
+
+		keys := make([]string, 0, len(mv.Items))
+		for k := range mv.Items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			item := mv.Items[key]
+			kind := item.Kind
+			if kind == "" {
+				kind = KindBody
+			}
+			problem := ""
+			ch, exists := byID[item.ID]
+			switch {
+			case ItemKey(kind, item.ID) != key:
+				problem = ProblemKey
+			case !exists:
+				problem = ProblemOrphan
+			default:
+				problem = CheckVector(item, r.Dim)
+			}
+			if problem == "" && (kind != KindBody || r.HashChecked) {
+				if input, err := KindInput(kind, ch, tmpl); err == nil && itemHash(kind, input, tmpl) != item.Hash {
+					problem = ProblemStale
+				}
+			}
+			if problem != "" {
+				r.Issues = append(r.Issues, CacheIssue{Key: key, Problem: problem})
+			} else {
+				r.Covered[kind]++
+			}
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// PruneCache deletes the reported items whose problem is listed and returns
+// how many were removed. Pruned vectors are re-embedded on the next run.
+func PruneCache(c *EmbedCache, reports []ModelReport, problems ...string) int {
+	drop := map[string]bool{}
+	for _, p := range problems {
+		drop[p] = true
+	}
+	removed := 0
+	for _, r := range reports {
+		mv := c.Models[r.Model]
+		if mv == nil {
+			continue
+		}
+		for _, is := range r.Issues {
+			if _, ok := mv.Items[is.Key]; ok && drop[is.Problem] {
+				delete(mv.Items, is.Key)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+func commonDim(items map[string]EmbedCacheItem) int {
+	counts := map[int]int{}
+	best, bestN := 0, 0
+	for _, it := range items {
+		counts[it.Dim]++
+	}
+	for d, n := range counts {
+		if d > 0 && (n > bestN || (n == bestN && d < best)) {
+			best, bestN = d, n
+		}
+	}
+	return best
+}
+
+// templateByID resolves a built-in template ID such as "default@v1"; an empty
+// ID (caches written before templates) resolves to the default.
+func templateByID(id string) (EmbedTemplate, error) {
+	name, ver, _ := strings.Cut(id, "@v")
+	t, err := LookupEmbedTemplate(name)
+	if err != nil {
+		return EmbedTemplate{}, err
+	}
+	if id != "" && strconv.Itoa(t.Version) != ver {
+		return EmbedTemplate{}, fmt.Errorf("template %s is not the built-in %s", id, t.ID())
+	}
+	return t, nil
+}
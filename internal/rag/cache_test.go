@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCacheMigratesSingleModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	legacy := `{"version":2,"model":"small","template":"default@v1","kinds":{"body":1},
+		"items":{"a-1":{"id":"a-1","hash":"h","dim":2,"vector":[1,0]}}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadCache(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	mv := c.Models["small"]
+	if mv == nil || mv.Template != "default@v1" || len(mv.Items) != 1 {
+		t.Fatalf("legacy cache not migrated: %+v", c.Models)
+	}
+
+	c.ForModel("large").Items["a-1"] = EmbedCacheItem{ID: "a-1", Dim: 3, Vector: []float32{0, 0, 1}}
+	if err := SaveCache(path, c); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	c, err = LoadCache(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if c.Version != CacheVersion || len(c.ModelNames()) != 2 || len(c.Models["small"].Items) != 1 {
+		t.Fatalf("models not stored side by side: %+v", c.Models)
+	}
+}
+
+func TestVerifyAndPruneCache(t *testing.T) {
+	chunks := []Chunk{
+		{ChunkID: "a", Title: "A", Text: "alpha"},
+		{ChunkID: "b", Title: "B", Text: "beta"},
+		{ChunkID: "c", Title: "C", Text: "gamma"},
+	}
+	nan := float32(math.NaN())
+	c := &EmbedCache{}
+	mv := c.ForModel("m")
+	mv.Template = "default@v1"
+	mv.Items = map[string]EmbedCacheItem{
+		"a":       {ID: "a", Hash: testItemHash(t, KindBody, chunks[0]), Dim: 2, Vector: []float32{1, 0}},
+		"a#title": {ID: "a", Kind: KindTitle, Hash: "old", Dim: 2, Vector: []float32{0, 1}},
+		"b":       {ID: "b", Hash: testItemHash(t, KindBody, chunks[1]), Dim: 2, Vector: []float32{nan, 1}},
+		"c":       {ID: "c", Hash: testItemHash(t, KindBody, chunks[2]), Dim: 3, Vector: []float32{1, 0, 0}},
+		"gone":    {ID: "gone", Hash: "x", Dim: 2, Vector: []float32{1, 1}},
+	}
+
+	reports := VerifyCache(c, chunks)
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	r := reports[0]
+	counts := r.Counts()
+	if r.Dim != 2 || counts[ProblemNaN] != 1 || counts[ProblemDim] != 1 || counts[ProblemOrphan] != 1 || counts[ProblemStale] != 1 {
+		t.Fatalf("unexpected issues: dim=%d %v", r.Dim, r.Issues)
+	}
+	if r.Covered[KindBody] != 1 || r.Coverage(KindBody) != 1.0/3 {
+		t.Fatalf("unexpected body coverage: %v", r.Covered)
+	}
+	if r.Covered[KindTitle] != 0 {
+		t.Fatalf("a stale vector must not count as coverage: %v", r.Covered)
+	}
+
+	if n := PruneCache(c, reports, ProblemOrphan); n != 1 {
+		t.Fatalf("gc should remove 1 orphan, removed %d", n)
+	}
+	if n := PruneCache(c, reports, ProblemDim, ProblemNaN); n != 2 {
+		t.Fatalf("fix should remove 2 invalid items, removed %d", n)
+	}
+	if _, ok := mv.Items["a#title"]; !ok {
+		t.Fatalf("stale items are re-embedded, not pruned")
+	}
+	if entries := BuildIndex(chunks, c, "m"); len(entries) != 1 {
+		t.Fatalf("expected only chunk a to be indexed, got %d", len(entries))
+	}
+}
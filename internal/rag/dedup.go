@@ -0,0 +1,223 @@
+package rag
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DedupOptions controls near-duplicate detection. Two chunks are duplicates
+// only when both their body vectors and their word shingles agree.
+type DedupOptions struct {
+	// MinSim is the minimum cosine similarity of the body vectors.
+	MinSim float32
+	// MinJaccard is the minimum Jaccard overlap of word shingles.
+	MinJaccard float64
+	// ShingleSize is the number of words per shingle (default 5).
+	ShingleSize int
+	// Filter, when set, limits grouping to the entries it matches, so a
+	// duplicate is never collapsed into a canonical chunk the search filter
+	// would exclude. Other entries are left as they are.
+	Filter Filter
+}
+
+// DefaultDedupOptions catches reused paragraphs without merging chunks that
+// merely cover the same topic.
+var DefaultDedupOptions = DedupOptions{MinSim: 0.95, MinJaccard: 0.8, ShingleSize: 5}
+
+// maxShingleDocs skips shingles shared by many chunks (boilerplate) when
+// generating candidate pairs; they would make candidate search quadratic.
+const maxShingleDocs = 50
+
+// DuplicateGroup is a canonical chunk and the chunks collapsed into it.
+type DuplicateGroup struct {
+	Canonical  Chunk
+	Duplicates []Chunk
+}
+
+// FindDuplicates groups near-duplicate entries. Candidate pairs come from
+// shared shingles, then both thresholds are checked; groups are transitive.
+// Entries without duplicates are not returned. Groups are sorted by the
+// canonical chunk ID.
+func FindDuplicates(entries []Entry, opts DedupOptions) []DuplicateGroup {
+	if opts.ShingleSize <= 0 {
+		opts.ShingleSize = DefaultDedupOptions.ShingleSize
+	}
+	if !opts.Filter.IsZero() {
+		matched := make([]Entry, 0, len(entries))
+		for _, e := range entries {
+			if opts.Filter.Match(e.Chunk) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+	}
+	shingles := make([]map[uint64]struct{}, len(entries))
+	postings := map[uint64][]int{}
+	for i, e := range entries {
+		shingles[i] = shingleSet(e.Chunk.Text, opts.ShingleSize)
+		for h := range shingles[i] {
+			postings[h] = append(postings[h], i)
+		}
+	}
+
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	checked := map[[2]int]bool{}
+	for _, docs := range postings {
+		if len(docs) < 2 || len(docs) > maxShingleDocs {
+			continue
+		}
+		for a := 0; a < len(docs); a++ {
+			for b := a + 1; b < len(docs); b++ {
+				i, j := docs[a], docs[b]
+				pair := [2]int{i, j}
+				if checked[pair] {
+					continue
+				}
+				checked[pair] = true
+				if Dot(entries[i].Vec, entries[j].Vec) < opts.MinSim {
+					continue
+				}
+				if jaccard(shingles[i], shingles[j]) < opts.MinJaccard {
+					continue
+				}
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	clusters := map[int][]int{}
+	for i := range entries {
+		clusters[find(i)] = append(clusters[find(i)], i)
+	}
+	var groups []DuplicateGroup
+	for _, members := range clusters {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(a, b int) bool {
+			return preferCanonical(entries[members[a]].Chunk, entries[members[b]].Chunk)
+		})
+		g := DuplicateGroup{Canonical: entries[members[0]].Chunk}
+		for _, m := range members[1:] {
+			g.Duplicates = append(g.Duplicates, entries[m].Chunk)
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Canonical.ChunkID < groups[j].Canonical.ChunkID
+	})
+	return groups
+}
+
+// CollapseDuplicates drops the non-canonical members of each duplicate group
+// and records their URLs in the canonical chunk's Alternates. Order of the
+// remaining entries is preserved.
+func CollapseDuplicates(entries []Entry, opts DedupOptions) ([]Entry, []DuplicateGroup) {
+	groups := FindDuplicates(entries, opts)
+	if len(groups) == 0 {
+		return entries, nil
+	}
+	drop := map[string]bool{}
+	alternates := map[string][]string{}
+	for _, g := range groups {
+		seen := map[string]bool{g.Canonical.URL: true}
+		for _, u := range g.Canonical.Alternates {
+			seen[u] = true
+		}
+		for _, d := range g.Duplicates {
+			drop[d.ChunkID] = true
+			for _, u := range append([]string{d.URL}, d.Alternates...) {
+				if u != "" && !seen[u] {
+					seen[u] = true
+					alternates[g.Canonical.ChunkID] = append(alternates[g.Canonical.ChunkID], u)
+				}
+			}
+		}
+	}
+	out := make([]Entry, 0, len(entries)-len(drop))
+	for _, e := range entries {
+		if drop[e.Chunk.ChunkID] {
+			continue
+		}
+		if alts := alternates[e.Chunk.ChunkID]; len(alts) > 0 {
+			sort.Strings(alts)
+			e.Chunk.Alternates = appendCopy(e.Chunk.Alternates, alts)
+		}
+		out = append(out, e)
+	}
+	return out, groups
+}
+
+// preferCanonical orders duplicate candidates: articles over index pages,
+// posts over pages, then the more complete text, the shorter URL and the
+// chunk ID for determinism.
+func preferCanonical(a, b Chunk) bool {
+	if a.IndexPage != b.IndexPage {
+		return !a.IndexPage
+	}
+	if (a.DocType == "post") != (b.DocType == "post") {
+		return a.DocType == "post"
+	}
+	if a.CharLen != b.CharLen {
+		return a.CharLen > b.CharLen
+	}
+	if len(a.URL) != len(b.URL) {
+		return len(a.URL) < len(b.URL)
+	}
+	return a.ChunkID < b.ChunkID
+}
+
+func shingleSet(text string, size int) map[uint64]struct{} {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	set := map[uint64]struct{}{}
+	if len(words) < size {
+		if len(words) > 0 {
+			set[hashWords(words)] = struct{}{}
+		}
+		return set
+	}
+	for i := 0; i+size <= len(words); i++ {
+		set[hashWords(words[i:i+size])] = struct{}{}
+	}
+	return set
+}
+
+func hashWords(words []string) uint64 {
+	h := fnv.New64a()
+	for _, w := range words {
+		h.Write([]byte(w))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+func jaccard(a, b map[uint64]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	inter := 0
+	for h := range a {
+		if _, ok := b[h]; ok {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
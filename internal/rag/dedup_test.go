@@ -0,0 +1,50 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollapseDuplicates(t *testing.T) {
+	para := "The C6 bus runs from Alicante airport to the city centre every 20 minutes and stops at Plaza Puerta del Mar, the Luceros fountain and the bus station. Tickets cost 3.85 euros on board."
+	entries := []Entry{
+		{Chunk: Chunk{ChunkID: "hub-1", DocType: "page", URL: "https://x/alicante-airport-travel-hub/", Text: para, CharLen: len(para)}, Vec: []float32{1, 0}},
+		{Chunk: Chunk{ChunkID: "bus-1", DocType: "post", URL: "https://x/alicante-airport-bus/", Text: para, CharLen: len(para)}, Vec: []float32{1, 0}},
+		{Chunk: Chunk{ChunkID: "centre-1", DocType: "post", URL: "https://x/from-alicante-airport-to-alicante-city-centre-by-bus/", Text: para + " Cash only.", CharLen: len(para) + 11}, Vec: []float32{0.99, 0.14}},
+		// Same topic, different wording: must survive.
+		{Chunk: Chunk{ChunkID: "taxi-1", DocType: "post", URL: "https://x/airport-taxi/", Text: "A taxi from the airport to the centre takes 20 minutes and costs about 25 euros."}, Vec: []float32{0.98, 0.2}},
+		// Same text but an unrelated vector (e.g. stale): must survive.
+		{Chunk: Chunk{ChunkID: "odd-1", DocType: "post", URL: "https://x/odd/", Text: para}, Vec: []float32{0, 1}},
+	}
+
+	out, groups := CollapseDuplicates(entries, DefaultDedupOptions)
+	if len(groups) != 1 || len(out) != 3 {
+		t.Fatalf("expected 1 group and 3 entries, got %d groups, %d entries", len(groups), len(out))
+	}
+	g := groups[0]
+	if g.Canonical.ChunkID != "centre-1" || len(g.Duplicates) != 2 {
+		t.Fatalf("longest post should be canonical, got %s with %d duplicates", g.Canonical.ChunkID, len(g.Duplicates))
+	}
+	var canon Chunk
+	for _, e := range out {
+		if e.Chunk.ChunkID == "centre-1" {
+			canon = e.Chunk
+		}
+	}
+	want := []string{"https://x/alicante-airport-bus/", "https://x/alicante-airport-travel-hub/"}
+	if strings.Join(canon.Alternates, " ") != strings.Join(want, " ") {
+		t.Fatalf("unexpected alternates: %v", canon.Alternates)
+	}
+	if entries[2].Chunk.Alternates != nil {
+		t.Fatalf("input entries must not be mutated")
+	}
+
+	// With posts filtered out of search, the page is the only candidate
+	// left: it must not be collapsed into a post.
+	opts := DefaultDedupOptions
+	opts.Filter = Filter{DocTypes: []string{"page"}}
+	out, groups = CollapseDuplicates(entries, opts)
+	if len(groups) != 0 || len(out) != len(entries) {
+		t.Fatalf("expected no groups among filtered entries, got %d groups, %d entries", len(groups), len(out))
+	}
+}
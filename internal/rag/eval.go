@@ -0,0 +1,95 @@
+package rag
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// EvalCase is one labelled retrieval question. Expected holds URLs, slugs or
+// chunk IDs; a hit matching any of them counts as relevant. Answerable
+// overrides the default (answerable iff Expected is non-empty) for gate
+// calibration.
+type EvalCase struct {
+	Question   string   `json:"question"`
+	Expected   []string `json:"expected"`
+	Answerable *bool    `json:"answerable,omitempty"`
+}
+
+// IsAnswerable reports whether the site content should answer the question.
+func (c EvalCase) IsAnswerable() bool {
+	if c.Answerable != nil {
+		return *c.Answerable
+	}
+	return len(c.Expected) > 0
+}
+
+// EvalResult summarizes retrieval quality over a set of cases.
+type EvalResult struct {
+	Cases     int
+	RecallAtK float64 // share of cases with a relevant hit in the top K
+	MRR       float64 // mean reciprocal rank of the first relevant hit
+	SearchAvg time.Duration
+}
+
+// ReadEvalCases loads cases from a JSONL file ({"question", "expected"} per line).
+func ReadEvalCases(path string) ([]EvalCase, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cases []EvalCase
+	for i, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		var c EvalCase
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("eval line %d: %w", i+1, err)
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// EvalRetrieval runs every case against entries and reports recall@k and MRR.
+// queries[i] is the embedding of cases[i]; it is reduced to the entries'
+// dimension, so one set of full query vectors can evaluate several dims.
+func EvalRetrieval(entries []Entry, cases []EvalCase, queries [][]float32, k int, weights VectorWeights) EvalResult {
+	res := EvalResult{Cases: len(cases)}
+	if len(cases) == 0 || len(entries) == 0 {
+		return res
+	}
+	dims := len(entries[0].Vec)
+	var hits, rr float64
+	var took time.Duration
+	for i, c := range cases {
+		q := TruncateVector(queries[i], dims)
+		start := time.Now()
+		results := TopKSearchWeighted(entries, q, k, Filter{}, weights)
+		took += time.Since(start)
+		for rank, r := range results {
+			if c.relevant(r.Chunk) {
+				hits++
+				rr += 1 / float64(rank+1)
+				break
+			}
+		}
+	}
+	n := float64(len(cases))
+	res.RecallAtK = hits / n
+	res.MRR = rr / n
+	res.SearchAvg = took / time.Duration(len(cases))
+	return res
+}
+
+func (c EvalCase) relevant(ch Chunk) bool {
+	for _, e := range c.Expected {
+		if e == ch.URL || e == ch.Slug || e == ch.ChunkID {
+			return true
+		}
+	}
+	return false
+}
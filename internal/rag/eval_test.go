@@ -0,0 +1,50 @@
+package rag
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuildIndexDimsTruncatesAndPrefersShortSection(t *testing.T) {
+	chunks := []Chunk{{ChunkID: "a", Slug: "a"}, {ChunkID: "b", Slug: "b"}}
+	c := &EmbedCache{}
+	c.ForModel("m").Items = map[string]EmbedCacheItem{
+		"a": {ID: "a", Dim: 4, Vector: []float32{3, 4, 100, 100}},
+		"b": {ID: "b", Dim: 4, Vector: []float32{0, 1, 0, 0}},
+	}
+	c.ForModel(ModelKey("m", 2)).Items = map[string]EmbedCacheItem{
+		"b": {ID: "b", Dim: 2, Vector: []float32{1, 0}},
+	}
+
+	entries := BuildIndexDims(chunks, c, "m", 2)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	a, b := entries[0].Vec, entries[1].Vec
+	if len(a) != 2 || math.Abs(float64(a[0])-0.6) > 1e-6 || math.Abs(float64(a[1])-0.8) > 1e-6 {
+		t.Fatalf("full vector should be truncated and renormalized, got %v", a)
+	}
+	if b[0] != 1 || b[1] != 0 {
+		t.Fatalf("api-shortened vector should win over truncation, got %v", b)
+	}
+	if full := BuildIndex(chunks, c, "m"); len(full[0].Vec) != 4 {
+		t.Fatalf("dims=0 should keep full vectors")
+	}
+}
+
+func TestEvalRetrieval(t *testing.T) {
+	entries := []Entry{
+		{Chunk: Chunk{ChunkID: "bus-1", URL: "https://x/bus"}, Vec: []float32{1, 0}},
+		{Chunk: Chunk{ChunkID: "tram-1", Slug: "tram"}, Vec: []float32{0, 1}},
+	}
+	cases := []EvalCase{
+		{Question: "bus", Expected: []string{"https://x/bus"}},
+		{Question: "tram", Expected: []string{"tram"}},
+		{Question: "ferry", Expected: []string{"ferry"}},
+	}
+	queries := [][]float32{{1, 0.1, 5}, {1, 0.9, 5}, {0, 1, 5}}
+	res := EvalRetrieval(entries, cases, queries, 2, nil)
+	if res.Cases != 3 || math.Abs(res.RecallAtK-2.0/3) > 1e-9 || math.Abs(res.MRR-0.5) > 1e-9 {
+		t.Fatalf("unexpected eval result: %+v", res)
+	}
+}
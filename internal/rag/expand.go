@@ -0,0 +1,174 @@
+package rag
+
+import (
+	"sort"
+	"strings"
+)
+
+// Expansion modes for ExpandOptions.Mode.
+const (
+	ExpandNone      = "none"
+	ExpandNeighbors = "neighbors"
+	ExpandSection   = "section"
+	ExpandDoc       = "doc"
+)
+
+// ExpandOptions controls how hits are widened before prompt construction.
+type ExpandOptions struct {
+	// Mode is one of ExpandNone, ExpandNeighbors, ExpandSection or ExpandDoc.
+	Mode string
+	// Window is the number of adjacent chunks on each side (neighbors mode).
+	Window int
+	// MaxChars caps the expanded text per hit; the hit itself is always kept.
+	MaxChars int
+}
+
+// DocIndex groups chunks by document in reading order so hits can be
+// expanded to adjacent chunks, their section or the whole parent doc.
+type DocIndex struct {
+	docs map[int][]Chunk
+	pos  map[string]int
+}
+
+// NewDocIndex orders each document's chunks by ChunkIndex, falling back to
+// input order when the index is absent.
+func NewDocIndex(chunks []Chunk) *DocIndex {
+	d := &DocIndex{docs: map[int][]Chunk{}, pos: map[string]int{}}
+	for _, ch := range chunks {
+		d.docs[ch.DocID] = append(d.docs[ch.DocID], ch)
+	}
+	for id, list := range d.docs {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].ChunkIndex < list[j].ChunkIndex
+		})
+		for i, ch := range list {
+			d.pos[ch.ChunkID] = i
+		}
+		d.docs[id] = list
+	}
+	return d
+}
+
+// Chunked reports whether any document has more than one chunk. Without
+// that, every expansion mode returns hits unchanged.
+func (d *DocIndex) Chunked() bool {
+	if d == nil {
+		return false
+	}
+	for _, list := range d.docs {
+		if len(list) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand widens each hit according to opts and merges hits from the same
+// document into one entry at the rank of its best hit. Scores and ranking
+// are unchanged; only Chunk.Text (and CharLen) grow. A nil index or
+// ExpandNone returns hits as-is.
+func (d *DocIndex) Expand(hits []ScoredChunk, opts ExpandOptions) []ScoredChunk {
+	if d == nil || opts.Mode == "" || opts.Mode == ExpandNone || len(hits) == 0 {
+		return hits
+	}
+	type docSpan struct {
+		out  int
+		keep map[int]bool
+	}
+	spans := map[int]*docSpan{}
+	out := make([]ScoredChunk, 0, len(hits))
+	for _, h := range hits {
+		list := d.docs[h.Chunk.DocID]
+		p, ok := d.pos[h.Chunk.ChunkID]
+		if !ok || len(list) <= 1 {
+			out = append(out, h)
+			continue
+		}
+		span, seen := spans[h.Chunk.DocID]
+		if !seen {
+			span = &docSpan{out: len(out), keep: map[int]bool{}}
+			spans[h.Chunk.DocID] = span
+			out = append(out, h)
+		}
+		for _, i := range d.window(list, p, opts) {
+			span.keep[i] = true
+		}
+	}
+	for docID, span := range spans {
+		list := d.docs[docID]
+		text := joinKept(list, span.keep)
+		out[span.out].Chunk.Text = text
+		out[span.out].Chunk.CharLen = len(text)
+	}
+	return out
+}
+
+// window grows outward from p, nearest chunks first, while the combined
+// length stays within MaxChars and the candidate is allowed by the mode.
+func (d *DocIndex) window(list []Chunk, p int, opts ExpandOptions) []int {
+	allowed := func(i int) bool {
+		switch opts.Mode {
+		case ExpandNeighbors:
+			w := opts.Window
+			if w <= 0 {
+				w = 1
+			}
+			return i >= p-w && i <= p+w
+		case ExpandSection:
+			if list[p].Section == "" {
+				return i >= p-1 && i <= p+1
+			}
+			return list[i].Section == list[p].Section
+		case ExpandDoc:
+			return true
+		}
+		return false
+	}
+	kept := []int{p}
+	total := len(list[p].Text)
+	lo, hi := p-1, p+1
+	for lo >= 0 || hi < len(list) {
+		grew := false
+		for _, i := range []int{hi, lo} {
+			if i < 0 || i >= len(list) || !allowed(i) {
+				continue
+			}
+			if opts.MaxChars > 0 && total+len(list[i].Text) > opts.MaxChars {
+				continue
+			}
+			kept = append(kept, i)
+			total += len(list[i].Text)
+			grew = true
+			if i == hi {
+				hi++
+			} else {
+				lo--
+			}
+		}
+		if !grew {
+			break
+		}
+	}
+	return kept
+}
+
+// joinKept concatenates kept chunks in reading order, marking gaps with an ellipsis.
+func joinKept(list []Chunk, keep map[int]bool) string {
+	var sb strings.Builder
+	prev := -2
+	for i, ch := range list {
+		if !keep[i] {
+			continue
+		}
+		if sb.Len() > 0 {
+			if i == prev+1 {
+				sb.WriteString("\n\n")
+			} else {
+				sb.WriteString("\n\n…\n\n")
+			}
+		}
+		sb.WriteString(ch.Text)
+		prev = i
+	}
+	return sb.String()
+}
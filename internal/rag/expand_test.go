@@ -0,0 +1,55 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocIndexExpand(t *testing.T) {
+	chunks := []Chunk{
+		{ChunkID: "bus-0", DocID: 1, ChunkIndex: 0, Section: "Intro", Text: "The C6 airport bus."},
+		{ChunkID: "bus-1", DocID: 1, ChunkIndex: 1, Section: "Prices", Text: "Tickets cost 3.85 EUR."},
+		{ChunkID: "bus-2", DocID: 1, ChunkIndex: 2, Section: "Prices", Text: "Pay on board by card."},
+		{ChunkID: "bus-3", DocID: 1, ChunkIndex: 3, Section: "Stops", Text: "Stops at Luceros."},
+		{ChunkID: "tram-0", DocID: 2, Text: "Tram line 1."},
+	}
+	d := NewDocIndex(chunks)
+	if !d.Chunked() || NewDocIndex(chunks[3:]).Chunked() {
+		t.Fatalf("Chunked should report multi-chunk docs only")
+	}
+	hits := []ScoredChunk{
+		{Chunk: chunks[2], Score: 0.9},
+		{Chunk: chunks[4], Score: 0.8},
+		{Chunk: chunks[0], Score: 0.7},
+	}
+
+	got := d.Expand(hits, ExpandOptions{Mode: ExpandNeighbors, Window: 1})
+	if len(got) != 2 {
+		t.Fatalf("expected same-doc hits to merge, got %d", len(got))
+	}
+	if got[0].Chunk.ChunkID != "bus-2" || got[0].Score != 0.9 {
+		t.Fatalf("expected best hit to keep its rank and score")
+	}
+	want := "The C6 airport bus.\n\nTickets cost 3.85 EUR.\n\nPay on board by card.\n\nStops at Luceros."
+	if got[0].Chunk.Text != want {
+		t.Fatalf("unexpected expanded text: %q", got[0].Chunk.Text)
+	}
+	if hits[0].Chunk.Text != "Pay on board by card." {
+		t.Fatalf("input hits should not be mutated")
+	}
+
+	got = d.Expand(hits[:1], ExpandOptions{Mode: ExpandSection})
+	if got[0].Chunk.Text != "Tickets cost 3.85 EUR.\n\nPay on board by card." {
+		t.Fatalf("unexpected section text: %q", got[0].Chunk.Text)
+	}
+
+	got = d.Expand(hits[:1], ExpandOptions{Mode: ExpandDoc, MaxChars: 50})
+	if !strings.Contains(got[0].Chunk.Text, "Pay on board") || len(got[0].Chunk.Text) > 60 {
+		t.Fatalf("expected budgeted doc expansion, got %q", got[0].Chunk.Text)
+	}
+
+	var nilIndex *DocIndex
+	if out := nilIndex.Expand(hits, ExpandOptions{Mode: ExpandDoc}); len(out) != 3 {
+		t.Fatalf("nil index should return hits unchanged")
+	}
+}
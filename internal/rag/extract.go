@@ -0,0 +1,260 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Lookup kinds answered by a single fact-bearing sentence.
+const (
+	LookupPrice    = "price"
+	LookupHours    = "hours"
+	LookupDistance = "distance"
+)
+
+type lookup struct {
+	kind     string
+	question *regexp.Regexp
+	fact     *regexp.Regexp
+	// cues are query words that name the fact rather than the subject; a
+	// sentence states the fact ("3.85 €") instead of repeating them.
+	cues string
+}
+
+var lookups = []lookup{
+	{
+		kind:     LookupPrice,
+		question: regexp.MustCompile(`\b(price|prices|cost|costs|fare|fares|fee|fees|how much|admission|entry)\b`),
+		fact:     regexp.MustCompile(`(\d+([.,]\d+)?\s*(€|eur\b|euros?\b)|€\s*\d|\bfree\b)`),
+		cues:     "price prices cost costs fare fares fee fees admission entry ticket tickets",
+	},
+	{
+		kind:     LookupHours,
+		question: regexp.MustCompile(`\b(open|opening|hours|close|closes|closing|what time|timetable)\b`),
+		fact:     regexp.MustCompile(`(\b\d{1,2}[:.]\d{2}\b|\b\d{1,2}\s*(am|pm)\b|\b(daily|mondays?|tuesdays?|wednesdays?|thursdays?|fridays?|saturdays?|sundays?|weekdays|weekends)\b)`),
+		cues:     "open opening hours close closes closing time timetable",
+	},
+	{
+		kind:     LookupDistance,
+		question: regexp.MustCompile(`\b(how far|distance|how long|far from)\b`),
+		fact:     regexp.MustCompile(`\b\d+([.,]\d+)?\s*(km|kilometres?|kilometers?|miles?|metres?|meters?|m|min|mins|minutes?|hours?|h)\b`),
+		cues:     "far distance long take takes",
+	},
+}
+
+// LookupKind returns the lookup kind of query, or "" if it is not a simple
+// fact lookup.
+func LookupKind(query string) string {
+	if l := findLookup(query); l != nil {
+		return l.kind
+	}
+	return ""
+}
+
+func findLookup(query string) *lookup {
+	q := strings.ToLower(query)
+	for i := range lookups {
+		if lookups[i].question.MatchString(q) {
+			return &lookups[i]
+		}
+	}
+	return nil
+}
+
+// Span is a sentence copied verbatim from a chunk.
+type Span struct {
+	Text       string
+	Chunk      Chunk
+	Confidence float64
+}
+
+// Extraction is the result of Extract. Confidence is that of the best span;
+// it is 0 when the query is not a lookup or no sentence states the fact.
+type Extraction struct {
+	Kind       string
+	Spans      []Span
+	Confidence float64
+}
+
+const (
+	minSentenceChars = 15
+	maxSentenceChars = 300
+	// spanKeepRatio admits extra spans scoring within this share of the best.
+	spanKeepRatio = 0.85
+)
+
+// Extract scores the sentences of hits against a lookup query and returns
+// up to maxSpans sentences that state the asked-for fact. A sentence's
+// confidence is the share of the query's subject terms it contains (the
+// article title counts for a third), scaled by its hit's score relative to
+// the top hit.
+func Extract(query string, hits []ScoredChunk, maxSpans int) Extraction {
+	l := findLookup(query)
+	if l == nil || len(hits) == 0 || maxSpans <= 0 {
+		return Extraction{}
+	}
+	out := Extraction{Kind: l.kind}
+	subject := subjectTerms(query, l.cues)
+	if len(subject) == 0 {
+		return out
+	}
+	top := hits[0].Score
+	var spans []Span
+	seen := map[string]bool{}
+	for _, h := range hits {
+		rank := 1.0
+		if top > 0 {
+			rank = 0.5 + 0.5*float64(h.Score/top)
+		}
+		for _, sent := range Sentences(h.Chunk.Text) {
+			if seen[sent] || len(sent) < minSentenceChars || len(sent) > maxSentenceChars || !l.fact.MatchString(strings.ToLower(sent)) {
+				continue
+			}
+			seen[sent] = true
+			conf := (2*coverage(subject, sent) + coverage(subject, h.Chunk.Title+"\n"+sent)) / 3 * rank
+			if conf > 0 {
+				spans = append(spans, Span{Text: sent, Chunk: h.Chunk, Confidence: conf})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return out
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Confidence > spans[j].Confidence })
+	out.Confidence = spans[0].Confidence
+	for _, sp := range spans {
+		if len(out.Spans) >= maxSpans || sp.Confidence < out.Confidence*spanKeepRatio {
+			break
+		}
+		out.Spans = append(out.Spans, sp)
+	}
+	return out
+}
+
+// subjectTerms returns the distinct query terms minus the lookup cues.
+func subjectTerms(query, cues string) map[string]bool {
+	skip := map[string]bool{}
+	for _, c := range Terms(cues) {
+		skip[c] = true
+	}
+	out := map[string]bool{}
+	for _, t := range Terms(query) {
+		if !skip[t] {
+			out[t] = true
+		}
+	}
+	return out
+}
+
+// coverage is the share of terms found in text.
+func coverage(terms map[string]bool, text string) float64 {
+	found := map[string]bool{}
+	for _, t := range Terms(text) {
+		if terms[t] {
+			found[t] = true
+		}
+	}
+	return float64(len(found)) / float64(len(terms))
+}
+
+// Sentences splits text into trimmed sentences: per line, then after
+// '.', '!' or '?' followed by a space and an upper-case letter or digit.
+// Decimals ("3.85") and times ("10.30") stay whole.
+func Sentences(text string) []string {
+	var out []string
+	for _, para := range splitParagraphs(text) {
+		r := []rune(para)
+		start := 0
+		for i := 0; i < len(r)-2; i++ {
+			if (r[i] == '.' || r[i] == '!' || r[i] == '?') && r[i+1] == ' ' && (unicode.IsUpper(r[i+2]) || unicode.IsDigit(r[i+2])) {
+				if s := strings.TrimSpace(string(r[start : i+1])); s != "" {
+					out = append(out, s)
+				}
+				start = i + 2
+			}
+		}
+		if s := strings.TrimSpace(string(r[start:])); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+var (
+	numberPattern = regexp.MustCompile(`\d+([.,]\d+)?`)
+	// Times as written on the site and by the model: 10:00, 10.00, 10h,
+	// 10h30, 10 am, 10:30 p.m.
+	clockPattern = regexp.MustCompile(`(?i)\b(\d{1,2})(?:[:.h](\d{2}))?\s*([ap])\.?m\b\.?|\b(\d{1,2})(?:[:.](\d{2})\b|\s?h(\d{2})?\b)`)
+)
+
+// claimNumbers lists the numbers in s for comparison, each with its
+// equivalent spellings: times are normalized to 24-hour "H:MM", so "10.00",
+// "10h" and "10 am" all match "10:00", and other numbers use "." as the
+// decimal separator. "10.00" could be either, so it carries both forms.
+func claimNumbers(s string) [][]string {
+	var out [][]string
+	s = clockPattern.ReplaceAllStringFunc(s, func(m string) string {
+		g := clockPattern.FindStringSubmatch(m)
+		hour, min, ampm := g[1], g[2], strings.ToLower(g[3])
+		if hour == "" {
+			hour, min = g[4], g[5]+g[6]
+		}
+		h, _ := strconv.Atoi(hour)
+		mm := 0
+		if min != "" {
+			mm, _ = strconv.Atoi(min)
+		}
+		if mm > 59 || h > 24 || ampm != "" && (h < 1 || h > 12) {
+			return m // a decimal such as 3.85, not a time
+		}
+		if ampm == "p" && h < 12 {
+			h += 12
+		} else if ampm == "a" && h == 12 {
+			h = 0
+		}
+		forms := []string{fmt.Sprintf("%d:%02d", h, mm)}
+		if ampm == "" && strings.Contains(m, ".") {
+			forms = append(forms, hour+"."+min)
+		}
+		out = append(out, forms)
+		return " "
+	})
+	for _, n := range numberPattern.FindAllString(s, -1) {
+		out = append(out, []string{strings.ReplaceAll(n, ",", ".")})
+	}
+	return out
+}
+
+// LexicalSupport estimates how well text supports claim: the share of the
+// claim's terms found in text, or 0 if the claim states a number that text
+// does not contain (a changed price or time is the costliest mistake).
+// Claims without terms return 1.
+func LexicalSupport(claim, text string) float64 {
+	nums := map[string]bool{}
+	for _, forms := range claimNumbers(text) {
+		for _, f := range forms {
+			nums[f] = true
+		}
+	}
+	for _, forms := range claimNumbers(claim) {
+		found := false
+		for _, f := range forms {
+			found = found || nums[f]
+		}
+		if !found {
+			return 0
+		}
+	}
+	terms := map[string]bool{}
+	for _, t := range Terms(claim) {
+		terms[t] = true
+	}
+	if len(terms) == 0 {
+		return 1
+	}
+	return coverage(terms, text)
+}
@@ -0,0 +1,81 @@
+package rag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSentences(t *testing.T) {
+	got := Sentences("Prices\nA single ticket costs 1.45 €. Children under 8 travel free! Open at 10.30 daily.")
+	want := []string{"Prices", "A single ticket costs 1.45 €.", "Children under 8 travel free!", "Open at 10.30 daily."}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestLookupKind(t *testing.T) {
+	cases := map[string]string{
+		"How much is the C6 airport bus?":             LookupPrice,
+		"What are the opening hours of the castle?":   LookupHours,
+		"How far is Tabarca from the port?":           LookupDistance,
+		"What is the best beach for kids in Alicante": "",
+	}
+	for q, want := range cases {
+		if got := LookupKind(q); got != want {
+			t.Errorf("%q: got %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	bus := Chunk{Title: "Alicante airport bus", URL: "https://bus", Text: "The C6 bus leaves from arrivals every 20 minutes. A single ticket on the C6 bus costs 3.85 €. Taxis to the centre cost about 25 €."}
+	beach := Chunk{Title: "Beaches", URL: "https://beach", Text: "Sunbeds at Postiguet cost 6 € a day."}
+	hits := []ScoredChunk{{Chunk: bus, Score: 0.8}, {Chunk: beach, Score: 0.4}}
+
+	ex := Extract("How much is the C6 bus from the airport?", hits, 2)
+	if ex.Kind != LookupPrice || len(ex.Spans) != 1 {
+		t.Fatalf("unexpected extraction %+v", ex)
+	}
+	if ex.Spans[0].Text != "A single ticket on the C6 bus costs 3.85 €." || ex.Spans[0].Chunk.URL != "https://bus" {
+		t.Fatalf("unexpected span %+v", ex.Spans[0])
+	}
+	if ex.Confidence < 0.75 {
+		t.Fatalf("expected high confidence, got %.3f", ex.Confidence)
+	}
+
+	// The subject is only in the title: low confidence, leave it to the model.
+	ex = Extract("How much is a taxi from the airport bus stop to the marina?", hits, 2)
+	if ex.Confidence >= 0.75 {
+		t.Fatalf("expected low confidence, got %+v", ex)
+	}
+
+	if ex := Extract("Which beach is best for kids?", hits, 2); ex.Kind != "" || len(ex.Spans) != 0 {
+		t.Fatalf("non-lookup question extracted %+v", ex)
+	}
+}
+
+func TestLexicalSupport(t *testing.T) {
+	text := "A single ticket on the C6 bus costs 3,85 € and it runs every 20 minutes."
+	if got := LexicalSupport("The C6 bus costs 3.85 €.", text); got < 0.99 {
+		t.Fatalf("expected full support, got %.2f", got)
+	}
+	if got := LexicalSupport("The C6 bus costs 4.50 €.", text); got != 0 {
+		t.Fatalf("a changed price must not be supported, got %.2f", got)
+	}
+	if got := LexicalSupport("Tabarca island has a marine reserve.", text); got > 0.2 {
+		t.Fatalf("unrelated claim supported: %.2f", got)
+	}
+	if got := LexicalSupport("The C6 bus costs 3.85 €.", "The C6 bus costs 3.85 €."); got < 0.99 {
+		t.Fatalf("a price must not be read as a time, got %.2f", got)
+	}
+
+	hours := "The castle is open daily from 10.00 to 20.00."
+	for _, claim := range []string{"The castle opens at 10:00.", "The castle opens at 10 am.", "The castle opens at 10h.", "The castle closes at 8 p.m."} {
+		if got := LexicalSupport(claim, hours); got == 0 {
+			t.Fatalf("%q: time written differently was not supported", claim)
+		}
+	}
+	if got := LexicalSupport("The castle opens at 11:00.", hours); got != 0 {
+		t.Fatalf("a changed time must not be supported, got %.2f", got)
+	}
+}
@@ -0,0 +1,221 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// modifiedLayout is the WordPress modified_gmt format (UTC, no zone suffix).
+const modifiedLayout = "2006-01-02T15:04:05"
+
+// Filter restricts which chunks are eligible for retrieval.
+// The zero value matches every chunk. List fields are OR-ed within a field
+// and AND-ed across fields.
+type Filter struct {
+	DocTypes           []string
+	ExcludeDocTypes    []string
+	Categories         []string
+	ExcludeSlugs       []string
+	URLPrefixes        []string
+	ExcludeURLPrefixes []string
+	ModifiedAfter      time.Time
+	ModifiedBefore     time.Time
+}
+
+// Modified parses ModifiedGMT. ok is false when the field is empty or malformed.
+func (c Chunk) Modified() (time.Time, bool) {
+	if c.ModifiedGMT == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(modifiedLayout, c.ModifiedGMT)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// IsZero reports whether the filter matches everything.
+func (f Filter) IsZero() bool {
+	return len(f.DocTypes) == 0 && len(f.ExcludeDocTypes) == 0 && len(f.Categories) == 0 &&
+		len(f.ExcludeSlugs) == 0 && len(f.URLPrefixes) == 0 && len(f.ExcludeURLPrefixes) == 0 &&
+		f.ModifiedAfter.IsZero() && f.ModifiedBefore.IsZero()
+}
+
+// Match reports whether ch passes every constraint in the filter.
+// Chunks without a parseable ModifiedGMT fail any date constraint.
+func (f Filter) Match(ch Chunk) bool {
+	if len(f.DocTypes) > 0 && !containsFold(f.DocTypes, ch.DocType) {
+		return false
+	}
+	if containsFold(f.ExcludeDocTypes, ch.DocType) {
+		return false
+	}
+	if len(f.Categories) > 0 {
+		found := false
+		for _, c := range ch.Categories {
+			if containsFold(f.Categories, c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if containsFold(f.ExcludeSlugs, ch.Slug) {
+		return false
+	}
+	if len(f.URLPrefixes) > 0 && !hasAnyPrefix(ch.URL, f.URLPrefixes) {
+		return false
+	}
+	if hasAnyPrefix(ch.URL, f.ExcludeURLPrefixes) {
+		return false
+	}
+	if !f.ModifiedAfter.IsZero() || !f.ModifiedBefore.IsZero() {
+		mod, ok := ch.Modified()
+		if !ok {
+			return false
+		}
+		if !f.ModifiedAfter.IsZero() && mod.Before(f.ModifiedAfter) {
+			return false
+		}
+		if !f.ModifiedBefore.IsZero() && !mod.Before(f.ModifiedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseFilter parses a space-separated filter expression such as
+//
+//	type:post -slug:privacy-policy url:https://alicanteabout.com/ since:2024-01-01
+//
+// Keys: type, category, slug (exclude only), url (prefix), since, until.
+// A leading "-" negates type and url terms and is required for slug.
+// Values may be comma-separated. Dates use YYYY-MM-DD or RFC3339.
+func ParseFilter(expr string) (Filter, error) {
+	var f Filter
+	for _, tok := range strings.Fields(expr) {
+		neg := strings.HasPrefix(tok, "-")
+		tok = strings.TrimPrefix(tok, "-")
+		key, val, ok := strings.Cut(tok, ":")
+		if !ok || val == "" {
+			return Filter{}, fmt.Errorf("filter: bad term %q (want key:value)", tok)
+		}
+		key = strings.ToLower(key)
+		vals := splitList(val)
+		switch key {
+		case "type":
+			if neg {
+				f.ExcludeDocTypes = append(f.ExcludeDocTypes, vals...)
+			} else {
+				f.DocTypes = append(f.DocTypes, vals...)
+			}
+		case "category":
+			if neg {
+				return Filter{}, fmt.Errorf("filter: category exclusion is not supported")
+			}
+			f.Categories = append(f.Categories, vals...)
+		case "slug":
+			if !neg {
+				return Filter{}, fmt.Errorf("filter: slug only supports exclusion (-slug:...)")
+			}
+			f.ExcludeSlugs = append(f.ExcludeSlugs, vals...)
+		case "url":
+			// URLs may contain commas in theory, so keep the raw value.
+			if neg {
+				f.ExcludeURLPrefixes = append(f.ExcludeURLPrefixes, val)
+			} else {
+				f.URLPrefixes = append(f.URLPrefixes, val)
+			}
+		case "since", "until":
+			if neg {
+				return Filter{}, fmt.Errorf("filter: %s cannot be negated", key)
+			}
+			t, err := parseFilterDate(val)
+			if err != nil {
+				return Filter{}, fmt.Errorf("filter: %s: %w", key, err)
+			}
+			if key == "since" {
+				f.ModifiedAfter = t
+			} else {
+				f.ModifiedBefore = t
+			}
+		default:
+			return Filter{}, fmt.Errorf("filter: unknown key %q", key)
+		}
+	}
+	return f, nil
+}
+
+// String renders the filter back into ParseFilter syntax (for logs).
+func (f Filter) String() string {
+	var parts []string
+	add := func(prefix string, vals []string) {
+		if len(vals) > 0 {
+			parts = append(parts, prefix+strings.Join(vals, ","))
+		}
+	}
+	add("type:", f.DocTypes)
+	add("-type:", f.ExcludeDocTypes)
+	add("category:", f.Categories)
+	add("-slug:", f.ExcludeSlugs)
+	for _, p := range f.URLPrefixes {
+		parts = append(parts, "url:"+p)
+	}
+	for _, p := range f.ExcludeURLPrefixes {
+		parts = append(parts, "-url:"+p)
+	}
+	if !f.ModifiedAfter.IsZero() {
+		parts = append(parts, "since:"+f.ModifiedAfter.Format(time.RFC3339))
+	}
+	if !f.ModifiedBefore.IsZero() {
+		parts = append(parts, "until:"+f.ModifiedBefore.Format(time.RFC3339))
+	}
+	return strings.Join(parts, " ")
+}
+
+func parseFilterDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendCopy(a, b []string) []string {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(a)+len(b))
+	out = append(out, a...)
+	return append(out, b...)
+}
@@ -0,0 +1,52 @@
+package rag
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter("type:post -slug:privacy-policy,contact -url:https://alicanteabout.com/author/ since:2024-01-01")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(f.DocTypes) != 1 || f.DocTypes[0] != "post" {
+		t.Fatalf("unexpected doc types: %v", f.DocTypes)
+	}
+	if len(f.ExcludeSlugs) != 2 {
+		t.Fatalf("unexpected slugs: %v", f.ExcludeSlugs)
+	}
+	if !f.ModifiedAfter.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected since: %v", f.ModifiedAfter)
+	}
+
+	for _, bad := range []string{"type", "slug:x", "color:red", "since:yesterday"} {
+		if _, err := ParseFilter(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestTopKSearchFilter(t *testing.T) {
+	entries := []Entry{
+		{Chunk: Chunk{ChunkID: "a", DocType: "page", Slug: "privacy-policy", URL: "https://a", ModifiedGMT: "2024-05-01T00:00:00"}, Vec: []float32{1, 0}},
+		{Chunk: Chunk{ChunkID: "b", DocType: "post", Slug: "alicante-airport-bus", URL: "https://b", ModifiedGMT: "2022-01-01T00:00:00"}, Vec: []float32{0.9, 0.1}},
+		{Chunk: Chunk{ChunkID: "c", DocType: "post", Slug: "alicante-tram", URL: "https://c", ModifiedGMT: "2024-06-01T00:00:00"}, Vec: []float32{0.5, 0.5}},
+	}
+	q := []float32{1, 0}
+
+	got := TopKSearch(entries, q, 2, Filter{})
+	if len(got) != 2 || got[0].Chunk.ChunkID != "a" {
+		t.Fatalf("unfiltered search: unexpected results %+v", got)
+	}
+
+	got = TopKSearch(entries, q, 2, Filter{DocTypes: []string{"post"}})
+	if len(got) != 2 || got[0].Chunk.ChunkID != "b" || got[1].Chunk.ChunkID != "c" {
+		t.Fatalf("type filter: unexpected results %+v", got)
+	}
+
+	got = TopKSearch(entries, q, 2, Filter{ModifiedAfter: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), ExcludeSlugs: []string{"privacy-policy"}})
+	if len(got) != 1 || got[0].Chunk.ChunkID != "c" {
+		t.Fatalf("date filter: unexpected results %+v", got)
+	}
+}
@@ -0,0 +1,66 @@
+package rag
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Freshness configures recency boosting for time-sensitive questions.
+type Freshness struct {
+	// Weight is the share of the score subject to decay, in [0,1]; 0 disables boosting.
+	Weight float32
+	// HalfLife is the age at which the recency factor halves.
+	HalfLife time.Duration
+	// Now anchors the decay; zero means time.Now().
+	Now time.Time
+}
+
+var timeSensitivePattern = regexp.MustCompile(`\b(price|prices|cost|costs|fare|fares|ticket|tickets|how much|timetable|timetables|schedule|schedules|opening hours|open|hours|now|today|tonight|currently|current|latest|this (year|month|week|summer|winter)|next (year|month|week)|news|still|20[2-9][0-9])\b`)
+
+// IsTimeSensitive reports whether the query asks about facts that go stale:
+// prices, timetables, opening hours, news, "this year", "now".
+func IsTimeSensitive(query string) bool {
+	return timeSensitivePattern.MatchString(strings.ToLower(query))
+}
+
+// Decay returns the recency factor in (0,1] for a chunk: 1 for content
+// modified at Now, 0.5 after one HalfLife. Chunks without a date get 0.5.
+func (f Freshness) Decay(ch Chunk) float32 {
+	mod, ok := ch.Modified()
+	if !ok || f.HalfLife <= 0 {
+		return 0.5
+	}
+	now := f.Now
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	age := now.Sub(mod)
+	if age < 0 {
+		age = 0
+	}
+	return float32(math.Pow(0.5, age.Hours()/f.HalfLife.Hours()))
+}
+
+// ApplyFreshness mixes recency into the scores as score*((1-w) + w*decay)
+// and re-sorts. A zero Weight returns hits unchanged.
+func ApplyFreshness(hits []ScoredChunk, f Freshness) []ScoredChunk {
+	if f.Weight <= 0 || len(hits) == 0 {
+		return hits
+	}
+	w := f.Weight
+	if w > 1 {
+		w = 1
+	}
+	out := make([]ScoredChunk, len(hits))
+	copy(out, hits)
+	for i := range out {
+		out[i].Score *= (1 - w) + w*f.Decay(out[i].Chunk)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Score > out[j].Score
+	})
+	return out
+}
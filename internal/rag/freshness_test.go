@@ -0,0 +1,41 @@
+package rag
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsTimeSensitive(t *testing.T) {
+	for _, q := range []string{"How much is the airport bus?", "Tram timetable to Benidorm", "Is the MARQ open today?", "bus prices this year"} {
+		if !IsTimeSensitive(q) {
+			t.Fatalf("expected %q to be time-sensitive", q)
+		}
+	}
+	for _, q := range []string{"Is Alicante worth visiting?", "History of Santa Barbara Castle"} {
+		if IsTimeSensitive(q) {
+			t.Fatalf("expected %q not to be time-sensitive", q)
+		}
+	}
+}
+
+func TestApplyFreshness(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	hits := []ScoredChunk{
+		{Chunk: Chunk{ChunkID: "old", ModifiedGMT: "2021-01-01T00:00:00"}, Score: 0.80},
+		{Chunk: Chunk{ChunkID: "new", ModifiedGMT: "2024-12-01T00:00:00"}, Score: 0.75},
+	}
+	f := Freshness{Weight: 0.3, HalfLife: 365 * 24 * time.Hour, Now: now}
+
+	got := ApplyFreshness(hits, f)
+	if got[0].Chunk.ChunkID != "new" {
+		t.Fatalf("expected recent chunk first, got %s", got[0].Chunk.ChunkID)
+	}
+	if hits[0].Chunk.ChunkID != "old" || hits[0].Score != 0.80 {
+		t.Fatalf("input should not be mutated")
+	}
+
+	same := ApplyFreshness(hits, Freshness{})
+	if same[0].Chunk.ChunkID != "old" {
+		t.Fatalf("zero weight should keep order")
+	}
+}
@@ -0,0 +1,222 @@
+package rag
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Gate decision reasons.
+const (
+	GateNoResults     = "no_results"
+	GateBelowMin      = "below_min_score"
+	GateStrong        = "strong_score"
+	GateMargin        = "margin"
+	GateLexical       = "lexical_agreement"
+	GateAmbiguous     = "ambiguous"
+	GateCalibrated    = "calibrated"
+	GateCalibratedLow = "calibrated_low"
+	GateRerankLow     = "rerank_below_min"
+)
+
+// gateMarginHits is how many runner-up hits the margin is measured against.
+const gateMarginHits = 4
+
+// BM25 returns any doc sharing a term with the query, so lexical agreement
+// needs the vector top doc within the first gateLexicalHits lexical hits,
+// and that hit must contain gateLexicalCoverage of the query's terms.
+const (
+	gateLexicalHits     = 3
+	gateLexicalCoverage = 0.5
+)
+
+// GateSignals are the inputs of a relevance decision, recorded with it.
+type GateSignals struct {
+	Hits   int     `json:"hits"`
+	Top    float32 `json:"top"`
+	Margin float32 `json:"margin"` // top minus the mean of the next hits
+	// QueryTerms counts content words; short queries score lower on cosine.
+	QueryTerms int `json:"query_terms"`
+	// LexicalAgree is set when the top vector hit's document is among the
+	// top lexical (BM25) hits, with most of the query's terms.
+	LexicalAgree bool    `json:"lexical_agree"`
+	LexicalTop   float32 `json:"lexical_top"`
+	Probability  float64 `json:"probability,omitempty"`
+}
+
+// GateDecision is the outcome of GatePolicy.Decide.
+type GateDecision struct {
+	Answer  bool        `json:"answer"`
+	Reason  string      `json:"reason"`
+	Signals GateSignals `json:"signals"`
+}
+
+// GatePolicy decides whether retrieval found enough support to answer.
+// Below MinScore the question is declined and at or above StrongScore it is
+// answered. In between, the top hit must stand out (Margin >= MinMargin) or
+// lexical retrieval must agree; with a Calibration the calibrated
+// probability decides instead.
+type GatePolicy struct {
+	MinScore     float32
+	StrongScore  float32
+	MinMargin    float32
+	LexicalCheck bool
+	Calibration  *Calibration
+}
+
+// ComputeGateSignals derives the gate inputs from vector hits (sorted by
+// score) and lexical hits for the same query.
+func ComputeGateSignals(query string, hits, lexical []ScoredChunk) GateSignals {
+	sig := GateSignals{Hits: len(hits), QueryTerms: len(Terms(query))}
+	if len(hits) == 0 {
+		return sig
+	}
+	sig.Top = hits[0].Score
+	sig.Margin = sig.Top
+	if n := min(len(hits)-1, gateMarginHits); n > 0 {
+		var sum float32
+		for _, h := range hits[1 : n+1] {
+			sum += h.Score
+		}
+		sig.Margin = sig.Top - sum/float32(n)
+	}
+	if len(lexical) > 0 {
+		sig.LexicalTop = lexical[0].Score
+		terms := map[string]bool{}
+		for _, t := range Terms(query) {
+			terms[t] = true
+		}
+		for _, h := range lexical[:min(len(lexical), gateLexicalHits)] {
+			if h.Chunk.DocID == hits[0].Chunk.DocID && len(terms) > 0 && coverage(terms, h.Chunk.Title+"\n"+h.Chunk.Text) >= gateLexicalCoverage {
+				sig.LexicalAgree = true
+				break
+			}
+		}
+	}
+	return sig
+}
+
+// Decide applies the policy to sig.
+func (p GatePolicy) Decide(sig GateSignals) GateDecision {
+	d := GateDecision{Signals: sig}
+	switch {
+	case sig.Hits == 0:
+		d.Reason = GateNoResults
+	case sig.Top < p.MinScore:
+		d.Reason = GateBelowMin
+	case p.StrongScore <= p.MinScore || sig.Top >= p.StrongScore:
+		d.Answer, d.Reason = true, GateStrong
+	case p.Calibration != nil:
+		// Only the zone between MinScore and StrongScore is calibrated.
+		d.Signals.Probability = p.Calibration.Probability(sig)
+		d.Answer = d.Signals.Probability >= p.Calibration.Threshold
+		d.Reason = GateCalibrated
+		if !d.Answer {
+			d.Reason = GateCalibratedLow
+		}
+	case p.MinMargin > 0 && sig.Margin >= p.MinMargin:
+		d.Answer, d.Reason = true, GateMargin
+	case p.LexicalCheck && sig.LexicalAgree:
+		d.Answer, d.Reason = true, GateLexical
+	default:
+		d.Reason = GateAmbiguous
+	}
+	return d
+}
+
+// Calibration is a logistic model of P(answerable | signals) fitted on
+// labelled questions.
+type Calibration struct {
+	Weights   []float64 `json:"weights"` // top, margin, lexical_agree, query_terms/10
+	Bias      float64   `json:"bias"`
+	Threshold float64   `json:"threshold"`
+	Examples  int       `json:"examples"`
+}
+
+func calibrationFeatures(sig GateSignals) []float64 {
+	agree := 0.0
+	if sig.LexicalAgree {
+		agree = 1
+	}
+	return []float64{float64(sig.Top), float64(sig.Margin), agree, math.Min(float64(sig.QueryTerms), 10) / 10}
+}
+
+// Probability returns the calibrated probability that sig is answerable.
+func (c *Calibration) Probability(sig GateSignals) float64 {
+	z := c.Bias
+	for i, x := range calibrationFeatures(sig) {
+		if i < len(c.Weights) {
+			z += c.Weights[i] * x
+		}
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// FitCalibration fits a logistic regression by batch gradient descent.
+// labels[i] reports whether signals[i] came from an answerable question.
+func FitCalibration(signals []GateSignals, labels []bool, threshold float64) (*Calibration, error) {
+	if len(signals) != len(labels) || len(signals) < 4 {
+		return nil, fmt.Errorf("calibration needs at least 4 labelled examples, got %d", len(signals))
+	}
+	const (
+		iters = 5000
+		rate  = 1.0
+		l2    = 1e-3
+	)
+	feats := make([][]float64, len(signals))
+	for i, s := range signals {
+		feats[i] = calibrationFeatures(s)
+	}
+	c := &Calibration{Weights: make([]float64, len(feats[0])), Threshold: threshold, Examples: len(signals)}
+	for it := 0; it < iters; it++ {
+		grad := make([]float64, len(c.Weights))
+		var gradB float64
+		for i, x := range feats {
+			y := 0.0
+			if labels[i] {
+				y = 1
+			}
+			z := c.Bias
+			for j, v := range x {
+				z += c.Weights[j] * v
+			}
+			err := 1/(1+math.Exp(-z)) - y
+			for j, v := range x {
+				grad[j] += err * v
+			}
+			gradB += err
+		}
+		n := float64(len(feats))
+		for j := range c.Weights {
+			c.Weights[j] -= rate * (grad[j]/n + l2*c.Weights[j])
+		}
+		c.Bias -= rate * gradB / n
+	}
+	return c, nil
+}
+
+// LoadCalibration reads a calibration written by SaveCalibration.
+func LoadCalibration(path string) (*Calibration, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Calibration
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("calibration %s: %w", path, err)
+	}
+	if len(c.Weights) != len(calibrationFeatures(GateSignals{})) {
+		return nil, fmt.Errorf("calibration %s: want %d weights, got %d", path, len(calibrationFeatures(GateSignals{})), len(c.Weights))
+	}
+	return &c, nil
+}
+
+// SaveCalibration writes c as indented JSON.
+func SaveCalibration(path string, c *Calibration) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
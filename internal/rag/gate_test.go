@@ -0,0 +1,92 @@
+package rag
+
+import "testing"
+
+func TestGatePolicyDecide(t *testing.T) {
+	p := GatePolicy{MinScore: 0.25, StrongScore: 0.4, MinMargin: 0.05, LexicalCheck: true}
+	hit := func(doc int, score float32) ScoredChunk {
+		return ScoredChunk{Chunk: Chunk{DocID: doc}, Score: score}
+	}
+	lex := func(doc int, text string) ScoredChunk {
+		return ScoredChunk{Chunk: Chunk{DocID: doc, Text: text}, Score: 7}
+	}
+	onTopic := "The airport bus runs to the centre."
+	cases := []struct {
+		name    string
+		hits    []ScoredChunk
+		lexical []ScoredChunk
+		answer  bool
+		reason  string
+	}{
+		{"empty", nil, nil, false, GateNoResults},
+		{"low", []ScoredChunk{hit(1, 0.2)}, nil, false, GateBelowMin},
+		{"strong", []ScoredChunk{hit(1, 0.45), hit(2, 0.44)}, nil, true, GateStrong},
+		{"margin", []ScoredChunk{hit(1, 0.35), hit(2, 0.28), hit(3, 0.27)}, nil, true, GateMargin},
+		{"lexical", []ScoredChunk{hit(1, 0.3), hit(2, 0.29)}, []ScoredChunk{lex(3, "Bus fares."), lex(1, onTopic)}, true, GateLexical},
+		{"ambiguous", []ScoredChunk{hit(1, 0.3), hit(2, 0.29)}, []ScoredChunk{lex(9, onTopic)}, false, GateAmbiguous},
+		// Lexical hits that merely share a word are off-topic, not agreement.
+		{"lexical off-topic", []ScoredChunk{hit(1, 0.3), hit(2, 0.29)}, []ScoredChunk{lex(1, "Bars in the old town centre.")}, false, GateAmbiguous},
+		{"lexical runner-up", []ScoredChunk{hit(1, 0.3), hit(2, 0.29)}, []ScoredChunk{lex(2, onTopic)}, false, GateAmbiguous},
+		{"lexical too deep", []ScoredChunk{hit(1, 0.3), hit(2, 0.29)}, []ScoredChunk{lex(5, "Bus."), lex(6, "Bus."), lex(7, "Bus."), lex(1, onTopic)}, false, GateAmbiguous},
+	}
+	for _, c := range cases {
+		d := p.Decide(ComputeGateSignals("airport bus to the centre", c.hits, c.lexical))
+		if d.Answer != c.answer || d.Reason != c.reason {
+			t.Fatalf("%s: got answer=%t reason=%s, want %t %s", c.name, d.Answer, d.Reason, c.answer, c.reason)
+		}
+	}
+
+	legacy := GatePolicy{MinScore: 0.25}
+	if d := legacy.Decide(ComputeGateSignals("q", []ScoredChunk{hit(1, 0.26), hit(2, 0.26)}, nil)); !d.Answer {
+		t.Fatalf("zero StrongScore should behave like a plain threshold")
+	}
+}
+
+func TestFitCalibration(t *testing.T) {
+	var sigs []GateSignals
+	var labels []bool
+	for i := 0; i < 20; i++ {
+		good := i%2 == 0
+		s := GateSignals{Hits: 5, Top: 0.3, Margin: 0.01, QueryTerms: 3}
+		if good {
+			s.Margin, s.LexicalAgree = 0.08, true
+		}
+		sigs = append(sigs, s)
+		labels = append(labels, good)
+	}
+	c, err := FitCalibration(sigs, labels, 0.5)
+	if err != nil {
+		t.Fatalf("fit: %v", err)
+	}
+	if c.Probability(sigs[0]) < 0.8 || c.Probability(sigs[1]) > 0.2 {
+		t.Fatalf("calibration did not separate: %.3f vs %.3f", c.Probability(sigs[0]), c.Probability(sigs[1]))
+	}
+	p := GatePolicy{MinScore: 0.25, StrongScore: 0.4, Calibration: c}
+	if d := p.Decide(sigs[1]); d.Answer || d.Reason != GateCalibratedLow || d.Signals.Probability == 0 {
+		t.Fatalf("unexpected calibrated decision: %+v", d)
+	}
+	strong := sigs[1]
+	strong.Top = 0.9
+	if d := p.Decide(strong); !d.Answer || d.Reason != GateStrong {
+		t.Fatalf("calibration must not decline a strong score: %+v", d)
+	}
+}
+
+func TestBM25Search(t *testing.T) {
+	ix := NewBM25([]Chunk{
+		{ChunkID: "bus", DocType: "post", Title: "Airport bus", Text: "The C6 bus leaves the airport every 20 minutes."},
+		{ChunkID: "beach", DocType: "post", Title: "Beaches", Text: "Postiguet beach is next to the marina."},
+		{ChunkID: "page", DocType: "page", Title: "Buses", Text: "Bus bus bus timetable."},
+	})
+	got := ix.Search("which bus goes to the airport", 5, Filter{})
+	if len(got) != 2 || got[0].Chunk.ChunkID != "bus" {
+		t.Fatalf("unexpected lexical results: %+v", got)
+	}
+	if got := ix.Search("bus", 5, Filter{DocTypes: []string{"post"}}); len(got) != 1 {
+		t.Fatalf("filter not applied: %+v", got)
+	}
+	var nilIx *BM25
+	if nilIx.Search("bus", 5, Filter{}) != nil {
+		t.Fatalf("nil index should return nil")
+	}
+}
@@ -0,0 +1,57 @@
+package rag
+
+// MMR reranks hits with maximal marginal relevance and returns at most k of them.
+//
+// Each step picks the hit maximizing lambda*relevance - (1-lambda)*maxSim, where
+// maxSim is the highest cosine similarity to an already selected hit. lambda=1
+// keeps the original order; lower values favour diversity. Hits without a
+// vector are treated as dissimilar to everything. Scores are left untouched so
+// relevance gating downstream still sees cosine scores.
+func MMR(hits []ScoredChunk, lambda float32, k int) []ScoredChunk {
+	if k <= 0 || len(hits) == 0 {
+		return nil
+	}
+	if k > len(hits) {
+		k = len(hits)
+	}
+	if lambda >= 1 {
+		return hits[:k]
+	}
+	if lambda < 0 {
+		lambda = 0
+	}
+
+	remaining := make([]ScoredChunk, len(hits))
+	copy(remaining, hits)
+	selected := make([]ScoredChunk, 0, k)
+	maxSim := make([]float32, len(remaining))
+
+	for len(selected) < k && len(remaining) > 0 {
+		best := 0
+		bestScore := float32(0)
+		for i, h := range remaining {
+			score := lambda*h.Score - (1-lambda)*maxSim[i]
+			if i == 0 || score > bestScore {
+				best = i
+				bestScore = score
+			}
+		}
+		pick := remaining[best]
+		selected = append(selected, pick)
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		maxSim = append(maxSim[:best], maxSim[best+1:]...)
+
+		if len(pick.Vec) == 0 {
+			continue
+		}
+		for i, h := range remaining {
+			if len(h.Vec) == 0 {
+				continue
+			}
+			if sim := Dot(pick.Vec, h.Vec); sim > maxSim[i] {
+				maxSim[i] = sim
+			}
+		}
+	}
+	return selected
+}
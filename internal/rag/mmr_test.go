@@ -0,0 +1,31 @@
+package rag
+
+import "testing"
+
+func TestMMRPrefersDiverseHits(t *testing.T) {
+	march := []float32{1, 0, 0}
+	april := []float32{0.99, 0.141, 0}
+	Normalize(april)
+	bus := []float32{0, 0, 1}
+	hits := []ScoredChunk{
+		{Chunk: Chunk{ChunkID: "weather-march"}, Score: 0.80, Vec: march},
+		{Chunk: Chunk{ChunkID: "weather-april"}, Score: 0.79, Vec: april},
+		{Chunk: Chunk{ChunkID: "airport-bus"}, Score: 0.60, Vec: bus},
+	}
+
+	got := MMR(hits, 1, 2)
+	if got[0].Chunk.ChunkID != "weather-march" || got[1].Chunk.ChunkID != "weather-april" {
+		t.Fatalf("lambda=1 should keep relevance order, got %s,%s", got[0].Chunk.ChunkID, got[1].Chunk.ChunkID)
+	}
+
+	got = MMR(hits, 0.5, 2)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 hits, got %d", len(got))
+	}
+	if got[0].Chunk.ChunkID != "weather-march" || got[1].Chunk.ChunkID != "airport-bus" {
+		t.Fatalf("expected diverse pick, got %s,%s", got[0].Chunk.ChunkID, got[1].Chunk.ChunkID)
+	}
+	if got[1].Score != 0.60 {
+		t.Fatalf("expected original score to be kept, got %.2f", got[1].Score)
+	}
+}
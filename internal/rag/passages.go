@@ -0,0 +1,183 @@
+package rag
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// EstimateTokens approximates the token count of English text (~4 chars per token).
+func EstimateTokens(s string) int {
+	n := len([]rune(s))
+	return (n + 3) / 4
+}
+
+// PassageSelection describes the result of SelectPassages.
+type PassageSelection struct {
+	Text      string
+	TokensIn  int
+	TokensOut int
+	Trimmed   bool
+}
+
+var queryStopwords = map[string]struct{}{
+	"a": {}, "an": {}, "the": {}, "and": {}, "or": {}, "of": {}, "to": {}, "in": {}, "on": {}, "at": {}, "for": {},
+	"from": {}, "with": {}, "by": {}, "is": {}, "are": {}, "was": {}, "be": {}, "it": {}, "this": {}, "that": {},
+	"i": {}, "you": {}, "we": {}, "my": {}, "me": {}, "do": {}, "does": {}, "can": {}, "how": {}, "what": {},
+	"where": {}, "when": {}, "which": {}, "who": {}, "why": {}, "there": {}, "any": {}, "get": {}, "much": {},
+	"alicante": {},
+}
+
+// Terms lowercases, splits and lightly stems s, dropping stopwords.
+// "alicante" is a stopword because nearly every passage mentions it.
+func Terms(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if _, ok := queryStopwords[f]; ok {
+			continue
+		}
+		out = append(out, stem(f))
+	}
+	return out
+}
+
+// stem strips common English plural/verb suffixes; crude but symmetric.
+func stem(w string) string {
+	for _, suf := range []string{"ies", "es", "s", "ing", "ed"} {
+		if len(w) > len(suf)+3 && strings.HasSuffix(w, suf) {
+			return strings.TrimSuffix(w, suf)
+		}
+	}
+	return w
+}
+
+// SelectPassages keeps the paragraphs of text that best overlap with query
+// terms, within maxTokens. Selected paragraphs are returned in document
+// order with "…" marking skipped spans. Text that already fits is returned
+// untouched; maxTokens <= 0 disables trimming.
+func SelectPassages(query, text string, maxTokens int) PassageSelection {
+	in := EstimateTokens(text)
+	sel := PassageSelection{Text: text, TokensIn: in, TokensOut: in}
+	if maxTokens <= 0 || in <= maxTokens {
+		return sel
+	}
+
+	paras := splitParagraphs(text)
+	if len(paras) == 0 {
+		// Nothing but blank lines: there is no paragraph to pick.
+		cut := truncateToTokens(strings.TrimSpace(text), maxTokens)
+		return PassageSelection{Text: cut, TokensIn: in, TokensOut: EstimateTokens(cut), Trimmed: true}
+	}
+	qTerms := map[string]struct{}{}
+	for _, t := range Terms(query) {
+		qTerms[t] = struct{}{}
+	}
+	type scored struct {
+		idx    int
+		score  float64
+		tokens int
+	}
+	ranked := make([]scored, len(paras))
+	for i, p := range paras {
+		hits := 0
+		terms := Terms(p)
+		for _, t := range terms {
+			if _, ok := qTerms[t]; ok {
+				hits++
+			}
+		}
+		score := 0.0
+		if len(terms) > 0 {
+			// Reward matches, lightly penalise long paragraphs.
+			score = float64(hits) / (1 + float64(len(terms))/50)
+		}
+		ranked[i] = scored{idx: i, score: score, tokens: EstimateTokens(p)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	keep := make([]bool, len(paras))
+	used := 0
+	for _, r := range ranked {
+		if r.score <= 0 || used+r.tokens > maxTokens {
+			continue
+		}
+		keep[r.idx] = true
+		used += r.tokens
+	}
+	// Pull in short lines right before kept paragraphs: usually the heading.
+	for i := 1; i < len(paras); i++ {
+		if keep[i] && !keep[i-1] && len(paras[i-1]) <= 80 {
+			t := EstimateTokens(paras[i-1])
+			if used+t <= maxTokens {
+				keep[i-1] = true
+				used += t
+			}
+		}
+	}
+	// Spend what is left on non-matching paragraphs in reading order.
+	for i, p := range paras {
+		t := EstimateTokens(p)
+		if keep[i] || used+t > maxTokens {
+			continue
+		}
+		keep[i] = true
+		used += t
+	}
+	if used == 0 {
+		// Even the best paragraph is too long: hard-cut it.
+		best := paras[ranked[0].idx]
+		cut := truncateToTokens(best, maxTokens)
+		return PassageSelection{Text: cut, TokensIn: in, TokensOut: EstimateTokens(cut), Trimmed: true}
+	}
+
+	var sb strings.Builder
+	prev := -2
+	for i, p := range paras {
+		if !keep[i] {
+			continue
+		}
+		if sb.Len() > 0 {
+			if i == prev+1 {
+				sb.WriteString("\n")
+			} else {
+				sb.WriteString("\n…\n")
+			}
+		} else if i > 0 {
+			sb.WriteString("…\n")
+		}
+		sb.WriteString(p)
+		prev = i
+	}
+	if prev < len(paras)-1 {
+		sb.WriteString("\n…")
+	}
+	sel.Text = sb.String()
+	sel.TokensOut = EstimateTokens(sel.Text)
+	sel.Trimmed = true
+	return sel
+}
+
+func splitParagraphs(text string) []string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func truncateToTokens(s string, maxTokens int) string {
+	r := []rune(s)
+	limit := maxTokens * 4
+	if len(r) <= limit {
+		return s
+	}
+	return string(r[:limit]) + "…"
+}
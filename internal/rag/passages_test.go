@@ -0,0 +1,21 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectPassagesBlankText(t *testing.T) {
+	sel := SelectPassages("bus", strings.Repeat(" \n", 20), 2)
+	if sel.Text != "" || !sel.Trimmed || sel.TokensOut != 0 {
+		t.Fatalf("unexpected selection %+v", sel)
+	}
+}
+
+func TestSelectPassagesKeepsMatchingParagraph(t *testing.T) {
+	text := "Beaches\n\n" + strings.Repeat("Sand and sea all year round. ", 20) + "\n\nAirport bus\n\nThe C6 bus runs every 20 minutes."
+	sel := SelectPassages("airport bus", text, 20)
+	if !strings.Contains(sel.Text, "The C6 bus runs every 20 minutes.") || strings.Contains(sel.Text, "Sand and sea") || !sel.Trimmed {
+		t.Fatalf("unexpected selection %q", sel.Text)
+	}
+}
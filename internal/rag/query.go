@@ -0,0 +1,314 @@
+package rag
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Place is a gazetteer entry: the spelling used by AlicanteAbout content plus
+// the names visitors actually type.
+type Place struct {
+	Canonical string
+	// Aliases are rewritten to Canonical (Valencian/Spanish/English names, codes).
+	Aliases []string
+	// Exact aliases are ordinary words that are only rewritten when spelled
+	// exactly, so a near miss ("metre") is not mistaken for them ("metro").
+	Exact []string
+	// Generic phrases describe the place without naming it ("archaeological
+	// museum"). They are never replaced; with expansion on they append
+	// Canonical and the Related terms.
+	Generic []string
+	// Related terms are appended when query expansion is enabled.
+	Related []string
+}
+
+// DefaultGazetteer maps common Alicante names onto the spellings used on the site.
+var DefaultGazetteer = []Place{
+	{Canonical: "Alicante", Aliases: []string{"Alacant", "Alicant"}},
+	{
+		Canonical: "Alicante-Elche Airport",
+		Aliases:   []string{"El Altet", "El Altet airport", "ALC", "LEAL", "Alicante airport", "Aeropuerto de Alicante", "Aeroport d'Alacant", "Alicante-Elche Miguel Hernandez Airport"},
+		Related:   []string{"airport", "El Altet"},
+	},
+	{
+		Canonical: "Santa Barbara Castle",
+		Aliases:   []string{"Castillo de Santa Barbara", "Castell de Santa Barbara", "Santa Barbara"},
+		Related:   []string{"castle", "Benacantil"},
+	},
+	{
+		Canonical: "Explanada de España",
+		Aliases:   []string{"Explanada", "Esplanade", "Explanada d'Espanya", "Esplanada"},
+		Related:   []string{"promenade"},
+	},
+	{Canonical: "El Postiguet Beach", Aliases: []string{"Postiguet", "Playa del Postiguet", "Platja del Postiguet", "Postiguet Beach"}, Related: []string{"beach"}},
+	{Canonical: "San Juan Beach", Aliases: []string{"Playa de San Juan", "Platja de Sant Joan", "Sant Joan beach"}, Related: []string{"beach"}},
+	{Canonical: "La Albufereta Beach", Aliases: []string{"Albufereta", "Playa de la Albufereta", "Platja de l'Albufereta"}, Related: []string{"beach"}},
+	{Canonical: "Cabo de Las Huertas", Aliases: []string{"Cap de l'Horta", "Cabo Huertas"}, Related: []string{"coves"}},
+	{Canonical: "Tabarca", Aliases: []string{"Nueva Tabarca", "Isla de Tabarca", "Illa de Tabarca", "Tabarca island"}, Related: []string{"island", "boat"}},
+	{Canonical: "Elche", Aliases: []string{"Elx"}},
+	{Canonical: "Hogueras de San Juan", Aliases: []string{"Fogueres de Sant Joan", "Fogueres", "Hogueras"}, Generic: []string{"Alicante bonfires"}, Related: []string{"bonfires", "festival", "June"}},
+	{Canonical: "MARQ Museum", Aliases: []string{"MARQ", "Museo Arqueologico", "Museu Arqueologic"}, Generic: []string{"Archaeological Museum"}, Related: []string{"museum"}},
+	{Canonical: "Luceros", Aliases: []string{"Plaza de los Luceros", "Placa dels Estels"}, Related: []string{"tram station"}},
+	{Canonical: "TRAM", Aliases: []string{"TRAM d'Alacant", "FGV"}, Exact: []string{"tramway", "metro"}, Related: []string{"tram"}},
+	{Canonical: "C6", Aliases: []string{"C-6", "C 6"}, Related: []string{"airport bus"}},
+	{Canonical: "Vectalia", Aliases: []string{"Masatusa", "Alcoyana"}, Related: []string{"city bus"}},
+	{Canonical: "ALSA", Aliases: []string{"Alsa bus"}, Related: []string{"intercity bus"}},
+	{Canonical: "Renfe", Related: []string{"train", "Cercanias"}},
+	{Canonical: "Benidorm"},
+}
+
+// QueryRewrite is the result of preprocessing a user question.
+type QueryRewrite struct {
+	Original  string
+	Rewritten string
+	// Matches lists the canonical names recognised in the query.
+	Matches []string
+	// Expansions are related terms; empty unless expansion is enabled.
+	Expansions []string
+}
+
+// Changed reports whether rewriting or expansion altered the query.
+func (r QueryRewrite) Changed() bool {
+	return r.Rewritten != r.Original || len(r.Expansions) > 0
+}
+
+// Text returns the query to embed and search: the rewritten question plus any expansions.
+func (r QueryRewrite) Text() string {
+	if len(r.Expansions) == 0 {
+		return r.Rewritten
+	}
+	return r.Rewritten + " (" + strings.Join(r.Expansions, ", ") + ")"
+}
+
+// QueryRewriter normalises place names and optionally expands queries.
+type QueryRewriter struct {
+	expand  bool
+	places  []Place
+	aliases []aliasPhrase
+}
+
+type aliasPhrase struct {
+	tokens  []string
+	place   int
+	exact   bool
+	generic bool
+}
+
+// NewQueryRewriter indexes places for alias matching. When expand is true the
+// Related terms of every matched place are returned as expansions.
+func NewQueryRewriter(places []Place, expand bool) *QueryRewriter {
+	r := &QueryRewriter{expand: expand, places: places}
+	for i, p := range places {
+		names := append([]string{p.Canonical}, p.Aliases...)
+		exact := append(names, p.Exact...)
+		for k, name := range append(exact, p.Generic...) {
+			toks := tokenize(name)
+			if len(toks) == 0 {
+				continue
+			}
+			words := make([]string, len(toks))
+			for j, t := range toks {
+				words[j] = t.norm
+			}
+			r.aliases = append(r.aliases, aliasPhrase{tokens: words, place: i, exact: k >= len(names) && k < len(exact), generic: k >= len(exact)})
+		}
+	}
+	return r
+}
+
+// Rewrite replaces known aliases (including near-miss spellings) with their
+// canonical names; generic phrases are left as typed and only expanded. Matching is case- and accent-insensitive and prefers the
+// longest alias at each position.
+func (r *QueryRewriter) Rewrite(q string) QueryRewrite {
+	out := QueryRewrite{Original: q, Rewritten: q}
+	if r == nil {
+		return out
+	}
+	toks := tokenize(q)
+	var sb strings.Builder
+	last := 0
+	seen := map[int]bool{}
+	for i := 0; i < len(toks); {
+		place, n, generic := r.match(toks[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		start, end := toks[i].start, toks[i+n-1].end
+		canonical := r.places[place].Canonical
+		i += n
+		if generic {
+			if r.expand && !seen[place] {
+				seen[place] = true
+				out.Expansions = appendNew(out.Expansions, append([]string{canonical}, r.places[place].Related...), q)
+			}
+			continue
+		}
+		sb.WriteString(q[last:start])
+		if normalizePhrase(q[start:end]) == normalizePhrase(canonical) {
+			sb.WriteString(q[start:end])
+		} else {
+			sb.WriteString(canonical)
+		}
+		last = end
+		if !seen[place] {
+			seen[place] = true
+			out.Matches = append(out.Matches, canonical)
+			if r.expand {
+				out.Expansions = appendNew(out.Expansions, r.places[place].Related, q)
+			}
+		}
+	}
+	sb.WriteString(q[last:])
+	out.Rewritten = sb.String()
+	return out
+}
+
+// match returns the place and token count of the longest alias starting at
+// toks[0], and whether it is a generic phrase. Among aliases of equal
+// length, the one needing fewer edits wins.
+func (r *QueryRewriter) match(toks []token) (int, int, bool) {
+	bestPlace, bestLen, bestEdits, bestGeneric := -1, 0, 0, false
+	for _, a := range r.aliases {
+		if len(a.tokens) > len(toks) || len(a.tokens) < bestLen {
+			continue
+		}
+		edits := 0
+		ok := true
+		for j, w := range a.tokens {
+			d, match := fuzzyEqual(toks[j].norm, w)
+			if !match || a.exact && d > 0 {
+				ok = false
+				break
+			}
+			edits += d
+		}
+		if !ok {
+			continue
+		}
+		if len(a.tokens) > bestLen || edits < bestEdits {
+			bestPlace, bestLen, bestEdits, bestGeneric = a.place, len(a.tokens), edits, a.generic
+		}
+	}
+	if bestPlace < 0 {
+		return 0, 0, false
+	}
+	return bestPlace, bestLen, bestGeneric
+}
+
+// fuzzyEqual tolerates one typo for words of 5+ letters and two for 9+, and
+// returns the edit count. Short words and codes (ALC, C6) must match exactly.
+func fuzzyEqual(got, want string) (int, bool) {
+	if got == want {
+		return 0, true
+	}
+	n := len([]rune(want))
+	if n < 5 || got == "" || []rune(got)[0] != []rune(want)[0] {
+		return 0, false
+	}
+	maxEdits := 1
+	if n >= 9 {
+		maxEdits = 2
+	}
+	d := editDistance(got, want)
+	return d, d <= maxEdits
+}
+
+// editDistance is the optimal string alignment distance (Levenshtein plus
+// adjacent transpositions), which catches "Postigeut"-style typos.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+type token struct {
+	norm       string
+	start, end int
+}
+
+// tokenize splits on anything that is not a letter or digit and records byte
+// offsets so matches can be replaced in the original string.
+func tokenize(s string) []token {
+	var toks []token
+	start := -1
+	for i, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			toks = append(toks, token{norm: foldWord(s[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{norm: foldWord(s[start:]), start: start, end: len(s)})
+	}
+	return toks
+}
+
+func normalizePhrase(s string) string {
+	toks := tokenize(s)
+	words := make([]string, len(toks))
+	for i, t := range toks {
+		words[i] = t.norm
+	}
+	return strings.Join(words, " ")
+}
+
+var accentFold = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c",
+)
+
+func foldWord(s string) string {
+	return accentFold.Replace(strings.ToLower(s))
+}
+
+// appendNew adds terms not already present in dst or in the query itself.
+func appendNew(dst, terms []string, query string) []string {
+	q := " " + normalizePhrase(query) + " "
+	for _, t := range terms {
+		n := normalizePhrase(t)
+		if strings.Contains(q, " "+n+" ") {
+			continue
+		}
+		dup := false
+		for _, d := range dst {
+			if normalizePhrase(d) == n {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			dst = append(dst, t)
+		}
+	}
+	return dst
+}
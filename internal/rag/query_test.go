@@ -0,0 +1,57 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryRewriterAliases(t *testing.T) {
+	rw := NewQueryRewriter(DefaultGazetteer, false)
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{in: "How do I get from El Altet to Alacant?", want: "How do I get from Alicante-Elche Airport to Alicante?"},
+		{in: "ALC to Benidorm by bus", want: "Alicante-Elche Airport to Benidorm by bus"},
+		{in: "Opening hours of the Castillo de Santa Bárbara", want: "Opening hours of the Santa Barbara Castle"},
+		{in: "Is Santa Barbara castle free?", want: "Is Santa Barbara castle free?"},
+		{in: "Best cafes near the Explanada", want: "Best cafes near the Explanada de España"},
+		{in: "Is Postigeut beach crowded?", want: "Is El Postiguet Beach crowded?"},
+		{in: "When does the C-6 leave?", want: "When does the C6 leave?"},
+		{in: "Is the weather nice in March?", want: "Is the weather nice in March?"},
+		{in: "Take the metro to Luceros", want: "Take the TRAM to Luceros"},
+		{in: "How much is a square metre?", want: "How much is a square metre?"},
+		{in: "Is there an archaeological museum?", want: "Is there an archaeological museum?"},
+		{in: "Is the Museo Arqueologico open?", want: "Is the MARQ Museum open?"},
+	}
+	for _, tc := range cases {
+		got := rw.Rewrite(tc.in)
+		if got.Rewritten != tc.want {
+			t.Fatalf("Rewrite(%q) = %q, want %q", tc.in, got.Rewritten, tc.want)
+		}
+		if len(got.Expansions) != 0 {
+			t.Fatalf("expected no expansions when disabled, got %v", got.Expansions)
+		}
+	}
+}
+
+func TestQueryRewriterExpansion(t *testing.T) {
+	rw := NewQueryRewriter(DefaultGazetteer, true)
+	got := rw.Rewrite("Fogueres dates")
+	if got.Rewritten != "Hogueras de San Juan dates" {
+		t.Fatalf("unexpected rewrite: %q", got.Rewritten)
+	}
+	if !strings.Contains(got.Text(), "bonfires") {
+		t.Fatalf("expected expansion with bonfires, got %q", got.Text())
+	}
+
+	got = rw.Rewrite("Is there an archaeological museum?")
+	if got.Rewritten != got.Original || !strings.Contains(got.Text(), "MARQ Museum") {
+		t.Fatalf("generic phrase should be expanded, not replaced: %q", got.Text())
+	}
+
+	var nilRewriter *QueryRewriter
+	if out := nilRewriter.Rewrite("Alacant"); out.Changed() {
+		t.Fatalf("nil rewriter should be a no-op")
+	}
+}
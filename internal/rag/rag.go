@@ -20,50 +20,71 @@ import (
 )
 
 type Chunk struct {
-	ChunkID     string `json:"chunk_id"`
-	DocID       int    `json:"doc_id"`
-	DocType     string `json:"type"`
-	Slug        string `json:"slug"`
-	Title       string `json:"title"`
-	URL         string `json:"url"`
-	ModifiedGMT string `json:"modified_gmt"`
-	IndexPage   bool   `json:"index_page"`
-	Text        string `json:"text"`
-	CharLen     int    `json:"char_len"`
+	ChunkID     string   `json:"chunk_id"`
+	DocID       int      `json:"doc_id"`
+	DocType     string   `json:"type"`
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	ModifiedGMT string   `json:"modified_gmt"`
+	IndexPage   bool     `json:"index_page"`
+	Categories  []string `json:"categories,omitempty"`
+	ChunkIndex  int      `json:"chunk_index,omitempty"` // position within the doc
+	Section     string   `json:"section,omitempty"`     // heading path, e.g. "Prices > Night bus"
+	Text        string   `json:"text"`
+	CharLen     int      `json:"char_len"`
+	Alternates  []string `json:"alternates,omitempty"` // URLs of collapsed near-duplicates
 }
 
 // RawChunk represents the format in alicanteabout_chunks.json
 type RawChunk struct {
-	ID          int    `json:"id"`
-	DocType     string `json:"type"`
-	Slug        string `json:"slug"`
-	Title       string `json:"title"`
-	URL         string `json:"url"`
-	ModifiedGMT string `json:"modified_gmt"`
-	ContentText string `json:"content_text"`
+	ID          int      `json:"id"`
+	DocType     string   `json:"type"`
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	ModifiedGMT string   `json:"modified_gmt"`
+	Categories  []string `json:"categories,omitempty"`
+	ContentText string   `json:"content_text"`
 }
 
 type EmbedCacheItem struct {
-	ID        string    `json:"id"` // chunk_id
+	ID        string    `json:"id"`             // chunk_id
+	Kind      string    `json:"kind,omitempty"` // empty means body
 	Hash      string    `json:"hash"`
 	Dim       int       `json:"dim"`
 	Vector    []float32 `json:"vector"`
 	UpdatedAt string    `json:"updated_at"`
 }
 
+// EmbedCache stores vectors for one or more embedding models side by side,
+// so switching models does not throw away the vectors of the previous one.
 type EmbedCache struct {
-	Model string                    `json:"model"`
-	Items map[string]EmbedCacheItem `json:"items"`
+	Version int                      `json:"version,omitempty"`
+	Models  map[string]*ModelVectors `json:"models"`
+}
+
+// ModelVectors holds the vectors produced by a single embedding model.
+type ModelVectors struct {
+	// Kinds records the KindVersions each stored vector kind was built with.
+	Kinds map[string]int `json:"kinds,omitempty"`
+	// Template is the ID of the body input template, e.g. "default@v1".
+	Template string                    `json:"template,omitempty"`
+	Items    map[string]EmbedCacheItem `json:"items"` // keyed by ItemKey(kind, chunk_id)
 }
 
 type ScoredChunk struct {
 	Chunk Chunk
 	Score float32
+	// Vec is the (normalized) chunk vector, shared with the index; do not mutate.
+	Vec []float32
 }
 
 type Entry struct {
 	Chunk Chunk
-	Vec   []float32
+	Vec   []float32 // body vector
+	// KindVecs holds additional normalized vectors (title, summary) by kind.
+	KindVecs map[string][]float32
 }
 
 // ReadChunks loads chunks from JSON array (.json) or JSONL (.jsonl).
@@ -98,6 +119,7 @@ func readChunksJSON(f *os.File) ([]Chunk, error) {
 			URL:         r.URL,
 			ModifiedGMT: r.ModifiedGMT,
 			IndexPage:   false,
+			Categories:  r.Categories,
 			Text:        r.ContentText,
 			CharLen:     len(r.ContentText),
 		}
@@ -107,7 +129,7 @@ func readChunksJSON(f *os.File) ([]Chunk, error) {
 }
 
 // readChunksJSONL reads JSONL format (one Chunk per line).
-func readChunksJSONL(f *os.File) ([]Chunk, error) {
+func readChunksJSONL(f io.Reader) ([]Chunk, error) {
 	var chunks []Chunk
 	sc := bufio.NewScanner(f)
 	buf := make([]byte, 0, 1024*1024)
@@ -131,26 +153,157 @@ func readChunksJSONL(f *os.File) ([]Chunk, error) {
 }
 
 // BuildIndex creates a normalized in-memory matrix from cached embeddings.
+// Chunks need a body vector to be indexed; title/summary vectors are attached
+// when present. Vectors that fail CheckVector are skipped.
 func BuildIndex(chunks []Chunk, cache *EmbedCache, model string) []Entry {
+	return BuildIndexDims(chunks, cache, model, 0)
+}
+
+// BuildIndexDims is BuildIndex with vectors reduced to dims dimensions.
+// Vectors embedded at dims (the ModelKey(model, dims) section) are used
+// first; otherwise full vectors are truncated and renormalized, which is
+// equivalent for Matryoshka-trained models such as text-embedding-3.
+// dims <= 0 keeps full vectors.
+func BuildIndexDims(chunks []Chunk, cache *EmbedCache, model string, dims int) []Entry {
+	sections := []*ModelVectors{cache.Models[ModelKey(model, dims)]}
+	if dims > 0 {
+		sections = append(sections, cache.Models[model])
+	}
 	entries := make([]Entry, 0, len(chunks))
 	for _, ch := range chunks {
-		item, ok := cache.Items[ch.ChunkID]
-		if !ok || cache.Model != model {
-			continue
+		for _, mv := range sections {
+			if e, ok := indexEntry(ch, mv, dims); ok {
+				entries = append(entries, e)
+				break
+			}
 		}
-		v := make([]float32, len(item.Vector))
-		copy(v, item.Vector)
-		Normalize(v)
-		entries = append(entries, Entry{Chunk: ch, Vec: v})
 	}
 	return entries
 }
 
+func indexEntry(ch Chunk, mv *ModelVectors, dims int) (Entry, bool) {
+	if mv == nil {
+		return Entry{}, false
+	}
+	item, ok := mv.Items[ch.ChunkID]
+	if !ok || CheckVector(item, 0) != "" || item.Dim < dims {
+		return Entry{}, false
+	}
+	e := Entry{Chunk: ch, Vec: TruncateVector(item.Vector, dims)}
+	for _, kind := range []string{KindTitle, KindSummary} {
+		extra, ok := mv.Items[ItemKey(kind, ch.ChunkID)]
+		if !ok || CheckVector(extra, item.Dim) != "" {
+			continue
+		}
+		if e.KindVecs == nil {
+			e.KindVecs = map[string][]float32{}
+		}
+		e.KindVecs[kind] = TruncateVector(extra.Vector, dims)
+	}
+	return e, true
+}
+
+// TruncateVector returns a normalized copy of the first dims components of
+// vec (Matryoshka shortening). dims <= 0 or >= len(vec) keeps every component.
+func TruncateVector(vec []float32, dims int) []float32 {
+	if dims > 0 && dims < len(vec) {
+		vec = vec[:dims]
+	}
+	return normalizedCopy(vec)
+}
+
+// ModelKey names the cache section for vectors embedded at dims dimensions,
+// e.g. "text-embedding-3-small@256". dims <= 0 is the full-size model.
+func ModelKey(model string, dims int) string {
+	if dims <= 0 {
+		return model
+	}
+	return fmt.Sprintf("%s@%d", model, dims)
+}
+
+func normalizedCopy(vec []float32) []float32 {
+	v := make([]float32, len(vec))
+	copy(v, vec)
+	Normalize(v)
+	return v
+}
+
+// EmbedOptions controls which vectors EmbedAll produces and how.
+type EmbedOptions struct {
+	// Kinds to embed; defaults to body only.
+	Kinds []string
+	// Template renders body inputs; the zero value uses the default template.
+	Template EmbedTemplate
+	// Dims, when > 0, asks the API for shortened vectors and stores them
+	// in the ModelKey(model, Dims) section.
+	Dims      int
+	BatchSize int
+	Sleep     time.Duration
+}
+
+func (o EmbedOptions) withDefaults() (EmbedOptions, error) {
+	if len(o.Kinds) == 0 {
+		o.Kinds = []string{KindBody}
+	}
+	if o.Template.tmpl == nil {
+		t, err := LookupEmbedTemplate(o.Template.Name)
+		if err != nil {
+			return o, err
+		}
+		o.Template = t
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 64
+	}
+	return o, nil
+}
+
+type pendingEmbed struct {
+	ch    Chunk
+	kind  string
+	input string
+	hash  string
+}
+
+// staleEmbeddings renders every (chunk, kind) input and returns those whose
+// cached vector in the section key is absent or was built from a different input.
+func staleEmbeddings(chunks []Chunk, cache *EmbedCache, key string, opts EmbedOptions) ([]pendingEmbed, error) {
+	var todo []pendingEmbed
+	for _, ch := range chunks {
+		for _, kind := range opts.Kinds {
+			input, err := KindInput(kind, ch, opts.Template)
+			if err != nil {
+				return nil, err
+			}
+			h := itemHash(kind, input, opts.Template)
+			if mv := cache.Models[key]; mv != nil {
+				item, ok := mv.Items[ItemKey(kind, ch.ChunkID)]
+				if ok && item.Hash == h && CheckVector(item, 0) == "" {
+					continue
+				}
+			}
+			todo = append(todo, pendingEmbed{ch: ch, kind: kind, input: input, hash: h})
+		}
+	}
+	return todo, nil
+}
+
+// MissingEmbeddings counts the (chunk, kind) vectors that are absent or stale.
+func MissingEmbeddings(chunks []Chunk, cache *EmbedCache, model string, opts EmbedOptions) (int, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+	todo, err := staleEmbeddings(chunks, cache, ModelKey(model, opts.Dims), opts)
+	return len(todo), err
+}
+
 // ---------------- Embeddings (OpenAI) ----------------
 
 type openAIEmbeddingsRequest struct {
-	Model string      `json:"model"`
-	Input interface{} `json:"input"` // string or []string
+	Model      string      `json:"model"`
+	Input      interface{} `json:"input"`                // string or []string
+	Dimensions int         `json:"dimensions,omitempty"` // text-embedding-3 only
 }
 
 type openAIEmbeddingsResponse struct {
@@ -164,21 +317,27 @@ type openAIEmbeddingsResponse struct {
 	} `json:"error,omitempty"`
 }
 
-func EmbedAll(ctx context.Context, client *http.Client, provider, apiKey, model string, chunks []Chunk, cache *EmbedCache, batchSize int, sleep time.Duration) error {
-	type pending struct {
-		ch   Chunk
-		hash string
+// EmbedAll embeds every missing or stale (chunk, kind) vector into the
+// cache section for model; other models' vectors are left untouched.
+func EmbedAll(ctx context.Context, client *http.Client, provider, apiKey, model string, chunks []Chunk, cache *EmbedCache, opts EmbedOptions) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return err
 	}
-	var todo []pending
-	for _, ch := range chunks {
-		h := TextHash(ch.Text)
-		item, ok := cache.Items[ch.ChunkID]
-		if ok && cache.Model == model && item.Hash == h && item.Dim > 0 && len(item.Vector) == item.Dim {
-			continue
-		}
-		todo = append(todo, pending{ch: ch, hash: h})
+	todo, err := staleEmbeddings(chunks, cache, ModelKey(model, opts.Dims), opts)
+	if err != nil {
+		return err
+	}
+	mv := cache.ForModel(ModelKey(model, opts.Dims))
+	if mv.Kinds == nil {
+		mv.Kinds = map[string]int{}
 	}
+	for _, kind := range opts.Kinds {
+		mv.Kinds[kind] = KindVersions[kind]
+	}
+	mv.Template = opts.Template.ID()
 
+	batchSize := opts.BatchSize
 	for i := 0; i < len(todo); i += batchSize {
 		end := i + batchSize
 		if end > len(todo) {
@@ -188,10 +347,10 @@ func EmbedAll(ctx context.Context, client *http.Client, provider, apiKey, model
 
 		inputs := make([]string, 0, len(batch))
 		for _, p := range batch {
-			inputs = append(inputs, fmt.Sprintf("%s\n%s\n\n%s", p.ch.Title, p.ch.URL, p.ch.Text))
+			inputs = append(inputs, p.input)
 		}
 
-		vecs, err := EmbedTexts(ctx, client, provider, apiKey, model, inputs)
+		vecs, err := EmbedTextsDims(ctx, client, provider, apiKey, model, opts.Dims, inputs)
 		if err != nil {
 			return err
 		}
@@ -202,8 +361,13 @@ func EmbedAll(ctx context.Context, client *http.Client, provider, apiKey, model
 		now := time.Now().UTC().Format(time.RFC3339)
 		for j, p := range batch {
 			v := vecs[j]
-			cache.Items[p.ch.ChunkID] = EmbedCacheItem{
+			kind := p.kind
+			if kind == KindBody {
+				kind = ""
+			}
+			mv.Items[ItemKey(p.kind, p.ch.ChunkID)] = EmbedCacheItem{
 				ID:        p.ch.ChunkID,
+				Kind:      kind,
 				Hash:      p.hash,
 				Dim:       len(v),
 				Vector:    v,
@@ -211,15 +375,20 @@ func EmbedAll(ctx context.Context, client *http.Client, provider, apiKey, model
 			}
 		}
 
-		if sleep > 0 {
-			time.Sleep(sleep)
+		if opts.Sleep > 0 {
+			time.Sleep(opts.Sleep)
 		}
 	}
 	return nil
 }
 
 func EmbedQuery(ctx context.Context, client *http.Client, provider, apiKey, model, query string) ([]float32, error) {
-	vecs, err := EmbedTexts(ctx, client, provider, apiKey, model, []string{query})
+	return EmbedQueryDims(ctx, client, provider, apiKey, model, 0, query)
+}
+
+// EmbedQueryDims embeds query at dims dimensions (0 = model default).
+func EmbedQueryDims(ctx context.Context, client *http.Client, provider, apiKey, model string, dims int, query string) ([]float32, error) {
+	vecs, err := EmbedTextsDims(ctx, client, provider, apiKey, model, dims, []string{query})
 	if err != nil {
 		return nil, err
 	}
@@ -227,18 +396,24 @@ func EmbedQuery(ctx context.Context, client *http.Client, provider, apiKey, mode
 }
 
 func EmbedTexts(ctx context.Context, client *http.Client, provider, apiKey, model string, inputs []string) ([][]float32, error) {
+	return EmbedTextsDims(ctx, client, provider, apiKey, model, 0, inputs)
+}
+
+// EmbedTextsDims is EmbedTexts with the API dimensions parameter set when dims > 0.
+func EmbedTextsDims(ctx context.Context, client *http.Client, provider, apiKey, model string, dims int, inputs []string) ([][]float32, error) {
 	switch provider {
 	case "openai":
-		return openAIEmbed(ctx, client, apiKey, model, inputs)
+		return openAIEmbed(ctx, client, apiKey, model, dims, inputs)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
 }
 
-func openAIEmbed(ctx context.Context, client *http.Client, apiKey, model string, inputs []string) ([][]float32, error) {
+func openAIEmbed(ctx context.Context, client *http.Client, apiKey, model string, dims int, inputs []string) ([][]float32, error) {
 	reqBody := openAIEmbeddingsRequest{
-		Model: model,
-		Input: inputs,
+		Model:      model,
+		Input:      inputs,
+		Dimensions: dims,
 	}
 	b, _ := json.Marshal(reqBody)
 
@@ -246,7 +421,7 @@ func openAIEmbed(ctx context.Context, client *http.Client, apiKey, model string,
 	if reqID == "" {
 		reqID = "unknown"
 	}
-	log.Printf("req_id=%s openai embeddings request_bytes=%d inputs=%d model=%s", reqID, len(b), len(inputs), model)
+	log.Printf("req_id=%s openai embeddings request_bytes=%d inputs=%d model=%s dims=%d", reqID, len(b), len(inputs), model, dims)
 	start := time.Now()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/embeddings", bytes.NewReader(b))
 	if err != nil {
@@ -292,15 +467,26 @@ func openAIEmbed(ctx context.Context, client *http.Client, apiKey, model string,
 
 // ---------------- Search ----------------
 
-func TopKSearch(entries []Entry, q []float32, k int) []ScoredChunk {
+// TopKSearch scores entries that pass filter against q and returns the best k.
+// The filter is applied before top-K selection, so excluded chunks never take a slot.
+// Only body vectors are used; see TopKSearchWeighted for multi-vector scoring.
+func TopKSearch(entries []Entry, q []float32, k int, filter Filter) []ScoredChunk {
+	return TopKSearchWeighted(entries, q, k, filter, nil)
+}
+
+// TopKSearchWeighted is TopKSearch with scores aggregated across vector kinds.
+func TopKSearchWeighted(entries []Entry, q []float32, k int, filter Filter, weights VectorWeights) []ScoredChunk {
 	if k <= 0 {
 		return nil
 	}
 	results := make([]ScoredChunk, 0, k)
 
 	for _, e := range entries {
-		s := Dot(q, e.Vec)
-		results = append(results, ScoredChunk{Chunk: e.Chunk, Score: s})
+		if !filter.Match(e.Chunk) {
+			continue
+		}
+		s := e.Score(q, weights)
+		results = append(results, ScoredChunk{Chunk: e.Chunk, Score: s, Vec: e.Vec})
 	}
 
 	sort.Slice(results, func(i, j int) bool {
@@ -357,7 +543,11 @@ func BuildPrompt(question string, hits []ScoredChunk) string {
 
 	sb.WriteString("Sources (excerpts):\n")
 	for i, h := range hits {
-		sb.WriteString(fmt.Sprintf("\n[%d] %s\nURL: %s\nExcerpt:\n%s\n", i+1, h.Chunk.Title, h.Chunk.URL, h.Chunk.Text))
+		sb.WriteString(fmt.Sprintf("\n[%d] %s\nURL: %s\n", i+1, h.Chunk.Title, h.Chunk.URL))
+		if mod, ok := h.Chunk.Modified(); ok {
+			sb.WriteString(fmt.Sprintf("Last updated: %s\n", mod.Format("2006-01-02")))
+		}
+		sb.WriteString(fmt.Sprintf("Excerpt:\n%s\n", h.Chunk.Text))
 	}
 	sb.WriteString("\nAnswer:\n")
 	return sb.String()
@@ -365,33 +555,56 @@ func BuildPrompt(question string, hits []ScoredChunk) string {
 
 // ---------------- Cache ----------------
 
+// LoadCache reads the embeddings cache. A missing file yields an empty cache;
+// single-model caches (version <= 2) are migrated in memory.
 func LoadCache(path string) (*EmbedCache, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &EmbedCache{Items: map[string]EmbedCacheItem{}}, nil
+			return &EmbedCache{Models: map[string]*ModelVectors{}}, nil
 		}
 		return nil, err
 	}
-	var c EmbedCache
+	var c struct {
+		EmbedCache
+		// Single-model layout, before models were stored side by side.
+		Model string `json:"model"`
+		ModelVectors
+	}
 	if err := json.Unmarshal(b, &c); err != nil {
 		return nil, err
 	}
-	if c.Items == nil {
-		c.Items = map[string]EmbedCacheItem{}
+	cache := &c.EmbedCache
+	if cache.Models == nil {
+		cache.Models = map[string]*ModelVectors{}
+	}
+	if len(c.ModelVectors.Items) > 0 && cache.Models[c.Model] == nil {
+		legacy := c.ModelVectors
+		cache.Models[c.Model] = &legacy
 	}
-	return &c, nil
+	for _, mv := range cache.Models {
+		if mv.Items == nil {
+			mv.Items = map[string]EmbedCacheItem{}
+		}
+	}
+	return cache, nil
 }
 
 func SaveCache(path string, c *EmbedCache) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
+	c.Version = CacheVersion
 	b, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, b, 0o644)
+	// Write then rename so a reloading server never reads a partial file.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 func TextHash(s string) string {
@@ -0,0 +1,95 @@
+package rag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// EmbedTemplate renders the text sent to the embedding model for a chunk's
+// body vector. Templates execute against Chunk, so they can use .Title, .URL,
+// .Text, .Categories, .Section, .DocType, .Slug and .ModifiedGMT.
+//
+// The cache hash covers the rendered input and the template ID, so editing a
+// title, the template text or bumping Version re-embeds the affected chunks.
+type EmbedTemplate struct {
+	Name    string
+	Version int
+	Text    string
+
+	tmpl *template.Template
+}
+
+// DefaultEmbedTemplate is the original title/url/text layout.
+const DefaultEmbedTemplate = "default"
+
+var builtinEmbedTemplates = map[string]struct {
+	version int
+	text    string
+}{
+	DefaultEmbedTemplate: {version: 1, text: "{{.Title}}\n{{.URL}}\n\n{{.Text}}"},
+	"structured": {version: 1, text: "Title: {{.Title}}\n" +
+		"{{with .Categories}}Categories: {{join . \", \"}}\n{{end}}" +
+		"{{with .Section}}Section: {{.}}\n{{end}}" +
+		"URL: {{.URL}}\n\n{{.Text}}"},
+	"title-text": {version: 1, text: "{{.Title}}\n\n{{.Text}}"},
+}
+
+// EmbedTemplateNames lists the built-in templates, sorted.
+func EmbedTemplateNames() []string {
+	names := make([]string, 0, len(builtinEmbedTemplates))
+	for n := range builtinEmbedTemplates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupEmbedTemplate returns a built-in template by name ("" means default).
+func LookupEmbedTemplate(name string) (EmbedTemplate, error) {
+	if name == "" {
+		name = DefaultEmbedTemplate
+	}
+	b, ok := builtinEmbedTemplates[name]
+	if !ok {
+		return EmbedTemplate{}, fmt.Errorf("unknown embed template %q (have %s)", name, strings.Join(EmbedTemplateNames(), ", "))
+	}
+	return ParseEmbedTemplate(name, b.version, b.text)
+}
+
+// ParseEmbedTemplate compiles a custom template and checks it renders.
+func ParseEmbedTemplate(name string, version int, text string) (EmbedTemplate, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).Parse(text)
+	if err != nil {
+		return EmbedTemplate{}, fmt.Errorf("embed template %s: %w", name, err)
+	}
+	et := EmbedTemplate{Name: name, Version: version, Text: text, tmpl: t}
+	if _, err := et.Render(Chunk{Title: "t", URL: "u", Text: "x", Categories: []string{"c"}, Section: "s"}); err != nil {
+		return EmbedTemplate{}, err
+	}
+	return et, nil
+}
+
+// ID identifies the template in cache metadata, e.g. "default@v1".
+func (t EmbedTemplate) ID() string {
+	return fmt.Sprintf("%s@v%d", t.Name, t.Version)
+}
+
+// Render executes the template for a chunk. A zero EmbedTemplate renders the default layout.
+func (t EmbedTemplate) Render(ch Chunk) (string, error) {
+	if t.tmpl == nil {
+		def, err := LookupEmbedTemplate(DefaultEmbedTemplate)
+		if err != nil {
+			return "", err
+		}
+		t = def
+	}
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, ch); err != nil {
+		return "", fmt.Errorf("embed template %s: %w", t.Name, err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,47 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmbedTemplateRenderAndHash(t *testing.T) {
+	ch := Chunk{ChunkID: "bus-1", Title: "Airport bus", URL: "https://a", Text: "C6 route.", Categories: []string{"Transport", "Airport"}, Section: "Prices"}
+
+	def, err := LookupEmbedTemplate("")
+	if err != nil {
+		t.Fatalf("lookup default: %v", err)
+	}
+	got, err := def.Render(ch)
+	if err != nil || got != "Airport bus\nhttps://a\n\nC6 route." {
+		t.Fatalf("unexpected default render %q (%v)", got, err)
+	}
+
+	structured, err := LookupEmbedTemplate("structured")
+	if err != nil {
+		t.Fatalf("lookup structured: %v", err)
+	}
+	got, err = structured.Render(ch)
+	if err != nil || !strings.Contains(got, "Categories: Transport, Airport\n") || !strings.Contains(got, "Section: Prices\n") {
+		t.Fatalf("unexpected structured render %q (%v)", got, err)
+	}
+
+	base := testItemHash(t, KindBody, ch)
+	renamed := ch
+	renamed.Title = "Alicante airport bus"
+	if testItemHash(t, KindBody, renamed) == base {
+		t.Fatalf("title change should change the body hash")
+	}
+	bumped, _ := ParseEmbedTemplate(DefaultEmbedTemplate, 2, def.Text)
+	input, _ := KindInput(KindBody, ch, bumped)
+	if itemHash(KindBody, input, bumped) == base {
+		t.Fatalf("template version bump should change the body hash")
+	}
+
+	if _, err := LookupEmbedTemplate("nope"); err == nil {
+		t.Fatalf("expected unknown template error")
+	}
+	if _, err := ParseEmbedTemplate("bad", 1, "{{.Nope}}"); err == nil {
+		t.Fatalf("expected render error for unknown field")
+	}
+}
@@ -0,0 +1,177 @@
+package rag
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Vector kinds stored per chunk. Body is the original full-text vector and
+// keeps the bare chunk_id as its cache key for backward compatibility.
+const (
+	KindBody    = "body"
+	KindTitle   = "title"
+	KindSummary = "summary"
+)
+
+// CacheVersion is the embeddings cache schema version written by SaveCache.
+// v1 had body vectors only; v2 adds per-kind items and the kinds table;
+// v3 stores each model's vectors side by side under "models".
+const CacheVersion = 3
+
+// KindVersions is bumped when the input a kind embeds changes, so stale
+// vectors of that kind are re-embedded.
+var KindVersions = map[string]int{
+	KindBody:    1,
+	KindTitle:   1,
+	KindSummary: 1,
+}
+
+const summaryChars = 600
+
+// VectorWeights maps vector kinds to their weight in the aggregated score.
+type VectorWeights map[string]float32
+
+// DefaultVectorWeights scores on the body vector alone; title and summary
+// vectors are opt-in because every extra kind is another embedding per chunk.
+var DefaultVectorWeights = VectorWeights{KindBody: 1}
+
+// ParseVectorWeights parses "body=1,title=0.3,summary=0.3".
+func ParseVectorWeights(s string) (VectorWeights, error) {
+	w := VectorWeights{}
+	for _, part := range splitList(s) {
+		kind, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("vector weights: bad term %q (want kind=weight)", part)
+		}
+		kind = strings.TrimSpace(kind)
+		if _, known := KindVersions[kind]; !known {
+			return nil, fmt.Errorf("vector weights: unknown kind %q", kind)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 32)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("vector weights: bad weight for %s: %q", kind, val)
+		}
+		w[kind] = float32(f)
+	}
+	return w, nil
+}
+
+// String renders weights in ParseVectorWeights syntax, sorted by kind.
+func (w VectorWeights) String() string {
+	kinds := make([]string, 0, len(w))
+	for k := range w {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = k + "=" + strconv.FormatFloat(float64(w[k]), 'f', -1, 32)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Kinds returns the kinds with a positive weight, body first.
+func (w VectorWeights) Kinds() []string {
+	var kinds []string
+	for _, k := range []string{KindBody, KindTitle, KindSummary} {
+		if w[k] > 0 {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// ItemKey is the cache key for a chunk's vector of the given kind.
+func ItemKey(kind, chunkID string) string {
+	if kind == "" || kind == KindBody {
+		return chunkID
+	}
+	return chunkID + "#" + kind
+}
+
+// KindInput returns the text embedded for a chunk's vector of the given kind.
+// Body vectors are rendered with tmpl; title and summary have fixed inputs.
+func KindInput(kind string, ch Chunk, tmpl EmbedTemplate) (string, error) {
+	switch kind {
+	case KindTitle:
+		return ch.Title, nil
+	case KindSummary:
+		return ch.Title + "\n\n" + ExtractiveSummary(ch.Text, summaryChars), nil
+	default:
+		return tmpl.Render(ch)
+	}
+}
+
+// itemHash is the staleness hash for an item: the rendered input plus the
+// template ID (body) or kind version (title, summary).
+func itemHash(kind string, input string, tmpl EmbedTemplate) string {
+	if kind == "" || kind == KindBody {
+		return TextHash(tmpl.ID() + "\n" + input)
+	}
+	return TextHash(fmt.Sprintf("%s:v%d\n%s", kind, KindVersions[kind], input))
+}
+
+// ExtractiveSummary returns the lead of text: whole lines up to max chars,
+// skipping short navigation lines ("Contents", "Toggle").
+func ExtractiveSummary(text string, max int) string {
+	var sb strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 40 {
+			continue
+		}
+		if sb.Len() > 0 && sb.Len()+len(line)+1 > max {
+			break
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(line)
+		if sb.Len() >= max {
+			break
+		}
+	}
+	return TruncateRunes(sb.String(), max, "")
+}
+
+// Score aggregates the weighted dot products of q with the entry's vectors,
+// normalised over every configured kind: a kind the entry lacks counts as 0,
+// so partly embedded entries stay on the same scale as complete ones and the
+// gate thresholds mean the same for both. Nil or body-only weights score
+// exactly as plain cosine similarity.
+func (e Entry) Score(q []float32, weights VectorWeights) float32 {
+	if len(weights) == 0 {
+		return Dot(q, e.Vec)
+	}
+	var sum, total float32
+	for _, kind := range []string{KindBody, KindTitle, KindSummary} {
+		w := weights[kind]
+		if w <= 0 {
+			continue
+		}
+		total += w
+		v := e.Vec
+		if kind != KindBody {
+			v = e.KindVecs[kind]
+		}
+		if len(v) > 0 {
+			sum += w * Dot(q, v)
+		}
+	}
+	if total == 0 {
+		return Dot(q, e.Vec)
+	}
+	return sum / total
+}
+
+// TruncateRunes cuts s to its first max runes, appending tail (e.g. "…")
+// only if something was cut.
+func TruncateRunes(s string, max int, tail string) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max]) + tail
+}
@@ -0,0 +1,72 @@
+package rag
+
+import "testing"
+
+func TestParseVectorWeights(t *testing.T) {
+	w, err := ParseVectorWeights("body=1, title=0.5")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if w[KindBody] != 1 || w[KindTitle] != 0.5 {
+		t.Fatalf("unexpected weights: %v", w)
+	}
+	if kinds := w.Kinds(); len(kinds) != 2 || kinds[0] != KindBody {
+		t.Fatalf("unexpected kinds: %v", kinds)
+	}
+	for _, bad := range []string{"body", "colour=1", "title=-1"} {
+		if _, err := ParseVectorWeights(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestBuildIndexMultiVector(t *testing.T) {
+	chunks := []Chunk{
+		{ChunkID: "bus", Title: "Alicante Airport Bus", Text: "Long body about many things."},
+		{ChunkID: "tram", Title: "Tram", Text: "Body only."},
+	}
+	cache := &EmbedCache{Models: map[string]*ModelVectors{"m": {Items: map[string]EmbedCacheItem{
+		"bus":       {ID: "bus", Hash: testItemHash(t, KindBody, chunks[0]), Dim: 2, Vector: []float32{0, 1}},
+		"bus#title": {ID: "bus", Kind: KindTitle, Hash: testItemHash(t, KindTitle, chunks[0]), Dim: 2, Vector: []float32{1, 0}},
+		"tram":      {ID: "tram", Hash: testItemHash(t, KindBody, chunks[1]), Dim: 2, Vector: []float32{0.6, 0.8}},
+	}}}}
+	entries := BuildIndex(chunks, cache, "m")
+	if len(entries) != 2 || entries[0].KindVecs[KindTitle] == nil || entries[1].KindVecs != nil {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	q := []float32{1, 0}
+	body := TopKSearch(entries, q, 2, Filter{})
+	if body[0].Chunk.ChunkID != "tram" {
+		t.Fatalf("body-only search should prefer tram, got %s", body[0].Chunk.ChunkID)
+	}
+	weighted := TopKSearchWeighted(entries, q, 2, Filter{}, VectorWeights{KindBody: 1, KindTitle: 3})
+	if weighted[0].Chunk.ChunkID != "bus" {
+		t.Fatalf("title vector should lift bus, got %s", weighted[0].Chunk.ChunkID)
+	}
+	if d := weighted[1].Score - body[0].Score/4; d > 1e-6 || d < -1e-6 {
+		t.Fatalf("a missing title vector should count as 0: %.3f vs %.3f", weighted[1].Score, body[0].Score/4)
+	}
+	plain := TopKSearchWeighted(entries, q, 2, Filter{}, DefaultVectorWeights)
+	if plain[0].Chunk.ChunkID != "tram" || plain[0].Score != body[0].Score {
+		t.Fatalf("default weights should score as plain cosine, got %s %.3f", plain[0].Chunk.ChunkID, plain[0].Score)
+	}
+
+	n, err := MissingEmbeddings(chunks, cache, "m", EmbedOptions{Kinds: []string{KindBody, KindTitle, KindSummary}})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 missing vectors (tram title, both summaries), got %d (%v)", n, err)
+	}
+}
+
+func testItemHash(t *testing.T, kind string, ch Chunk) string {
+	t.Helper()
+	tmpl, err := LookupEmbedTemplate("")
+	if err != nil {
+		t.Fatalf("template: %v", err)
+	}
+	input, err := KindInput(kind, ch, tmpl)
+	if err != nil {
+		t.Fatalf("input: %v", err)
+	}
+	return itemHash(kind, input, tmpl)
+}
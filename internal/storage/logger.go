@@ -16,6 +16,15 @@ type ChatLog struct {
 	TopSources       []string
 	TopScores        []float32
 	LatencyMs        int
+	// GateReason and GateSignals (JSON) record why retrieval was accepted
+	// or declined; empty when the request was never gated.
+	GateReason  string
+	GateSignals string
+	// Model is the "provider:model" that answered, after any fallback.
+	Model string
+	// Faithfulness is the share of answer claims the sources support; nil
+	// when the answer was not verified.
+	Faithfulness *float32
 }
 
 type Logger interface {
@@ -128,13 +137,16 @@ func (l *AsyncLogger) insertBatch(ctx context.Context, records []ChatLog) error
 
 func buildInsert(records []ChatLog) (string, []any) {
 	values := make([]string, 0, len(records))
-	args := make([]any, 0, len(records)*6)
-	for i, rec := range records {
-		base := i*6 + 1
-		values = append(values, fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d)", base, base+1, base+2, base+3, base+4, base+5))
-		args = append(args, rec.QuestionRedacted, rec.QuestionHash, rec.AnswerType, rec.TopSources, rec.TopScores, rec.LatencyMs)
+	args := make([]any, 0, len(records)*len(chatLogColumns))
+	for _, rec := range records {
+		placeholders := make([]string, len(chatLogColumns))
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+		}
+		values = append(values, "("+join(placeholders, ",")+")")
+		args = append(args, rec.QuestionRedacted, rec.QuestionHash, rec.AnswerType, rec.TopSources, rec.TopScores, rec.LatencyMs, nullString(rec.GateReason), nullString(rec.GateSignals), nullString(rec.Model), nullFloat(rec.Faithfulness))
 	}
-	query := "INSERT INTO chat_logs (question_redacted, question_hash, answer_type, top_sources, top_scores, latency_ms) VALUES " + join(values, ",")
+	query := "INSERT INTO chat_logs (" + join(chatLogColumns, ", ") + ") VALUES " + join(values, ",")
 	return query, args
 }
 
@@ -168,3 +180,21 @@ func logDropped(dropped uint64) {
 	// Using fmt here to avoid pulling log into this package.
 	fmt.Printf("chat_logger dropped=%d\n", dropped)
 }
+
+// chatLogColumns lists the insert columns in the order buildInsert appends
+// its arguments.
+var chatLogColumns = []string{"question_redacted", "question_hash", "answer_type", "top_sources", "top_scores", "latency_ms", "gate_reason", "gate_signals", "model", "faithfulness"}
+
+func nullFloat(f *float32) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
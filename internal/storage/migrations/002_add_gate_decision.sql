@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE chat_logs ADD COLUMN gate_reason text;
+ALTER TABLE chat_logs ADD COLUMN gate_signals jsonb;
+
+CREATE INDEX chat_logs_gate_reason_idx ON chat_logs (gate_reason);
+
+-- +goose Down
+DROP INDEX chat_logs_gate_reason_idx;
+ALTER TABLE chat_logs DROP COLUMN gate_signals;
+ALTER TABLE chat_logs DROP COLUMN gate_reason;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE chat_sessions (
+  id_hash text PRIMARY KEY,
+  turns jsonb NOT NULL DEFAULT '[]',
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  expires_at timestamptz NOT NULL
+);
+
+CREATE INDEX chat_sessions_expires_at_idx ON chat_sessions (expires_at);
+
+-- +goose Down
+DROP TABLE chat_sessions;
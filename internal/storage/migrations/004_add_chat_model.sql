@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE chat_logs ADD COLUMN model text;
+
+CREATE INDEX chat_logs_model_idx ON chat_logs (model);
+
+-- +goose Down
+DROP INDEX chat_logs_model_idx;
+ALTER TABLE chat_logs DROP COLUMN model;
@@ -0,0 +1,12 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+ALTER TYPE answer_type ADD VALUE IF NOT EXISTS 'search_only';
+ALTER TYPE answer_type ADD VALUE IF NOT EXISTS 'lexical_only';
+
+-- +goose Down
+-- Enum values cannot be dropped; rebuild the type without them.
+UPDATE chat_logs SET answer_type = 'no_answer' WHERE answer_type IN ('search_only', 'lexical_only');
+ALTER TYPE answer_type RENAME TO answer_type_old;
+CREATE TYPE answer_type AS ENUM ('grounded', 'no_answer');
+ALTER TABLE chat_logs ALTER COLUMN answer_type TYPE answer_type USING answer_type::text::answer_type;
+DROP TYPE answer_type_old;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE chat_logs ADD COLUMN faithfulness real;
+
+-- +goose Down
+ALTER TABLE chat_logs DROP COLUMN faithfulness;
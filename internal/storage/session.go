@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned for unknown or expired sessions.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionTurn is one message of a conversation. Content of both roles must
+// already be redacted: stores never see raw user text, nor answers that
+// repeat it.
+type SessionTurn struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Sources []string  `json:"sources,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Session is a conversation keyed by an opaque, server-issued id.
+type Session struct {
+	ID        string
+	Turns     []SessionTurn
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SessionStore keeps conversations until they expire or are deleted.
+type SessionStore interface {
+	// Get returns ErrSessionNotFound for unknown or expired ids.
+	Get(ctx context.Context, id string) (*Session, error)
+	Save(ctx context.Context, s *Session) error
+	// Delete is idempotent: deleting an unknown id is not an error.
+	Delete(ctx context.Context, id string) error
+	// Sweep removes expired sessions and reports how many were removed.
+	Sweep(ctx context.Context) (int, error)
+}
+
+// MemorySessionStore is an in-process SessionStore. Beyond max sessions the
+// least recently used ones are evicted first.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	max      int
+	sessions map[string]*list.Element // values are *Session
+	lru      *list.List               // most recently used at the front
+	now      func() time.Time
+}
+
+func NewMemorySessionStore(max int) *MemorySessionStore {
+	return &MemorySessionStore{
+		max:      max,
+		sessions: make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+func (m *MemorySessionStore) Get(_ context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	s := e.Value.(*Session)
+	if !s.ExpiresAt.After(m.now()) {
+		m.removeLocked(e)
+		return nil, ErrSessionNotFound
+	}
+	m.lru.MoveToFront(e)
+	return cloneSession(s), nil
+}
+
+func (m *MemorySessionStore) Save(_ context.Context, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.sessions[s.ID]; ok {
+		e.Value = cloneSession(s)
+		m.lru.MoveToFront(e)
+	} else {
+		m.sessions[s.ID] = m.lru.PushFront(cloneSession(s))
+	}
+	if m.max > 0 && len(m.sessions) > m.max {
+		m.sweepLocked()
+		for len(m.sessions) > m.max {
+			m.removeLocked(m.lru.Back())
+		}
+	}
+	return nil
+}
+
+func (m *MemorySessionStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.sessions[id]; ok {
+		m.removeLocked(e)
+	}
+	return nil
+}
+
+func (m *MemorySessionStore) Sweep(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sweepLocked(), nil
+}
+
+func (m *MemorySessionStore) sweepLocked() int {
+	now := m.now()
+	n := 0
+	for _, e := range m.sessions {
+		if !e.Value.(*Session).ExpiresAt.After(now) {
+			m.removeLocked(e)
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MemorySessionStore) removeLocked(e *list.Element) {
+	delete(m.sessions, e.Value.(*Session).ID)
+	m.lru.Remove(e)
+}
+
+func cloneSession(s *Session) *Session {
+	out := *s
+	out.Turns = make([]SessionTurn, len(s.Turns))
+	for i, t := range s.Turns {
+		t.Sources = append([]string(nil), t.Sources...)
+		out.Turns[i] = t
+	}
+	return &out
+}
+
+// PostgresSessionStore keeps sessions in chat_sessions. Rows are keyed by
+// the SHA-256 of the session id, so a database dump can't be replayed
+// against the API.
+type PostgresSessionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresSessionStore(db *sql.DB) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db}
+}
+
+func (p *PostgresSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	var raw []byte
+	s := &Session{ID: id}
+	err := p.db.QueryRowContext(ctx,
+		"SELECT turns, created_at, expires_at FROM chat_sessions WHERE id_hash = $1 AND expires_at > now()",
+		sessionKey(id)).Scan(&raw, &s.CreatedAt, &s.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.Turns); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *PostgresSessionStore) Save(ctx context.Context, s *Session) error {
+	turns, err := json.Marshal(s.Turns)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx,
+		`INSERT INTO chat_sessions (id_hash, turns, created_at, updated_at, expires_at) VALUES ($1, $2, $3, now(), $4)
+ON CONFLICT (id_hash) DO UPDATE SET turns = EXCLUDED.turns, updated_at = now(), expires_at = EXCLUDED.expires_at`,
+		sessionKey(s.ID), string(turns), s.CreatedAt, s.ExpiresAt)
+	return err
+}
+
+func (p *PostgresSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM chat_sessions WHERE id_hash = $1", sessionKey(id))
+	return err
+}
+
+func (p *PostgresSessionStore) Sweep(ctx context.Context) (int, error) {
+	res, err := p.db.ExecContext(ctx, "DELETE FROM chat_sessions WHERE expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func sessionKey(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(h[:])
+}
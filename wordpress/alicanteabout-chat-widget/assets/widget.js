@@ -16,6 +16,7 @@
   let sendBtn;
   let tokenCache = null;
   let tokenExpiresAt = 0;
+  let conversationId = "";
 
   function buildModal() {
     overlay = document.createElement("div");
@@ -93,10 +94,25 @@
     if (messagesEl) {
       messagesEl.innerHTML = "";
     }
+    forgetConversation();
     tokenCache = null;
     tokenExpiresAt = 0;
   }
 
+  // Ask the server to delete the session; best-effort, it expires anyway.
+  function forgetConversation() {
+    const id = conversationId;
+    conversationId = "";
+    if (!id || !tokenCache) {
+      return;
+    }
+    fetch(apiUrl + "/session?conversation_id=" + encodeURIComponent(id), {
+      method: "DELETE",
+      headers: { Authorization: "Bearer " + tokenCache },
+      keepalive: true,
+    }).catch(() => {});
+  }
+
   function onInputKey(e) {
     if (e.key === "Enter" && !e.shiftKey) {
       e.preventDefault();
@@ -172,7 +188,11 @@
           Accept: "text/event-stream",
           Authorization: "Bearer " + token,
         },
-        body: JSON.stringify({ question, lang: "en" }),
+        body: JSON.stringify({
+          question,
+          lang: "en",
+          conversation_id: conversationId || undefined,
+        }),
       });
       if (res.status === 401 && !retry) {
         tokenCache = null;
@@ -183,12 +203,16 @@
       if (!res.ok || !res.body) {
         throw new Error("chat request failed");
       }
+      conversationId = res.headers.get("X-Conversation-ID") || conversationId;
 
       const contentType = (res.headers.get("Content-Type") || "").toLowerCase();
       if (!contentType.includes("text/event-stream")) {
         const payload = await res.json();
         if (payload && payload.answer) {
           setMessageText(assistantMsg, payload.answer);
+          if (Array.isArray(payload.citations) && payload.citations.length) {
+            setCitations(assistantMsg, payload.answer, payload.citations);
+          }
         }
         if (payload && payload.sources) {
           setSources(assistantMsg, payload.sources);
@@ -243,20 +267,29 @@
       } catch (err) {
         delta = data;
       }
+      // Deltas are plain answer text; the result event replaces it.
       state.buffer += delta;
-      const answer = extractAnswerFromBuffer(state.buffer);
-      if (answer !== null) {
-        setMessageText(assistantMsg, answer);
+      setMessageText(assistantMsg, state.buffer);
+    } else if (event === "sources") {
+      try {
+        const payload = JSON.parse(data);
+        if (payload && payload.sources) {
+          setSources(assistantMsg, payload.sources);
+        }
+      } catch (err) {
+        return;
       }
     } else if (event === "result") {
       try {
         const payload = JSON.parse(data);
         if (payload.answer) {
           setMessageText(assistantMsg, payload.answer);
+          if (Array.isArray(payload.citations) && payload.citations.length) {
+            setCitations(assistantMsg, payload.answer, payload.citations);
+          }
         }
-        if (payload.sources) {
-          setSources(assistantMsg, payload.sources);
-        }
+        // Replaces any early "sources" event, also when there are none.
+        setSources(assistantMsg, payload.sources);
       } catch (err) {
         return;
       }
@@ -277,6 +310,47 @@
     }
   }
 
+  // setCitations renders the answer with footnote markers after each cited
+  // span. Offsets are in code points, hence Array.from.
+  function setCitations(msg, answer, citations) {
+    const el = msg.querySelector(".aat-chat-message-text") || msg;
+    const chars = Array.from(answer);
+    const marks = {};
+    citations.forEach((c) => {
+      if (typeof c.end !== "number" || c.end < 0 || c.end > chars.length) {
+        return;
+      }
+      marks[c.end] = marks[c.end] || [];
+      if (!marks[c.end].some((m) => m.source === c.source)) {
+        marks[c.end].push(c);
+      }
+    });
+    el.textContent = "";
+    let last = 0;
+    Object.keys(marks)
+      .map(Number)
+      .sort((a, b) => a - b)
+      .forEach((end) => {
+        el.appendChild(document.createTextNode(chars.slice(last, end).join("")));
+        marks[end].forEach((c) => {
+          const sup = document.createElement("sup");
+          sup.className = "aat-chat-cite";
+          const link = document.createElement("a");
+          link.href = c.url;
+          link.target = "_blank";
+          link.rel = "noopener";
+          link.textContent = "[" + c.source + "]";
+          if (c.excerpt) {
+            link.title = c.excerpt;
+          }
+          sup.appendChild(link);
+          el.appendChild(sup);
+        });
+        last = end;
+      });
+    el.appendChild(document.createTextNode(chars.slice(last).join("")));
+  }
+
   function setTyping(msg, active) {
     if (active) {
       msg.classList.add("aat-typing");
@@ -297,7 +371,8 @@
     const title = document.createElement("div");
     title.className = "aat-chat-sources-title";
     title.textContent = "Related articles";
-    const list = document.createElement("ul");
+    // Numbered so footnote markers [n] match the list.
+    const list = document.createElement("ol");
     list.className = "aat-chat-sources-list";
     sources.forEach((src) => {
       const li = document.createElement("li");
@@ -307,87 +382,18 @@
       link.rel = "noopener";
       link.textContent = src.title || src.url || "Source";
       li.appendChild(link);
+      if (src.snippet) {
+        const snippet = document.createElement("p");
+        snippet.className = "aat-chat-source-snippet";
+        snippet.textContent = src.snippet;
+        li.appendChild(snippet);
+      }
       list.appendChild(li);
     });
     el.appendChild(title);
     el.appendChild(list);
   }
 
-  function extractAnswerFromBuffer(buffer) {
-    try {
-      const parsed = JSON.parse(buffer);
-      if (parsed && typeof parsed.answer === "string") {
-        return parsed.answer;
-      }
-    } catch (err) {
-      // fall through to partial extraction
-    }
-    const keyIndex = buffer.indexOf('"answer"');
-    if (keyIndex === -1) {
-      return null;
-    }
-    let i = buffer.indexOf(":", keyIndex);
-    if (i === -1) {
-      return null;
-    }
-    i += 1;
-    while (i < buffer.length && /\s/.test(buffer[i])) {
-      i += 1;
-    }
-    if (buffer[i] !== '"') {
-      return null;
-    }
-    i += 1;
-    let out = "";
-    let escaped = false;
-    for (; i < buffer.length; i += 1) {
-      const ch = buffer[i];
-      if (escaped) {
-        switch (ch) {
-          case "n":
-            out += "\n";
-            break;
-          case "t":
-            out += "\t";
-            break;
-          case "r":
-            out += "\r";
-            break;
-          case '"':
-            out += '"';
-            break;
-          case "\\":
-            out += "\\";
-            break;
-          case "u": {
-            const hex = buffer.slice(i + 1, i + 5);
-            if (/^[0-9a-fA-F]{4}$/.test(hex)) {
-              out += String.fromCharCode(parseInt(hex, 16));
-              i += 4;
-            } else {
-              out += "u";
-            }
-            break;
-          }
-          default:
-            out += ch;
-            break;
-        }
-        escaped = false;
-        continue;
-      }
-      if (ch === "\\") {
-        escaped = true;
-        continue;
-      }
-      if (ch === '"') {
-        return out;
-      }
-      out += ch;
-    }
-    return out;
-  }
-
   function escapeHtml(str) {
     return String(str)
       .replace(/&/g, "&amp;")